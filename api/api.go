@@ -4,10 +4,18 @@ package api
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/polldo/patweb/api/audit"
+	"github.com/polldo/patweb/api/cidr"
+	"github.com/polldo/patweb/api/concurrency"
 	"github.com/polldo/patweb/api/handler"
+	"github.com/polldo/patweb/api/ipfilter"
+	"github.com/polldo/patweb/api/maintenance"
+	"github.com/polldo/patweb/api/metrics"
 	"github.com/polldo/patweb/api/middleware"
+	"github.com/polldo/patweb/api/trace"
 	"github.com/polldo/patweb/api/web"
 	"github.com/sirupsen/logrus"
 )
@@ -15,6 +23,81 @@ import (
 // APIConfig contains all the mandatory dependencies required by handlers.
 type APIConfig struct {
 	Log logrus.FieldLogger
+
+	// LogOptions configure the access log of the requests.
+	LogOptions []middleware.LoggerOpt
+
+	// TrustedProxies, if set, are the networks of the proxies whose
+	// forwarding headers are used to resolve the address of clients.
+	TrustedProxies cidr.Set
+
+	// ProxyHeader is the header the trusted proxies set with the address
+	// of clients, X-Forwarded-For if empty. No other header is read.
+	ProxyHeader string
+
+	// IPFilter, if set, rejects the clients it doesn't allow.
+	// Routes can add their own lists passing middleware.IPFilter to Handle.
+	IPFilter *ipfilter.List
+
+	// Maintenance, if set, rejects the requests while it's enabled.
+	// Routes can join groups passing middleware.Maintenance to Handle.
+	Maintenance        *maintenance.Switch
+	MaintenanceOptions []middleware.MaintenanceOpt
+
+	// MaintenancePath, if set together with Maintenance, is the path of the
+	// administration endpoint of the maintenance, protected by MaintenanceAdmin,
+	// like middleware.IPFilter. The endpoint is not registered without them.
+	MaintenancePath  string
+	MaintenanceAdmin []web.Middleware
+
+	// Tracer, if set, enables the tracing of requests.
+	Tracer *trace.Tracer
+
+	// Metrics, if set, is the registry where request metrics are recorded.
+	// Handlers can use it to register their own metrics.
+	Metrics metrics.Registry
+
+	// MetricsPath, if set together with Metrics, is the path where
+	// metrics are exposed in the Prometheus text format.
+	MetricsPath string
+
+	// Timeout, if set, is the maximum duration of each request.
+	// Routes can set a shorter timeout passing middleware.Timeout to Handle.
+	Timeout time.Duration
+
+	// MaxBodyBytes, if set, is the maximum size of request bodies.
+	// Routes can set a different limit passing middleware.BodyLimit to Handle.
+	MaxBodyBytes int64
+
+	// Compress enables the compression of responses.
+	Compress bool
+
+	// CORS, if set, is the policy applied to cross-origin requests.
+	// Preflight requests are answered for every registered path.
+	CORS *middleware.CORSConfig
+
+	// Concurrency, if set, limits the requests processed at the same time.
+	// Its state is exposed with Metrics, if set.
+	Concurrency *concurrency.Limiter
+
+	// Audit, if set, is the sink of the audit trail of the requests.
+	// Handlers record audit events with web.Audit.
+	Audit audit.Sink
+
+	// Security, if set, contains the security headers of the responses.
+	// Routes can override them passing middleware.Security to Handle.
+	Security *middleware.SecurityConfig
+
+	// Background runs the goroutines spawned by handlers with web.Go.
+	// Construct it with NewBackground and shut it down after the server
+	// to await them. If nil, a Background that is never awaited is used.
+	Background *web.Background
+}
+
+// NewBackground constructs a Background that logs panics
+// with the request and trace IDs of the spawning request.
+func NewBackground(log logrus.FieldLogger) *web.Background {
+	return web.NewBackground(log, web.WithLogFields(middleware.BackgroundLogFields))
 }
 
 // api represents our server api.
@@ -22,23 +105,109 @@ type api struct {
 	*mux.Router
 	mw  []web.Middleware
 	log logrus.FieldLogger
+
+	// paths and methods hold the registered paths and their methods,
+	// in order of registration.
+	paths   []string
+	methods map[string][]string
 }
 
 // APIMux constructs a http.Handler with all application routes defined.
 func APIMux(cfg APIConfig) http.Handler {
 	a := &api{
-		Router: mux.NewRouter(),
-		log:    cfg.Log,
+		Router:  mux.NewRouter(),
+		log:     cfg.Log,
+		methods: map[string][]string{},
+	}
+
+	if cfg.Background == nil {
+		cfg.Background = NewBackground(cfg.Log)
+	}
+
+	// An unprotected endpoint would let anyone take the API offline.
+	if cfg.Maintenance != nil && cfg.MaintenancePath != "" && len(cfg.MaintenanceAdmin) == 0 {
+		cfg.Log.Warn("maintenance endpoint not registered: MaintenanceAdmin is empty")
+		cfg.MaintenancePath = ""
 	}
 
 	// Setup the middleware common to each handler.
-	a.mw = append(a.mw, middleware.RequestID())
-	a.mw = append(a.mw, middleware.Logger(cfg.Log))
+	a.mw = append(a.mw, middleware.RequestID(middleware.WithRequestIDEcho(true)))
+	if cfg.TrustedProxies != nil {
+		var opts []middleware.RealIPOpt
+		if cfg.ProxyHeader != "" {
+			opts = append(opts, middleware.WithRealIPHeader(cfg.ProxyHeader))
+		}
+		a.mw = append(a.mw, middleware.RealIP(cfg.TrustedProxies, opts...))
+	}
+	a.mw = append(a.mw, middleware.Background(cfg.Background))
+	if cfg.Tracer != nil {
+		a.mw = append(a.mw, middleware.Trace(cfg.Tracer))
+	}
+	a.mw = append(a.mw, middleware.Logger(cfg.Log, cfg.LogOptions...))
+	if cfg.Compress {
+		a.mw = append(a.mw, middleware.Compress())
+	}
+	if cfg.Security != nil {
+		a.mw = append(a.mw, middleware.Security(*cfg.Security))
+	}
+	if cfg.Audit != nil {
+		a.mw = append(a.mw, middleware.Audit(cfg.Audit, cfg.Log))
+	}
 	a.mw = append(a.mw, middleware.Errors(cfg.Log))
+	if cfg.CORS != nil {
+		a.mw = append(a.mw, middleware.CORS(*cfg.CORS))
+	}
+	if cfg.Metrics != nil {
+		a.mw = append(a.mw, middleware.Metrics(cfg.Metrics))
+	}
+	if cfg.IPFilter != nil {
+		a.mw = append(a.mw, middleware.IPFilter(cfg.IPFilter))
+	}
+	if cfg.Maintenance != nil {
+		opts := cfg.MaintenanceOptions
+		if cfg.MaintenancePath != "" {
+			opts = append(opts, middleware.WithMaintenanceSkipPaths(cfg.MaintenancePath))
+		}
+		a.mw = append(a.mw, middleware.Maintenance(cfg.Maintenance, opts...))
+	}
 	a.mw = append(a.mw, middleware.Panics())
+	if cfg.Concurrency != nil {
+		a.mw = append(a.mw, middleware.ConcurrencyLimit(cfg.Concurrency, nil))
+		if cfg.Metrics != nil {
+			metrics.MustRegister(cfg.Metrics, cfg.Concurrency.Collectors("http_concurrency")...)
+		}
+	}
+	if cfg.MaxBodyBytes > 0 {
+		a.mw = append(a.mw, middleware.BodyLimit(cfg.MaxBodyBytes))
+	}
+	if cfg.Timeout > 0 {
+		a.mw = append(a.mw, middleware.Timeout(cfg.Timeout))
+	}
 
 	a.Handle(http.MethodPost, "/demo", handler.Demo())
 
+	if cfg.Security != nil && cfg.Security.CSPReportPath != "" {
+		a.Handle(http.MethodPost, cfg.Security.CSPReportPath, middleware.CSPReport(cfg.Log))
+	}
+
+	if cfg.Maintenance != nil && cfg.MaintenancePath != "" {
+		admin := middleware.MaintenanceAdmin(cfg.Maintenance, cfg.Log)
+		a.Handle(http.MethodGet, cfg.MaintenancePath, admin, cfg.MaintenanceAdmin...)
+		a.Handle(http.MethodPut, cfg.MaintenancePath, admin, cfg.MaintenanceAdmin...)
+		a.Handle(http.MethodDelete, cfg.MaintenancePath, admin, cfg.MaintenanceAdmin...)
+	}
+
+	// Answer preflight requests once all the routes are known.
+	if cfg.CORS != nil {
+		a.handlePreflights(*cfg.CORS)
+	}
+
+	// Metrics are served outside of the middleware chain,
+	// so that scrapes don't pollute logs and metrics.
+	if cfg.Metrics != nil && cfg.MetricsPath != "" {
+		a.Router.Handle(cfg.MetricsPath, metrics.Handler(cfg.Metrics)).Methods(http.MethodGet)
+	}
+
 	return a.Router
 }
 
@@ -57,7 +226,8 @@ func (a *api) Handle(method string, path string, handler web.Handler, mw ...web.
 
 		// Pull the context from the request and
 		// use it as a separate parameter.
-		ctx := r.Context()
+		// The request is stored to evaluate its conditional headers.
+		ctx := web.ContextWithRequest(r.Context(), r)
 
 		// Call the wrapped handler functions.
 		if err := handler(ctx, w, r); err != nil {
@@ -71,4 +241,25 @@ func (a *api) Handle(method string, path string, handler web.Handler, mw ...web.
 	})
 
 	a.Router.Handle(path, h).Methods(method)
+
+	if _, ok := a.methods[path]; !ok {
+		a.paths = append(a.paths, path)
+	}
+	a.methods[path] = append(a.methods[path], method)
+}
+
+// handlePreflights registers, for each path without an explicit OPTIONS
+// handler, a handler answering preflight requests with the registered methods.
+func (a *api) handlePreflights(cfg middleware.CORSConfig) {
+	for _, path := range a.paths {
+		methods := append([]string(nil), a.methods[path]...)
+		explicit := false
+		for _, m := range methods {
+			explicit = explicit || m == http.MethodOptions
+		}
+		if explicit {
+			continue
+		}
+		a.Handle(http.MethodOptions, path, middleware.Preflight(cfg, methods))
+	}
 }
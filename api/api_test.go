@@ -0,0 +1,175 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/polldo/patweb/api/cidr"
+	"github.com/polldo/patweb/api/ipfilter"
+	"github.com/polldo/patweb/api/maintenance"
+	"github.com/polldo/patweb/api/middleware"
+	"github.com/polldo/patweb/api/web"
+	"github.com/sirupsen/logrus"
+)
+
+func newTestMux(cfg APIConfig) http.Handler {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	cfg.Log = log
+	return APIMux(cfg)
+}
+
+func TestCORS(t *testing.T) {
+	mux := newTestMux(APIConfig{
+		CORS: &middleware.CORSConfig{
+			AllowedOrigins:   []string{"https://*.example.com"},
+			AllowCredentials: true,
+			ExposedHeaders:   []string{middleware.RequestIDHeader},
+		},
+	})
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodOptions, "/demo", nil)
+		r.Header.Set("Origin", origin)
+		r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		r.Header.Set("Access-Control-Request-Headers", "Content-Type")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	w := preflight("https://app.example.com")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("want status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	for h, want := range map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Methods":     "POST, OPTIONS",
+		"Access-Control-Allow-Headers":     "Content-Type",
+		"Access-Control-Allow-Credentials": "true",
+	} {
+		if got := w.Header().Get(h); got != want {
+			t.Errorf("want %s %q, got %q", h, want, got)
+		}
+	}
+
+	if w := preflight("https://evil.com"); w.Code != http.StatusForbidden {
+		t.Errorf("want status %d for disallowed origin, got %d", http.StatusForbidden, w.Code)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/demo", strings.NewReader(`{"Value":"ok"}`))
+	r.Header.Set("Origin", "https://app.example.com")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if got := w.Header().Get("Access-Control-Expose-Headers"); got != middleware.RequestIDHeader {
+		t.Errorf("want exposed headers on actual request, got %q", got)
+	}
+}
+
+func TestCORSAnyOriginCredentials(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("want panic allowing credentials from any origin")
+		}
+	}()
+	middleware.CORS(middleware.CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+}
+
+func TestBodyLimit(t *testing.T) {
+	mux := newTestMux(APIConfig{MaxBodyBytes: 16})
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"Value":"ok"}`, http.StatusOK},
+		{`{"Value":"` + strings.Repeat("a", 32) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/demo", strings.NewReader(tt.body))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("body of %d bytes: want status %d, got %d", len(tt.body), tt.status, w.Code)
+		}
+	}
+}
+
+func TestCSPReport(t *testing.T) {
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(&buf)
+	sec := middleware.DefaultSecurityConfig()
+	sec.CSPReportPath = "/csp-reports"
+	mux := APIMux(APIConfig{Log: log, Security: &sec})
+
+	report := `{"csp-report":{"document-uri":"https://example.com","violated-directive":"script-src"}}`
+	r := httptest.NewRequest(http.MethodPost, "/csp-reports", strings.NewReader(report))
+	r.Header.Set("Content-Type", "application/csp-report")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("want status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if !strings.Contains(w.Header().Get("Content-Security-Policy"), "report-uri /csp-reports") {
+		t.Errorf("want report-uri in policy, got %q", w.Header().Get("Content-Security-Policy"))
+	}
+	if !strings.Contains(buf.String(), "CSP violation") || !strings.Contains(buf.String(), "script-src") {
+		t.Errorf("want violation logged, got %q", buf.String())
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	sw := maintenance.NewSwitch(log)
+	admins := ipfilter.New(cidr.MustParse("192.0.2.0/24"), nil)
+	mux := newTestMux(APIConfig{
+		Maintenance:      sw,
+		MaintenancePath:  "/admin/maintenance",
+		MaintenanceAdmin: []web.Middleware{middleware.IPFilter(admins)},
+	})
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	r := httptest.NewRequest(http.MethodPut, "/admin/maintenance", strings.NewReader(`{"enabled":true}`))
+	r.RemoteAddr = "198.51.100.1:1234"
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden || sw.State().Enabled {
+		t.Fatalf("want admin endpoint guarded, got %d", w.Code)
+	}
+
+	w = serve(http.MethodPut, "/admin/maintenance", `{"enabled":true,"message":"migrating","retry_after":120}`)
+	if w.Code != http.StatusOK || !sw.State().Enabled {
+		t.Fatalf("want maintenance enabled, got %d %s", w.Code, w.Body)
+	}
+
+	w = serve(http.MethodPost, "/demo", `{"Value":"ok"}`)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "120" || !strings.Contains(w.Body.String(), "migrating") {
+		t.Fatalf("want 503 during maintenance, got %d %v %s", w.Code, w.Header(), w.Body)
+	}
+
+	if w := serve(http.MethodPut, "/admin/maintenance", `{"enabled":false}`); w.Code != http.StatusOK {
+		t.Fatalf("want admin endpoint reachable, got %d", w.Code)
+	}
+	if w := serve(http.MethodPost, "/demo", `{"Value":"ok"}`); w.Code == http.StatusServiceUnavailable {
+		t.Fatal("want maintenance disabled")
+	}
+
+	// Without guards the endpoint is not registered.
+	mux = newTestMux(APIConfig{Maintenance: sw, MaintenancePath: "/admin/maintenance"})
+	if w := serve(http.MethodPut, "/admin/maintenance", `{"enabled":true}`); w.Code == http.StatusOK || sw.State().Enabled {
+		t.Errorf("want unguarded endpoint not registered, got %d", w.Code)
+	}
+}
@@ -0,0 +1,62 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNDJSONChain(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "audit.ndjson")
+	key := []byte("audit-key")
+
+	s, err := OpenNDJSONFile(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Write(ctx, Event{Action: "order.create", Resource: "orders/1", After: map[string]int{"qty": 1}})
+	_ = s.Write(ctx, Event{Action: "order.update", Resource: "orders/1", Before: map[string]int{"qty": 1}, After: map[string]int{"qty": 2}})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The chain continues across reopenings.
+	s, err = OpenNDJSONFile(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Write(ctx, Event{Action: "order.delete", Resource: "orders/1"})
+	s.Close()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := Verify(bytes.NewReader(b), key); err != nil || n != 3 {
+		t.Fatalf("want 3 verified records, got %d %v", n, err)
+	}
+
+	tampered := strings.Replace(string(b), `"qty":2`, `"qty":20`, 1)
+	if n, err := Verify(strings.NewReader(tampered), key); !errors.Is(err, ErrTampered) || n != 1 {
+		t.Errorf("want tampering detected at line 2, got %d %v", n, err)
+	}
+
+	lines := strings.SplitAfter(string(b), "\n")
+	removed := lines[0] + lines[2]
+	if _, err := Verify(strings.NewReader(removed), key); !errors.Is(err, ErrTampered) {
+		t.Errorf("want removal detected, got %v", err)
+	}
+
+	// Without the key, a rewritten trail can't be chained again.
+	var forged bytes.Buffer
+	f := NewNDJSONSink(&forged, []byte("guessed-key"), "")
+	_ = f.Write(ctx, Event{Action: "order.create", Resource: "orders/1", After: map[string]int{"qty": 100}})
+	if _, err := Verify(&forged, key); !errors.Is(err, ErrTampered) {
+		t.Errorf("want forged chain detected, got %v", err)
+	}
+}
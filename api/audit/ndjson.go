@@ -0,0 +1,148 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// ErrTampered is returned by Verify when the chain of hashes is broken.
+var ErrTampered = errors.New("audit trail tampered")
+
+// record is a line of an NDJSON audit trail. Hash is the HMAC of PrevHash
+// and of the event as written, so that modifying, removing or reordering
+// lines breaks the chain.
+type record struct {
+	PrevHash string          `json:"prev_hash"`
+	Hash     string          `json:"hash"`
+	Event    json.RawMessage `json:"event"`
+}
+
+func chainHash(key []byte, prev string, event []byte) string {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(prev))
+	h.Write([]byte{'\n'})
+	h.Write(event)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// NDJSONSink writes each event as a JSON document on its own line,
+// chained to the previous one by an HMAC.
+type NDJSONSink struct {
+	mu   sync.Mutex
+	w    io.Writer
+	c    io.Closer
+	key  []byte
+	last string
+}
+
+// NewNDJSONSink constructs a sink writing to w a chain keyed by key,
+// starting after the given hash, empty for a new trail.
+// It panics if key is empty, since it's a programming error.
+func NewNDJSONSink(w io.Writer, key []byte, last string) *NDJSONSink {
+	if len(key) == 0 {
+		panic("audit: empty key")
+	}
+	return &NDJSONSink{w: w, key: key, last: last}
+}
+
+// OpenNDJSONFile constructs a sink appending to the file at path, which is
+// created if missing. The chain continues from the last line of the file.
+// The sink must be closed when done. It panics if key is empty.
+func OpenNDJSONFile(path string, key []byte) (*NDJSONSink, error) {
+	if len(key) == 0 {
+		panic("audit: empty key")
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open audit trail: %w", err)
+	}
+	last, err := lastHash(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	s := NewNDJSONSink(f, key, last)
+	s.c = f
+	return s, nil
+}
+
+// lastHash returns the hash of the last record of a trail.
+func lastHash(r io.Reader) (string, error) {
+	var last string
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 16<<20)
+	for sc.Scan() {
+		var rec record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return "", fmt.Errorf("cannot decode audit record: %w", err)
+		}
+		last = rec.Hash
+	}
+	if err := sc.Err(); err != nil {
+		return "", fmt.Errorf("cannot read audit trail: %w", err)
+	}
+	return last, nil
+}
+
+// Write implements the Sink interface.
+func (s *NDJSONSink) Write(ctx context.Context, e Event) error {
+	event, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("cannot encode audit event: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := record{PrevHash: s.last, Hash: chainHash(s.key, s.last, event), Event: event}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("cannot encode audit record: %w", err)
+	}
+	if _, err := s.w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("cannot write audit record: %w", err)
+	}
+	s.last = rec.Hash
+	return nil
+}
+
+// Close closes the underlying file, if any.
+func (s *NDJSONSink) Close() error {
+	if s.c == nil {
+		return nil
+	}
+	return s.c.Close()
+}
+
+// Verify checks the chain of an NDJSON trail written with key, returning an
+// error matching ErrTampered with the first broken line, and the number of
+// verified records.
+func Verify(r io.Reader, key []byte) (int, error) {
+	var prev string
+	n := 0
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 16<<20)
+	for sc.Scan() {
+		var rec record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return n, fmt.Errorf("%w: line %d: %v", ErrTampered, n+1, err)
+		}
+		if rec.PrevHash != prev || !hmac.Equal([]byte(rec.Hash), []byte(chainHash(key, prev, rec.Event))) {
+			return n, fmt.Errorf("%w: line %d", ErrTampered, n+1)
+		}
+		prev = rec.Hash
+		n++
+	}
+	if err := sc.Err(); err != nil {
+		return n, fmt.Errorf("cannot read audit trail: %w", err)
+	}
+	return n, nil
+}
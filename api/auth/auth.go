@@ -0,0 +1,53 @@
+// Package auth verifies API keys and passwords against hashed credentials.
+package auth
+
+import (
+	"context"
+	"errors"
+)
+
+// Kinds of principals, depending on how they authenticated.
+const (
+	KindAPIKey   = "apikey"
+	KindPassword = "password"
+	KindToken    = "token"
+)
+
+// ErrInvalidCredentials is returned when credentials don't match any principal.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Principal is an authenticated entity.
+type Principal struct {
+	// ID identifies the principal, like a user name or a service name.
+	ID string
+
+	// Kind tells how the principal authenticated.
+	Kind string
+
+	// Roles are the permissions granted to the principal.
+	Roles []string
+}
+
+// HasRole reports whether the principal has been granted the role.
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyVerifier verifies API keys.
+type KeyVerifier interface {
+	// VerifyAPIKey returns the principal owning the key.
+	// It fails with ErrInvalidCredentials if the key is unknown or expired.
+	VerifyAPIKey(ctx context.Context, key string) (*Principal, error)
+}
+
+// PasswordVerifier verifies user passwords.
+type PasswordVerifier interface {
+	// VerifyPassword returns the principal identified by user and password.
+	// It fails with ErrInvalidCredentials if they don't match.
+	VerifyPassword(ctx context.Context, user, password string) (*Principal, error)
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "creds.json")
+	s, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	if err := s.AddAPIKey("ci", "old-key", "deploy"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetPassword("admin", "s3cret", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RotateAPIKey("ci", "new-key", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reload from file to check persistence.
+	s, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return now }
+
+	for _, key := range []string{"old-key", "new-key"} {
+		p, err := s.VerifyAPIKey(ctx, key)
+		if err != nil || p.ID != "ci" || !p.HasRole("deploy") {
+			t.Errorf("key %q should be valid during the grace period, got %+v %v", key, p, err)
+		}
+	}
+	if _, err := s.VerifyAPIKey(ctx, "unknown"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("want invalid credentials, got %v", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, err := s.VerifyAPIKey(ctx, "old-key"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("old key should be expired after the grace period, got %v", err)
+	}
+	if _, err := s.VerifyAPIKey(ctx, "new-key"); err != nil {
+		t.Errorf("new key should be valid, got %v", err)
+	}
+
+	if p, err := s.VerifyPassword(ctx, "admin", "s3cret"); err != nil || p.ID != "admin" {
+		t.Errorf("want valid password, got %+v %v", p, err)
+	}
+	if _, err := s.VerifyPassword(ctx, "admin", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("want invalid credentials, got %v", err)
+	}
+	if _, err := s.VerifyPassword(ctx, "nobody", "s3cret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("want invalid credentials, got %v", err)
+	}
+}
+
+func TestStorePersistFailure(t *testing.T) {
+	ctx := context.Background()
+	s, err := OpenFileStore(filepath.Join(t.TempDir(), "missing", "creds.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.AddAPIKey("ci", "key", "deploy"); err == nil {
+		t.Fatal("want error writing to a missing directory")
+	}
+	if _, err := s.VerifyAPIKey(ctx, "key"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("want key not active when not persisted, got %v", err)
+	}
+	if err := s.SetPassword("admin", "s3cret"); err == nil {
+		t.Fatal("want error writing to a missing directory")
+	}
+	if _, err := s.VerifyPassword(ctx, "admin", "s3cret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("want password not active when not persisted, got %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pbkdf2Iterations is the cost of password hashes.
+const pbkdf2Iterations = 100000
+
+// HashAPIKey hashes an API key. Keys are expected to be long random strings,
+// so a fast unsalted hash is enough and allows to store only the digest.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "sha256$" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// HashPassword hashes a password with PBKDF2-HMAC-SHA256 and a random salt.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("cannot generate salt: %w", err)
+	}
+	dk := pbkdf2([]byte(password), salt, pbkdf2Iterations, sha256.Size)
+	enc := base64.RawStdEncoding.EncodeToString
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", pbkdf2Iterations, enc(salt), enc(dk)), nil
+}
+
+// checkHash reports whether secret matches the hash produced by HashAPIKey or HashPassword.
+// The comparison runs in constant time.
+func checkHash(hash, secret string) bool {
+	parts := strings.Split(hash, "$")
+	switch {
+	case len(parts) == 2 && parts[0] == "sha256":
+		return subtle.ConstantTimeCompare([]byte(HashAPIKey(secret)), []byte(hash)) == 1
+
+	case len(parts) == 4 && parts[0] == "pbkdf2-sha256":
+		iter, err := strconv.Atoi(parts[1])
+		if err != nil || iter <= 0 {
+			return false
+		}
+		salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+		if err != nil {
+			return false
+		}
+		want, err := base64.RawStdEncoding.DecodeString(parts[3])
+		if err != nil {
+			return false
+		}
+		got := pbkdf2([]byte(secret), salt, iter, len(want))
+		return subtle.ConstantTimeCompare(got, want) == 1
+	}
+	return false
+}
+
+// pbkdf2 derives a key as described in RFC 8018, using HMAC-SHA256.
+func pbkdf2(password, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var dk []byte
+	var buf [4]byte
+	for block := uint32(1); len(dk) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf[:], block)
+		prf.Write(buf[:])
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iter; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		dk = append(dk, t...)
+	}
+	return dk[:keyLen]
+}
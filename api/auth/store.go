@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Credential is a hashed secret owned by a principal.
+type Credential struct {
+	Principal string    `json:"principal"`
+	Kind      string    `json:"kind"`
+	Hash      string    `json:"hash"`
+	Roles     []string  `json:"roles,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// Store keeps hashed credentials in memory and, optionally, in a JSON file.
+// It implements both KeyVerifier and PasswordVerifier.
+type Store struct {
+	path string
+	now  func() time.Time
+
+	mu    sync.RWMutex
+	creds []Credential
+}
+
+// NewMemoryStore constructs an empty Store living only in memory.
+func NewMemoryStore() *Store {
+	return &Store{now: time.Now}
+}
+
+// OpenFileStore constructs a Store backed by the JSON file at path.
+// The file is loaded if it exists, and it's rewritten on every change.
+func OpenFileStore(path string) (*Store, error) {
+	s := &Store{path: path, now: time.Now}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read credentials: %w", err)
+	}
+	if err := json.Unmarshal(b, &s.creds); err != nil {
+		return nil, fmt.Errorf("cannot decode credentials: %w", err)
+	}
+	return s, nil
+}
+
+// AddAPIKey adds an API key for the principal.
+// Other keys of the principal remain valid.
+func (s *Store) AddAPIKey(principal, key string, roles ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	creds := append(s.cloneCreds(), Credential{Principal: principal, Kind: KindAPIKey, Hash: HashAPIKey(key), Roles: roles})
+	return s.update(creds)
+}
+
+// RotateAPIKey adds a new API key for the principal and makes its
+// current keys expire after grace, so that clients can switch key
+// without downtime. The new key inherits the roles of the current ones.
+func (s *Store) RotateAPIKey(principal, newKey string, grace time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	expires := now.Add(grace)
+	var roles []string
+	creds := s.cloneCreds()
+	for i, c := range creds {
+		if c.Principal != principal || c.Kind != KindAPIKey || c.expired(now) {
+			continue
+		}
+		roles = c.Roles
+		if c.ExpiresAt.IsZero() || c.ExpiresAt.After(expires) {
+			creds[i].ExpiresAt = expires
+		}
+	}
+	creds = append(creds, Credential{Principal: principal, Kind: KindAPIKey, Hash: HashAPIKey(newKey), Roles: roles})
+	return s.update(creds)
+}
+
+// SetPassword sets the password of the user, replacing the previous one.
+func (s *Store) SetPassword(user, password string, roles ...string) error {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	creds := make([]Credential, 0, len(s.creds)+1)
+	for _, c := range s.creds {
+		if c.Principal != user || c.Kind != KindPassword {
+			creds = append(creds, c)
+		}
+	}
+	creds = append(creds, Credential{Principal: user, Kind: KindPassword, Hash: hash, Roles: roles})
+	return s.update(creds)
+}
+
+// VerifyAPIKey implements the KeyVerifier interface.
+// All the keys are compared, in constant time, so that the time taken
+// doesn't reveal which keys exist.
+func (s *Store) VerifyAPIKey(ctx context.Context, key string) (*Principal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := s.now()
+	var match *Credential
+	for i := range s.creds {
+		c := &s.creds[i]
+		if c.Kind == KindAPIKey && checkHash(c.Hash, key) && !c.expired(now) && match == nil {
+			match = c
+		}
+	}
+	if match == nil {
+		return nil, ErrInvalidCredentials
+	}
+	return &Principal{ID: match.Principal, Kind: KindAPIKey, Roles: match.Roles}, nil
+}
+
+// VerifyPassword implements the PasswordVerifier interface.
+func (s *Store) VerifyPassword(ctx context.Context, user, password string) (*Principal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := s.now()
+	for _, c := range s.creds {
+		if c.Kind == KindPassword && c.Principal == user && !c.expired(now) {
+			if !checkHash(c.Hash, password) {
+				return nil, ErrInvalidCredentials
+			}
+			return &Principal{ID: c.Principal, Kind: KindPassword, Roles: c.Roles}, nil
+		}
+	}
+
+	// Spend the time of a real check to not reveal that the user doesn't exist.
+	dummyOnce.Do(func() { dummyHash, _ = HashPassword("dummy") })
+	checkHash(dummyHash, password)
+	return nil, ErrInvalidCredentials
+}
+
+// dummyHash is checked against the passwords of unknown users.
+var (
+	dummyOnce sync.Once
+	dummyHash string
+)
+
+func (c *Credential) expired(now time.Time) bool {
+	return !c.ExpiresAt.IsZero() && !now.Before(c.ExpiresAt)
+}
+
+// cloneCreds returns a copy of the credentials, to be changed and passed
+// to update. It must be called with the lock held.
+func (s *Store) cloneCreds() []Credential {
+	return append([]Credential(nil), s.creds...)
+}
+
+// update replaces the credentials with creds once they are persisted,
+// so that changes failing to be saved never take effect.
+// It must be called with the lock held.
+func (s *Store) update(creds []Credential) error {
+	if err := s.persist(creds); err != nil {
+		return err
+	}
+	s.creds = creds
+	return nil
+}
+
+// persist writes creds to the file, if any.
+func (s *Store) persist(creds []Credential) error {
+	if s.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot encode credentials: %w", err)
+	}
+
+	// Write to a temporary file and rename it, so that the file is never half written.
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("cannot write credentials: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write credentials: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot write credentials: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o600); err != nil {
+		return fmt.Errorf("cannot write credentials: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("cannot write credentials: %w", err)
+	}
+	return nil
+}
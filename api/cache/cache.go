@@ -0,0 +1,53 @@
+// Package cache stores HTTP responses to be served again
+// without executing their handlers.
+package cache
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Entry is a cached response.
+type Entry struct {
+	Status int
+	Header http.Header
+	Body   []byte
+
+	// Tags identify the entry for invalidation.
+	Tags []string
+
+	StoredAt  time.Time
+	ExpiresAt time.Time
+}
+
+// Size returns an estimate of the memory used by the entry.
+func (e *Entry) Size() int64 {
+	n := int64(len(e.Body))
+	for k, vs := range e.Header {
+		n += int64(len(k))
+		for _, v := range vs {
+			n += int64(len(v))
+		}
+	}
+	for _, t := range e.Tags {
+		n += int64(len(t))
+	}
+	return n
+}
+
+// Store persists cached responses.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the entry of key, if it exists and is not expired.
+	Get(ctx context.Context, key string) (e *Entry, ok bool, err error)
+
+	// Set stores the entry of key until it expires.
+	Set(ctx context.Context, key string, e *Entry) error
+
+	// Delete removes the entry of key.
+	Delete(ctx context.Context, key string) error
+
+	// InvalidateTags removes the entries having any of the tags.
+	InvalidateTags(ctx context.Context, tags ...string) error
+}
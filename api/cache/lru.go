@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// LRU is an in-memory Store that evicts the least recently used entries
+// when it exceeds the maximum number of entries or bytes.
+type LRU struct {
+	maxEntries int
+	maxBytes   int64
+	now        func() time.Time
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	tags  map[string]map[string]struct{}
+	size  int64
+}
+
+type lruItem struct {
+	key   string
+	entry *Entry
+}
+
+// NewLRU constructs an LRU store holding at most maxEntries entries and
+// maxBytes bytes of responses. A non positive value disables the limit.
+func NewLRU(maxEntries int, maxBytes int64) *LRU {
+	return &LRU{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		now:        time.Now,
+		ll:         list.New(),
+		items:      map[string]*list.Element{},
+		tags:       map[string]map[string]struct{}{},
+	}
+}
+
+// Len returns the number of entries in the store.
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// Get implements the Store interface.
+func (c *LRU) Get(ctx context.Context, key string) (*Entry, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	it := el.Value.(*lruItem)
+	if c.now().After(it.entry.ExpiresAt) {
+		c.remove(el)
+		return nil, false, nil
+	}
+	c.ll.MoveToFront(el)
+	return it.entry, true, nil
+}
+
+// Set implements the Store interface.
+func (c *LRU) Set(ctx context.Context, key string, e *Entry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	if c.maxBytes > 0 && e.Size() > c.maxBytes {
+		return nil
+	}
+
+	c.items[key] = c.ll.PushFront(&lruItem{key: key, entry: e})
+	c.size += e.Size()
+	for _, t := range e.Tags {
+		keys, ok := c.tags[t]
+		if !ok {
+			keys = map[string]struct{}{}
+			c.tags[t] = keys
+		}
+		keys[key] = struct{}{}
+	}
+
+	for (c.maxEntries > 0 && c.ll.Len() > c.maxEntries) || (c.maxBytes > 0 && c.size > c.maxBytes) {
+		c.remove(c.ll.Back())
+	}
+	return nil
+}
+
+// Delete implements the Store interface.
+func (c *LRU) Delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	return nil
+}
+
+// InvalidateTags implements the Store interface.
+func (c *LRU) InvalidateTags(ctx context.Context, tags ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range tags {
+		for key := range c.tags[t] {
+			if el, ok := c.items[key]; ok {
+				c.remove(el)
+			}
+		}
+	}
+	return nil
+}
+
+// remove deletes an element and its tag references. Callers hold the lock.
+func (c *LRU) remove(el *list.Element) {
+	it := el.Value.(*lruItem)
+	c.ll.Remove(el)
+	delete(c.items, it.key)
+	c.size -= it.entry.Size()
+	for _, t := range it.entry.Tags {
+		delete(c.tags[t], it.key)
+		if len(c.tags[t]) == 0 {
+			delete(c.tags, t)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLRU(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	c := NewLRU(2, 0)
+	c.now = func() time.Time { return now }
+
+	entry := func(body string, tags ...string) *Entry {
+		return &Entry{Status: 200, Body: []byte(body), Tags: tags, ExpiresAt: now.Add(time.Minute)}
+	}
+	get := func(key string) bool {
+		_, ok, err := c.Get(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	_ = c.Set(ctx, "a", entry("a", "orders"))
+	_ = c.Set(ctx, "b", entry("b", "users"))
+	get("a")
+	_ = c.Set(ctx, "c", entry("c", "orders"))
+	if get("b") || !get("a") || !get("c") {
+		t.Fatalf("want least recently used entry evicted")
+	}
+
+	_ = c.InvalidateTags(ctx, "orders")
+	if c.Len() != 0 || len(c.tags) != 0 {
+		t.Fatalf("want tagged entries invalidated, got %d entries", c.Len())
+	}
+
+	_ = c.Set(ctx, "d", entry("d"))
+	now = now.Add(2 * time.Minute)
+	if get("d") {
+		t.Errorf("want expired entry missing")
+	}
+
+	bytes := NewLRU(0, 10)
+	_ = bytes.Set(ctx, "a", entry("123456"))
+	_ = bytes.Set(ctx, "b", entry("123456"))
+	if bytes.Len() != 1 || bytes.size != 6 {
+		t.Errorf("want entries evicted over the byte limit, got %d entries of %d bytes", bytes.Len(), bytes.size)
+	}
+}
@@ -0,0 +1,72 @@
+// Package cidr matches IP addresses against sets of IPv4 and IPv6 networks.
+package cidr
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Set is a list of networks.
+type Set []*net.IPNet
+
+// Parse parses networks in CIDR notation, like '10.0.0.0/8' or 'fd00::/8'.
+// Single addresses are accepted too, as networks of one address.
+func Parse(cidrs ...string) (Set, error) {
+	s := make(Set, 0, len(cidrs))
+	for _, c := range cidrs {
+		n, err := parseNet(strings.TrimSpace(c))
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, n)
+	}
+	return s, nil
+}
+
+// MustParse is like Parse but panics if a network is invalid.
+// It's meant for networks known at compile time.
+func MustParse(cidrs ...string) Set {
+	s, err := Parse(cidrs...)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func parseNet(c string) (*net.IPNet, error) {
+	if !strings.Contains(c, "/") {
+		ip := net.ParseIP(c)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %q", c)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, n, err := net.ParseCIDR(c)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network %q: %w", c, err)
+	}
+	return n, nil
+}
+
+// Match returns the first network of the set containing ip.
+func (s Set) Match(ip net.IP) (*net.IPNet, bool) {
+	if ip == nil {
+		return nil, false
+	}
+	for _, n := range s {
+		if n.Contains(ip) {
+			return n, true
+		}
+	}
+	return nil, false
+}
+
+// Contains reports whether ip belongs to any network of the set.
+func (s Set) Contains(ip net.IP) bool {
+	_, ok := s.Match(ip)
+	return ok
+}
@@ -0,0 +1,44 @@
+package cidr
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	s, err := Parse("10.0.0.0/8", " 192.0.2.7 ", "fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip    string
+		match string
+	}{
+		{"10.1.2.3", "10.0.0.0/8"},
+		{"192.0.2.7", "192.0.2.7/32"},
+		{"::ffff:10.0.0.1", "10.0.0.0/8"},
+		{"fd12::1", "fd00::/8"},
+		{"192.0.2.8", ""},
+		{"2001:db8::1", ""},
+	}
+	for _, tt := range tests {
+		var got string
+		if n, ok := s.Match(net.ParseIP(tt.ip)); ok {
+			got = n.String()
+		}
+		if got != tt.match {
+			t.Errorf("%s: want match %q, got %q", tt.ip, tt.match, got)
+		}
+	}
+
+	if s.Contains(nil) {
+		t.Error("want nil address not contained")
+	}
+	if _, err := Parse("10.0.0.0/33"); err == nil {
+		t.Error("want error for invalid network")
+	}
+	if _, err := Parse("not-an-ip"); err == nil {
+		t.Error("want error for invalid address")
+	}
+}
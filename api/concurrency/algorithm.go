@@ -0,0 +1,163 @@
+package concurrency
+
+import (
+	"math"
+	"time"
+)
+
+// Sample is the outcome of a completed request, used to adapt the limit.
+type Sample struct {
+	// Limit and InFlight are the state of the limiter when the request completed,
+	// InFlight including the request.
+	Limit    int
+	InFlight int
+
+	// RTT is the time the request took to be processed.
+	RTT time.Duration
+
+	// Dropped reports whether the request failed because of overload,
+	// like a timeout.
+	Dropped bool
+}
+
+// Algorithm computes the concurrency limit.
+// Its methods are called with the lock of the limiter held,
+// so implementations don't need to be safe for concurrent use.
+type Algorithm interface {
+	// Limit returns the initial limit.
+	Limit() int
+
+	// Update returns the new limit after a request completed.
+	Update(s Sample) int
+}
+
+// Static returns an Algorithm with a fixed limit.
+func Static(limit int) Algorithm {
+	return staticLimit(limit)
+}
+
+type staticLimit int
+
+func (s staticLimit) Limit() int        { return int(s) }
+func (s staticLimit) Update(Sample) int { return int(s) }
+
+// AIMD is an Algorithm that increases the limit by one while requests are
+// fast and the limit is in use, and multiplies it by Backoff when a request
+// is dropped or slower than Threshold.
+type AIMD struct {
+	Initial, Min, Max int
+
+	// Backoff is the factor applied to the limit on overload, 0.9 if zero.
+	Backoff float64
+
+	// Threshold is the latency above which requests signal overload.
+	// If zero, only dropped requests do.
+	Threshold time.Duration
+}
+
+// Limit implements the Algorithm interface.
+func (a *AIMD) Limit() int { return a.Initial }
+
+// Update implements the Algorithm interface.
+func (a *AIMD) Update(s Sample) int {
+	backoff := a.Backoff
+	if backoff <= 0 || backoff >= 1 {
+		backoff = 0.9
+	}
+	limit := s.Limit
+	switch {
+	case s.Dropped || (a.Threshold > 0 && s.RTT > a.Threshold):
+		limit = int(float64(limit) * backoff)
+	case s.InFlight*2 >= s.Limit:
+		limit++
+	}
+	return clamp(limit, a.Min, a.Max)
+}
+
+// Gradient is an Algorithm that compares the latency of each request with
+// the long term average: while they are close the limit grows, and as the
+// latency rises, because requests are queuing up in the service, the limit
+// shrinks proportionally.
+type Gradient struct {
+	Initial, Min, Max int
+
+	// Tolerance is the ratio of latency increase tolerated before reducing
+	// the limit, 1.5 if zero.
+	Tolerance float64
+
+	// Smoothing is the weight of each new limit, 0.2 if zero.
+	Smoothing float64
+
+	limit   float64
+	longRTT float64
+}
+
+// longWindow is the number of samples averaged by the long term latency.
+const longWindow = 600
+
+// Limit implements the Algorithm interface.
+func (g *Gradient) Limit() int { return g.Initial }
+
+// Update implements the Algorithm interface.
+func (g *Gradient) Update(s Sample) int {
+	if g.limit == 0 {
+		g.limit = float64(s.Limit)
+	}
+	tolerance, smoothing := g.Tolerance, g.Smoothing
+	if tolerance <= 0 {
+		tolerance = 1.5
+	}
+	if smoothing <= 0 || smoothing > 1 {
+		smoothing = 0.2
+	}
+
+	if s.Dropped {
+		g.limit = math.Max(float64(g.Min), g.limit/2)
+		return clamp(int(g.limit), g.Min, g.Max)
+	}
+
+	// A sample without latency, like one measured by a coarse clock,
+	// can't be compared with the long term average.
+	rtt := float64(s.RTT)
+	if rtt <= 0 {
+		return clamp(int(g.limit), g.Min, g.Max)
+	}
+	if g.longRTT == 0 {
+		g.longRTT = rtt
+	}
+	g.longRTT += (rtt - g.longRTT) / longWindow
+
+	// Recover faster from a past latency increase.
+	if g.longRTT/rtt > 2 {
+		g.longRTT *= 0.95
+	}
+
+	// The limit is not the bottleneck, so there's nothing to learn.
+	if float64(s.InFlight) < g.limit/2 {
+		return clamp(int(g.limit), g.Min, g.Max)
+	}
+
+	gradient := math.Max(0.5, math.Min(1, tolerance*g.longRTT/rtt))
+	next := g.limit*gradient + math.Sqrt(g.limit)
+	g.limit = g.limit*(1-smoothing) + next*smoothing
+	g.limit = math.Max(float64(g.Min), g.limit)
+	if g.Max > 0 {
+		g.limit = math.Min(float64(g.Max), g.limit)
+	}
+	return clamp(int(g.limit), g.Min, g.Max)
+}
+
+// clamp bounds limit between min and max, ignoring a non positive max.
+// The limit is never lower than one.
+func clamp(limit, min, max int) int {
+	if max > 0 && limit > max {
+		limit = max
+	}
+	if limit < min {
+		limit = min
+	}
+	if limit < 1 {
+		limit = 1
+	}
+	return limit
+}
@@ -0,0 +1,267 @@
+// Package concurrency limits the number of requests processed at the same
+// time, queuing the excess ones by priority and shedding them when the
+// service is overloaded.
+package concurrency
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrShed is returned when a request is rejected by the limiter.
+var ErrShed = errors.New("request shed by concurrency limiter")
+
+// Priority orders the queued requests: higher priorities are served first.
+type Priority int
+
+// Predefined priorities. Any other value can be used.
+const (
+	PriorityLow      Priority = -10
+	PriorityNormal   Priority = 0
+	PriorityHigh     Priority = 10
+	PriorityCritical Priority = 20
+)
+
+// Stats is a snapshot of the state of a Limiter.
+type Stats struct {
+	Limit    int
+	InFlight int
+	Queued   int
+
+	// Accepted and Shed count the requests processed and rejected.
+	Accepted uint64
+	Shed     uint64
+}
+
+// Limiter bounds the requests in flight to the limit computed by its Algorithm.
+type Limiter struct {
+	alg        Algorithm
+	maxQueue   int
+	maxWait    time.Duration
+	retryAfter time.Duration
+	now        func() time.Time
+
+	mu       sync.Mutex
+	limit    int
+	inflight int
+	queue    waitQueue
+	seq      uint64
+	accepted uint64
+	shed     uint64
+}
+
+// LimiterOpt defines the type for Limiter options.
+type LimiterOpt func(*Limiter)
+
+// WithMaxQueue returns an option that sets how many requests can wait for
+// a slot. When the queue is full, a new request evicts the queued request
+// with the lowest priority, if lower than its own, or is shed.
+// Without a queue, requests over the limit are shed immediately.
+func WithMaxQueue(n int) LimiterOpt {
+	return func(l *Limiter) {
+		l.maxQueue = n
+	}
+}
+
+// WithMaxWait returns an option that sets how long requests wait in the queue
+// before being shed. If zero, they wait until their context is done.
+func WithMaxWait(d time.Duration) LimiterOpt {
+	return func(l *Limiter) {
+		l.maxWait = d
+	}
+}
+
+// WithRetryAfter returns an option that sets when shed requests should
+// be retried, one second by default.
+func WithRetryAfter(d time.Duration) LimiterOpt {
+	return func(l *Limiter) {
+		l.retryAfter = d
+	}
+}
+
+// WithClock returns an option that sets the clock used to measure latencies.
+func WithClock(now func() time.Time) LimiterOpt {
+	return func(l *Limiter) {
+		l.now = now
+	}
+}
+
+// NewLimiter constructs a Limiter.
+func NewLimiter(alg Algorithm, opts ...LimiterOpt) *Limiter {
+	l := &Limiter{alg: alg, retryAfter: time.Second, now: time.Now}
+	for _, opt := range opts {
+		opt(l)
+	}
+	l.limit = clamp(alg.Limit(), 1, 0)
+	return l
+}
+
+// RetryAfter returns when shed requests should be retried.
+func (l *Limiter) RetryAfter() time.Duration { return l.retryAfter }
+
+// Stats returns the current state of the limiter.
+func (l *Limiter) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return Stats{
+		Limit:    l.limit,
+		InFlight: l.inflight,
+		Queued:   len(l.queue),
+		Accepted: l.accepted,
+		Shed:     l.shed,
+	}
+}
+
+// Token is a slot acquired from a Limiter, to be released
+// when the request completes.
+type Token struct {
+	l     *Limiter
+	start time.Time
+	once  sync.Once
+}
+
+// Release frees the slot, adapting the limit to the latency of the request.
+// dropped reports whether the request failed because of overload.
+func (t *Token) Release(dropped bool) {
+	t.once.Do(func() {
+		l := t.l
+		rtt := l.now().Sub(t.start)
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.limit = clamp(l.alg.Update(Sample{Limit: l.limit, InFlight: l.inflight, RTT: rtt, Dropped: dropped}), 1, 0)
+		l.inflight--
+		l.grant()
+	})
+}
+
+// Acquire returns a Token once a slot is available. It fails with an error
+// matching ErrShed if the request is rejected, or with the error of ctx if
+// it's done while waiting.
+func (l *Limiter) Acquire(ctx context.Context, p Priority) (*Token, error) {
+	l.mu.Lock()
+	if l.inflight < l.limit && len(l.queue) == 0 {
+		l.inflight++
+		l.accepted++
+		l.mu.Unlock()
+		return &Token{l: l, start: l.now()}, nil
+	}
+
+	if len(l.queue) >= l.maxQueue {
+		lowest := l.queue.lowest()
+		if lowest == nil || lowest.prio >= p {
+			l.shed++
+			l.mu.Unlock()
+			return nil, fmt.Errorf("%w: queue full", ErrShed)
+		}
+		heap.Remove(&l.queue, lowest.index)
+		lowest.err = fmt.Errorf("%w: evicted by a request with higher priority", ErrShed)
+		l.shed++
+		close(lowest.ready)
+	}
+
+	l.seq++
+	w := &waiter{prio: p, seq: l.seq, ready: make(chan struct{})}
+	heap.Push(&l.queue, w)
+	l.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if l.maxWait > 0 {
+		t := time.NewTimer(l.maxWait)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	var err error
+	select {
+	case <-w.ready:
+	case <-timeout:
+		err = fmt.Errorf("%w: queue timeout", ErrShed)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-w.ready:
+		// Granted or evicted, even if the wait was interrupted meanwhile.
+		if w.err != nil {
+			return nil, w.err
+		}
+		return &Token{l: l, start: l.now()}, nil
+	default:
+	}
+	heap.Remove(&l.queue, w.index)
+	if errors.Is(err, ErrShed) {
+		l.shed++
+	}
+	return nil, err
+}
+
+// grant hands the free slots to the queued requests. Callers hold the lock.
+func (l *Limiter) grant() {
+	for l.inflight < l.limit && len(l.queue) > 0 {
+		w := heap.Pop(&l.queue).(*waiter)
+		l.inflight++
+		l.accepted++
+		close(w.ready)
+	}
+}
+
+// waiter is a queued request. ready is closed when it gets a slot,
+// or when it's evicted, in which case err is set.
+type waiter struct {
+	prio  Priority
+	seq   uint64
+	ready chan struct{}
+	err   error
+	index int
+}
+
+// waitQueue is a heap of waiters, by priority and then by arrival.
+type waitQueue []*waiter
+
+func (q waitQueue) Len() int { return len(q) }
+
+func (q waitQueue) Less(i, j int) bool {
+	if q[i].prio != q[j].prio {
+		return q[i].prio > q[j].prio
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q waitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *waitQueue) Push(x interface{}) {
+	w := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *waitQueue) Pop() interface{} {
+	old := *q
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return w
+}
+
+// lowest returns the waiter with the lowest priority, and the latest among
+// those with the same priority, or nil if the queue is empty.
+func (q waitQueue) lowest() *waiter {
+	var low *waiter
+	for _, w := range q {
+		if low == nil || w.prio < low.prio || (w.prio == low.prio && w.seq > low.seq) {
+			low = w
+		}
+	}
+	return low
+}
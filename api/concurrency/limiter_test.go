@@ -0,0 +1,144 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLimiterQueue(t *testing.T) {
+	ctx := context.Background()
+	l := NewLimiter(Static(1), WithMaxQueue(2))
+
+	token, err := l.Acquire(ctx, PriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Queue a low and a normal priority request, then a high priority one
+	// that evicts the low one since the queue is full.
+	type result struct {
+		name  string
+		token *Token
+		err   error
+	}
+	results := make(chan result, 3)
+	enqueue := func(name string, p Priority) {
+		go func() {
+			tk, err := l.Acquire(ctx, p)
+			results <- result{name, tk, err}
+		}()
+		for !l.queued(p) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	enqueue("low", PriorityLow)
+	enqueue("normal", PriorityNormal)
+	enqueue("high", PriorityHigh)
+
+	if r := <-results; r.name != "low" || !errors.Is(r.err, ErrShed) {
+		t.Fatalf("want low priority request evicted, got %s %v", r.name, r.err)
+	}
+	if _, err := l.Acquire(ctx, PriorityLow); !errors.Is(err, ErrShed) {
+		t.Fatalf("want low priority request shed on full queue, got %v", err)
+	}
+
+	// Slots are granted by priority.
+	for _, want := range []string{"high", "normal"} {
+		token.Release(false)
+		r := <-results
+		if r.name != want || r.err != nil {
+			t.Fatalf("want %s request granted, got %s %v", want, r.name, r.err)
+		}
+		token = r.token
+	}
+	token.Release(false)
+
+	if s := l.Stats(); s.InFlight != 0 || s.Queued != 0 || s.Accepted != 3 || s.Shed != 2 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+// queued reports whether a request with priority p is in the queue.
+func (l *Limiter) queued(p Priority) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, w := range l.queue {
+		if w.prio == p {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLimiterWait(t *testing.T) {
+	l := NewLimiter(Static(1), WithMaxQueue(1), WithMaxWait(10*time.Millisecond))
+	if _, err := l.Acquire(context.Background(), PriorityNormal); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Acquire(context.Background(), PriorityNormal); !errors.Is(err, ErrShed) {
+		t.Errorf("want request shed after max wait, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.Acquire(ctx, PriorityNormal); err != context.Canceled {
+		t.Errorf("want context error, got %v", err)
+	}
+	if s := l.Stats(); s.Queued != 0 || s.Shed != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestAlgorithms(t *testing.T) {
+	aimd := &AIMD{Initial: 10, Min: 2, Max: 12, Threshold: 100 * time.Millisecond}
+	limit := aimd.Limit()
+	for i := 0; i < 5; i++ {
+		limit = aimd.Update(Sample{Limit: limit, InFlight: limit, RTT: 10 * time.Millisecond})
+	}
+	if limit != 12 {
+		t.Errorf("AIMD: want limit increased up to max, got %d", limit)
+	}
+	limit = aimd.Update(Sample{Limit: limit, InFlight: limit, RTT: time.Second})
+	if limit != 10 {
+		t.Errorf("AIMD: want limit decreased on slow requests, got %d", limit)
+	}
+
+	g := &Gradient{Initial: 20, Min: 1, Max: 100}
+	limit = g.Limit()
+	for i := 0; i < 50; i++ {
+		limit = g.Update(Sample{Limit: limit, InFlight: limit, RTT: 10 * time.Millisecond})
+	}
+	grown := limit
+	for i := 0; i < 50; i++ {
+		limit = g.Update(Sample{Limit: limit, InFlight: limit, RTT: 100 * time.Millisecond})
+	}
+	if grown <= 20 || limit >= grown {
+		t.Errorf("Gradient: want limit grown with stable latency and reduced as it rises, got %d and %d", grown, limit)
+	}
+}
+
+func TestGradientBounds(t *testing.T) {
+	g := &Gradient{Initial: 20, Min: 1}
+	limit := g.Limit()
+	for i := 0; i < 50; i++ {
+		limit = g.Update(Sample{Limit: limit, InFlight: limit, RTT: 10 * time.Millisecond})
+	}
+	if limit <= 20 {
+		t.Errorf("want limit grown without max, got %d", limit)
+	}
+
+	grown := limit
+	for i := 0; i < 5; i++ {
+		limit = g.Update(Sample{Limit: limit, InFlight: limit, RTT: 0})
+	}
+	if limit != grown {
+		t.Errorf("want samples without latency ignored, got limit %d from %d", limit, grown)
+	}
+	limit = g.Update(Sample{Limit: limit, InFlight: limit, RTT: 10 * time.Millisecond})
+	if limit < grown || math.IsNaN(g.limit) || math.IsNaN(g.longRTT) {
+		t.Errorf("want state not corrupted by samples without latency, got limit %d", limit)
+	}
+}
@@ -0,0 +1,36 @@
+package concurrency
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/polldo/patweb/api/metrics"
+)
+
+// Collectors returns the collectors exposing the state of the limiter,
+// named with the given prefix, like 'http_concurrency'.
+func (l *Limiter) Collectors(prefix string) []metrics.Collector {
+	return []metrics.Collector{
+		&statCollector{prefix + "_limit", "Current concurrency limit.", "gauge", func(s Stats) float64 { return float64(s.Limit) }, l},
+		&statCollector{prefix + "_in_flight", "Requests holding a concurrency slot.", "gauge", func(s Stats) float64 { return float64(s.InFlight) }, l},
+		&statCollector{prefix + "_queued", "Requests waiting for a concurrency slot.", "gauge", func(s Stats) float64 { return float64(s.Queued) }, l},
+		&statCollector{prefix + "_accepted_total", "Requests admitted by the concurrency limiter.", "counter", func(s Stats) float64 { return float64(s.Accepted) }, l},
+		&statCollector{prefix + "_shed_total", "Requests shed by the concurrency limiter.", "counter", func(s Stats) float64 { return float64(s.Shed) }, l},
+	}
+}
+
+// statCollector exposes a value of the Stats of a limiter.
+type statCollector struct {
+	name  string
+	help  string
+	typ   string
+	value func(Stats) float64
+	l     *Limiter
+}
+
+func (c *statCollector) Name() string { return c.name }
+
+func (c *statCollector) Write(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", c.name, c.help, c.name, c.typ, c.name, c.value(c.l.Stats()))
+	return err
+}
@@ -0,0 +1,51 @@
+// Package idempotency stores the responses of requests carrying an
+// Idempotency-Key, so that retries of the same request can be replayed
+// instead of being processed again.
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrInProgress is returned when a request with the same key is still being processed.
+	ErrInProgress = errors.New("a request with the same idempotency key is in progress")
+
+	// ErrKeyReused is returned when a key is reused for a request with a different body.
+	ErrKeyReused = errors.New("idempotency key reused with a different request body")
+)
+
+// Record is the state of an idempotency key.
+type Record struct {
+	// BodyHash is the hash of the body of the first request with the key.
+	BodyHash string `json:"body_hash"`
+
+	// Done reports whether the response has been stored.
+	Done bool `json:"done"`
+
+	// Status, Header and Body make up the stored response.
+	Status int         `json:"status,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+
+	// ExpiresAt is the time after which the record is discarded.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Store persists the records of idempotency keys.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Begin atomically reserves key for a request whose body has the given
+	// hash, for at most ttl. If the key is already reserved, the existing
+	// record is returned with ok set to false.
+	Begin(ctx context.Context, key, bodyHash string, ttl time.Duration) (rec *Record, ok bool, err error)
+
+	// Complete stores the response of a reserved key for ttl.
+	Complete(ctx context.Context, key string, rec *Record, ttl time.Duration) error
+
+	// Release removes a reserved key, so that the request can be retried.
+	Release(ctx context.Context, key string) error
+}
@@ -0,0 +1,63 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStores(t *testing.T) {
+	fs, err := OpenFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]struct {
+		store Store
+		clock *func() time.Time
+	}{
+		"memory": {NewMemoryStore(), nil},
+		"file":   {fs, &fs.now},
+	}
+	ms := stores["memory"]
+	ms.clock = &ms.store.(*MemoryStore).now
+	stores["memory"] = ms
+
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now()
+			*s.clock = func() time.Time { return now }
+
+			if _, ok, err := s.store.Begin(ctx, "k", "h1", time.Minute); err != nil || !ok {
+				t.Fatalf("want key reserved, got %v %v", ok, err)
+			}
+			rec, ok, err := s.store.Begin(ctx, "k", "h1", time.Minute)
+			if err != nil || ok || rec.Done || rec.BodyHash != "h1" {
+				t.Fatalf("want in progress record, got %+v %v %v", rec, ok, err)
+			}
+
+			res := &Record{BodyHash: "h1", Status: http.StatusCreated, Header: http.Header{"X": {"y"}}, Body: []byte("body")}
+			if err := s.store.Complete(ctx, "k", res, time.Hour); err != nil {
+				t.Fatal(err)
+			}
+			rec, ok, err = s.store.Begin(ctx, "k", "h1", time.Minute)
+			if err != nil || ok || !rec.Done || rec.Status != http.StatusCreated || string(rec.Body) != "body" || rec.Header.Get("X") != "y" {
+				t.Fatalf("want stored response, got %+v %v %v", rec, ok, err)
+			}
+
+			// Expired records are replaced.
+			now = now.Add(2 * time.Hour)
+			if _, ok, err := s.store.Begin(ctx, "k", "h2", time.Minute); err != nil || !ok {
+				t.Fatalf("want expired key reserved again, got %v %v", ok, err)
+			}
+
+			if err := s.store.Release(ctx, "k"); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok, err := s.store.Begin(ctx, "k", "h3", time.Minute); err != nil || !ok {
+				t.Fatalf("want released key reserved again, got %v %v", ok, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// sweepEvery is the number of reservations after which expired records are removed.
+const sweepEvery = 1024
+
+// MemoryStore is an in-memory Store.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]*Record
+	ops     int
+	now     func() time.Time
+}
+
+// NewMemoryStore constructs an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: map[string]*Record{}, now: time.Now}
+}
+
+// Begin implements the Store interface.
+func (m *MemoryStore) Begin(ctx context.Context, key, bodyHash string, ttl time.Duration) (*Record, bool, error) {
+	now := m.now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ops++
+	if m.ops%sweepEvery == 0 {
+		for k, r := range m.records {
+			if now.After(r.ExpiresAt) {
+				delete(m.records, k)
+			}
+		}
+	}
+
+	if r, ok := m.records[key]; ok && !now.After(r.ExpiresAt) {
+		cp := *r
+		return &cp, false, nil
+	}
+	m.records[key] = &Record{BodyHash: bodyHash, ExpiresAt: now.Add(ttl)}
+	return nil, true, nil
+}
+
+// Complete implements the Store interface.
+func (m *MemoryStore) Complete(ctx context.Context, key string, rec *Record, ttl time.Duration) error {
+	cp := *rec
+	cp.Done = true
+	cp.ExpiresAt = m.now().Add(ttl)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records[key] = &cp
+	return nil
+}
+
+// Release implements the Store interface.
+func (m *MemoryStore) Release(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, key)
+	return nil
+}
+
+// FileStore is a Store keeping a file for each key in a directory,
+// so that records survive restarts and can be shared by the processes
+// of a host. Reservations rely on the exclusive creation of files.
+type FileStore struct {
+	dir string
+	now func() time.Time
+}
+
+// OpenFileStore constructs a FileStore in dir, creating it if needed.
+func OpenFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("cannot create idempotency store: %w", err)
+	}
+	return &FileStore{dir: dir, now: time.Now}, nil
+}
+
+// path returns the file of key. Keys are hashed since they are chosen by clients.
+func (f *FileStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(f.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Begin implements the Store interface.
+func (f *FileStore) Begin(ctx context.Context, key, bodyHash string, ttl time.Duration) (*Record, bool, error) {
+	path := f.path(key)
+	rec := &Record{BodyHash: bodyHash, ExpiresAt: f.now().Add(ttl)}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return nil, false, fmt.Errorf("cannot encode idempotency record: %w", err)
+	}
+
+	// A second attempt is made after removing an expired record.
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			_, err = file.Write(b)
+			if cerr := file.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, false, fmt.Errorf("cannot write idempotency record: %w", err)
+			}
+			return nil, true, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, false, fmt.Errorf("cannot write idempotency record: %w", err)
+		}
+
+		existing, err := f.read(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if !f.now().After(existing.ExpiresAt) {
+			return existing, false, nil
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, false, fmt.Errorf("cannot remove idempotency record: %w", err)
+		}
+	}
+	return nil, false, fmt.Errorf("cannot reserve idempotency key: %w", ErrInProgress)
+}
+
+// Complete implements the Store interface.
+func (f *FileStore) Complete(ctx context.Context, key string, rec *Record, ttl time.Duration) error {
+	cp := *rec
+	cp.Done = true
+	cp.ExpiresAt = f.now().Add(ttl)
+	b, err := json.Marshal(&cp)
+	if err != nil {
+		return fmt.Errorf("cannot encode idempotency record: %w", err)
+	}
+
+	// Write to a temporary file and rename it, so that the record is never half written.
+	tmp, err := os.CreateTemp(f.dir, "record.tmp*")
+	if err != nil {
+		return fmt.Errorf("cannot write idempotency record: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write idempotency record: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot write idempotency record: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), f.path(key)); err != nil {
+		return fmt.Errorf("cannot write idempotency record: %w", err)
+	}
+	return nil
+}
+
+// Release implements the Store interface.
+func (f *FileStore) Release(ctx context.Context, key string) error {
+	if err := os.Remove(f.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("cannot remove idempotency record: %w", err)
+	}
+	return nil
+}
+
+// Sweep removes the expired records, and should be called periodically.
+func (f *FileStore) Sweep() error {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return fmt.Errorf("cannot read idempotency store: %w", err)
+	}
+	now := f.now()
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(f.dir, e.Name())
+		rec, err := f.read(path)
+		if err == nil && !now.After(rec.ExpiresAt) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("cannot remove idempotency record: %w", err)
+		}
+	}
+	return nil
+}
+
+func (f *FileStore) read(path string) (*Record, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read idempotency record: %w", err)
+	}
+	var rec Record
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return nil, fmt.Errorf("cannot decode idempotency record: %w", err)
+	}
+	return &rec, nil
+}
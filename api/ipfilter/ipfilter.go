@@ -0,0 +1,171 @@
+// Package ipfilter decides which client addresses can reach the API,
+// with allow and deny lists of IPv4 and IPv6 networks.
+package ipfilter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/polldo/patweb/api/cidr"
+)
+
+// ErrNoFile is returned when reloading a List not loaded from a file.
+var ErrNoFile = errors.New("list not loaded from a file")
+
+// Decision is the outcome of the check of an address.
+type Decision struct {
+	Allowed bool
+
+	// Rule is the rule that decided, like 'deny 192.0.2.0/24', or
+	// 'default deny' for addresses missing from a non-empty allow list.
+	// It's empty for addresses allowed without rules.
+	Rule string
+}
+
+// List holds allow and deny lists of networks.
+// It's safe for concurrent use, also while being reloaded.
+type List struct {
+	path string
+
+	mu      sync.RWMutex
+	allow   cidr.Set
+	deny    cidr.Set
+	modTime time.Time
+}
+
+// New constructs a List with the given networks.
+func New(allow, deny cidr.Set) *List {
+	return &List{allow: allow, deny: deny}
+}
+
+// OpenFile constructs a List loading the networks from the file at path,
+// whose lines are like 'allow 10.0.0.0/8' or 'deny 192.0.2.1'.
+// Empty lines and lines starting with '#' are ignored.
+// The file is loaded immediately so that errors are reported at startup.
+func OpenFile(path string) (*List, error) {
+	l := &List{path: path}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reload loads the file of the list again.
+// The current networks are kept if the file cannot be loaded.
+func (l *List) Reload() error {
+	if l.path == "" {
+		return ErrNoFile
+	}
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return fmt.Errorf("cannot stat ip list: %w", err)
+	}
+	b, err := os.ReadFile(l.path)
+	if err != nil {
+		return fmt.Errorf("cannot read ip list: %w", err)
+	}
+	allow, deny, err := Parse(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.allow, l.deny = allow, deny
+	l.modTime = info.ModTime()
+	return nil
+}
+
+// Watch reloads the file of the list whenever it's modified, checking
+// it every interval until ctx is done. Failed reloads are reported
+// to onError, if not nil. It must be used with lists loaded by OpenFile.
+func (l *List) Watch(ctx context.Context, interval time.Duration, onError func(error)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		info, err := os.Stat(l.path)
+		if err == nil {
+			l.mu.RLock()
+			changed := !info.ModTime().Equal(l.modTime)
+			l.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			err = l.Reload()
+		}
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+// Set replaces the networks of the list.
+func (l *List) Set(allow, deny cidr.Set) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.allow, l.deny = allow, deny
+}
+
+// Check decides whether ip is allowed. Denied networks take precedence
+// over allowed ones; if the allow list is not empty, only the addresses
+// it contains are allowed. A nil ip matches no network.
+func (l *List) Check(ip net.IP) Decision {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if n, ok := l.deny.Match(ip); ok {
+		return Decision{Allowed: false, Rule: "deny " + n.String()}
+	}
+	if len(l.allow) == 0 {
+		return Decision{Allowed: true}
+	}
+	if n, ok := l.allow.Match(ip); ok {
+		return Decision{Allowed: true, Rule: "allow " + n.String()}
+	}
+	return Decision{Allowed: false, Rule: "default deny"}
+}
+
+// Parse reads allow and deny lists in the format of OpenFile.
+func Parse(r io.Reader) (allow, deny cidr.Set, err error) {
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, nil, fmt.Errorf("ip list line %d: want 'allow|deny network', got %q", n, line)
+		}
+		set, err := cidr.Parse(fields[1])
+		if err != nil {
+			return nil, nil, fmt.Errorf("ip list line %d: %w", n, err)
+		}
+		switch fields[0] {
+		case "allow":
+			allow = append(allow, set...)
+		case "deny":
+			deny = append(deny, set...)
+		default:
+			return nil, nil, fmt.Errorf("ip list line %d: unknown action %q", n, fields[0])
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, nil, fmt.Errorf("cannot read ip list: %w", err)
+	}
+	return allow, deny, nil
+}
@@ -0,0 +1,74 @@
+package ipfilter
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/polldo/patweb/api/cidr"
+)
+
+func TestCheck(t *testing.T) {
+	l := New(cidr.MustParse("10.0.0.0/8", "fd00::/8"), cidr.MustParse("10.6.6.0/24"))
+
+	tests := []struct {
+		ip      string
+		allowed bool
+		rule    string
+	}{
+		{"10.1.1.1", true, "allow 10.0.0.0/8"},
+		{"fd00::1", true, "allow fd00::/8"},
+		{"10.6.6.6", false, "deny 10.6.6.0/24"},
+		{"192.0.2.1", false, "default deny"},
+		{"", false, "default deny"},
+	}
+	for _, tt := range tests {
+		d := l.Check(net.ParseIP(tt.ip))
+		if d.Allowed != tt.allowed || d.Rule != tt.rule {
+			t.Errorf("%q: want %v %q, got %v %q", tt.ip, tt.allowed, tt.rule, d.Allowed, d.Rule)
+		}
+	}
+
+	l.Set(nil, cidr.MustParse("192.0.2.1"))
+	if d := l.Check(net.ParseIP("198.51.100.1")); !d.Allowed || d.Rule != "" {
+		t.Errorf("want allowed without allow list, got %+v", d)
+	}
+	if err := l.Reload(); err != ErrNoFile {
+		t.Errorf("want ErrNoFile, got %v", err)
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ips")
+	write := func(s string) {
+		if err := os.WriteFile(path, []byte(s), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("# vpn\nallow 10.0.0.0/8\n\ndeny 10.6.6.6\n")
+	l, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := l.Check(net.ParseIP("10.6.6.6")); d.Allowed {
+		t.Fatalf("want denied, got %+v", d)
+	}
+
+	write("allow 192.0.2.0/24\n")
+	if err := l.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if d := l.Check(net.ParseIP("10.1.1.1")); d.Allowed {
+		t.Fatalf("want reloaded list, got %+v", d)
+	}
+
+	write("permit 10.0.0.0/8\n")
+	if err := l.Reload(); err == nil {
+		t.Fatal("want error for invalid list")
+	}
+	if d := l.Check(net.ParseIP("192.0.2.1")); !d.Allowed {
+		t.Fatalf("want previous list kept, got %+v", d)
+	}
+}
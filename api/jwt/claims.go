@@ -0,0 +1,93 @@
+package jwt
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"time"
+)
+
+// NumericDate is a JSON numeric date: the number of seconds since the epoch.
+type NumericDate int64
+
+// NewNumericDate converts t to a NumericDate.
+func NewNumericDate(t time.Time) NumericDate { return NumericDate(t.Unix()) }
+
+// Time converts the date to a time.Time.
+func (d NumericDate) Time() time.Time { return time.Unix(int64(d), 0) }
+
+// UnmarshalJSON accepts both integer and fractional dates.
+func (d *NumericDate) UnmarshalJSON(b []byte) error {
+	var f float64
+	if err := json.Unmarshal(b, &f); err != nil {
+		return err
+	}
+	*d = NumericDate(math.Floor(f))
+	return nil
+}
+
+// Audience is the 'aud' claim, that can be either a string or an array of strings.
+type Audience []string
+
+// UnmarshalJSON accepts both a single string and an array of strings.
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+	*a = ss
+	return nil
+}
+
+// MarshalJSON encodes single audiences as a string.
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// Contains reports whether aud is one of the audiences.
+func (a Audience) Contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// Claims contains the registered claims of a token and the 'scope' claim.
+// All the claims, including custom ones, are available in Raw.
+type Claims struct {
+	Issuer    string      `json:"iss,omitempty"`
+	Subject   string      `json:"sub,omitempty"`
+	Audience  Audience    `json:"aud,omitempty"`
+	ExpiresAt NumericDate `json:"exp,omitempty"`
+	NotBefore NumericDate `json:"nbf,omitempty"`
+	IssuedAt  NumericDate `json:"iat,omitempty"`
+	ID        string      `json:"jti,omitempty"`
+	Scope     string      `json:"scope,omitempty"`
+
+	Raw map[string]interface{} `json:"-"`
+}
+
+// Scopes returns the space separated scopes of the 'scope' claim.
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// HasScope reports whether the token has been granted the scope.
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,280 @@
+// Package jwt verifies JSON Web Tokens (RFC 7519) signed with the
+// HS256, RS256 and ES256 algorithms, using keys from a static set
+// or from a JSON Web Key Set (RFC 7517).
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Supported signing algorithms.
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+// Errors returned by the verification of a token.
+var (
+	ErrMalformed   = errors.New("malformed token")
+	ErrAlgorithm   = errors.New("unsupported signing algorithm")
+	ErrUnknownKey  = errors.New("unknown signing key")
+	ErrSignature   = errors.New("invalid signature")
+	ErrExpired     = errors.New("token expired")
+	ErrNotYetValid = errors.New("token not valid yet")
+	ErrAudience    = errors.New("invalid audience")
+	ErrIssuer      = errors.New("invalid issuer")
+	ErrMissingExp  = errors.New("missing exp claim")
+)
+
+// header is the JOSE header of a token.
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// Verifier verifies tokens and validates their claims.
+type Verifier struct {
+	keys       KeySet
+	algs       map[string]bool
+	audience   string
+	issuer     string
+	leeway     time.Duration
+	now        func() time.Time
+	requireExp bool
+}
+
+// VerifierOpt defines the type for Verifier options.
+type VerifierOpt func(*Verifier)
+
+// WithAudience returns an option that requires the token audience to contain aud.
+func WithAudience(aud string) VerifierOpt {
+	return func(v *Verifier) {
+		v.audience = aud
+	}
+}
+
+// WithIssuer returns an option that requires the token issuer to be iss.
+func WithIssuer(iss string) VerifierOpt {
+	return func(v *Verifier) {
+		v.issuer = iss
+	}
+}
+
+// WithLeeway returns an option that tolerates clock skews up to d
+// when validating the exp and nbf claims.
+func WithLeeway(d time.Duration) VerifierOpt {
+	return func(v *Verifier) {
+		v.leeway = d
+	}
+}
+
+// WithClock returns an option that sets the function used to get the current time.
+func WithClock(now func() time.Time) VerifierOpt {
+	return func(v *Verifier) {
+		v.now = now
+	}
+}
+
+// WithAlgorithms returns an option that restricts the accepted algorithms.
+// All the supported algorithms are accepted by default.
+func WithAlgorithms(algs ...string) VerifierOpt {
+	return func(v *Verifier) {
+		v.algs = map[string]bool{}
+		for _, a := range algs {
+			v.algs[a] = true
+		}
+	}
+}
+
+// WithRequiredExpiration returns an option that rejects tokens without the exp claim.
+func WithRequiredExpiration(required bool) VerifierOpt {
+	return func(v *Verifier) {
+		v.requireExp = required
+	}
+}
+
+// NewVerifier constructs a Verifier using keys to check signatures.
+// By default tokens must have an exp claim.
+func NewVerifier(keys KeySet, opts ...VerifierOpt) *Verifier {
+	v := &Verifier{
+		keys:       keys,
+		algs:       map[string]bool{HS256: true, RS256: true, ES256: true},
+		now:        time.Now,
+		requireExp: true,
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// Verify checks the signature of the token and validates its claims.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, ErrMalformed
+	}
+	if !v.algs[h.Alg] {
+		return nil, fmt.Errorf("%w: %q", ErrAlgorithm, h.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	key, err := v.keys.Key(ctx, h.Kid, h.Alg)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(h.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrMalformed
+	}
+	if err := decodeSegment(parts[1], &claims.Raw); err != nil {
+		return nil, ErrMalformed
+	}
+	if err := v.validate(&claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+func (v *Verifier) validate(c *Claims) error {
+	now := v.now()
+	if c.ExpiresAt == 0 && v.requireExp {
+		return ErrMissingExp
+	}
+	if c.ExpiresAt != 0 && !now.Before(c.ExpiresAt.Time().Add(v.leeway)) {
+		return ErrExpired
+	}
+	if c.NotBefore != 0 && now.Add(v.leeway).Before(c.NotBefore.Time()) {
+		return ErrNotYetValid
+	}
+	if v.audience != "" && !c.Audience.Contains(v.audience) {
+		return ErrAudience
+	}
+	if v.issuer != "" && c.Issuer != v.issuer {
+		return ErrIssuer
+	}
+	return nil
+}
+
+func verifySignature(alg string, key interface{}, signed string, sig []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+	switch alg {
+	case HS256:
+		k, ok := key.([]byte)
+		if !ok {
+			return ErrUnknownKey
+		}
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return ErrSignature
+		}
+	case RS256:
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrUnknownKey
+		}
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig); err != nil {
+			return ErrSignature
+		}
+	case ES256:
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrUnknownKey
+		}
+		if len(sig) != 64 {
+			return ErrSignature
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(k, digest[:], r, s) {
+			return ErrSignature
+		}
+	default:
+		return ErrAlgorithm
+	}
+	return nil
+}
+
+// Sign creates a token with the given claims, signed with key according to alg.
+// The key must be a []byte for HS256, an *rsa.PrivateKey for RS256 and
+// an *ecdsa.PrivateKey for ES256. It is mainly intended for tests and tools.
+func Sign(claims interface{}, alg string, kid string, key interface{}) (string, error) {
+	hb, err := json.Marshal(header{Alg: alg, Kid: kid, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	cb, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(hb) + "." + base64.RawURLEncoding.EncodeToString(cb)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		if alg != HS256 {
+			return "", ErrAlgorithm
+		}
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		if alg != RS256 {
+			return "", ErrAlgorithm
+		}
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			return "", err
+		}
+	case *ecdsa.PrivateKey:
+		if alg != ES256 {
+			return "", ErrAlgorithm
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			return "", err
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	default:
+		return "", ErrUnknownKey
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,149 @@
+package jwt_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/polldo/patweb/api/jwt"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmacKey := []byte("secret")
+
+	// A local stand-in for the JWKS endpoint of an identity provider.
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "n": b64(rsaKey.N.Bytes()), "e": "AQAB"},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+	}})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(jwks)
+	}))
+	defer srv.Close()
+
+	remote := jwt.NewRemoteJWKS(srv.URL, srv.Client(), time.Hour)
+	static := jwt.StaticKeys{"hmac": hmacKey}
+	opts := []jwt.VerifierOpt{jwt.WithAudience("api"), jwt.WithIssuer("idp"), jwt.WithClock(func() time.Time { return now })}
+
+	valid := jwt.Claims{Issuer: "idp", Subject: "user", Audience: jwt.Audience{"api"}, ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)), Scope: "read write"}
+	expired := valid
+	expired.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute))
+	wrongAud := valid
+	wrongAud.Audience = jwt.Audience{"other"}
+
+	tests := []struct {
+		name   string
+		keys   jwt.KeySet
+		claims jwt.Claims
+		alg    string
+		kid    string
+		key    interface{}
+		err    error
+	}{
+		{"hs256", static, valid, jwt.HS256, "hmac", hmacKey, nil},
+		{"rs256", remote, valid, jwt.RS256, "rsa", rsaKey, nil},
+		{"es256", remote, valid, jwt.ES256, "ec", ecKey, nil},
+		{"wrong key", static, valid, jwt.HS256, "hmac", []byte("other"), jwt.ErrSignature},
+		{"unknown kid", remote, valid, jwt.RS256, "missing", rsaKey, jwt.ErrUnknownKey},
+		{"algorithm confusion", static, valid, jwt.RS256, "hmac", rsaKey, jwt.ErrUnknownKey},
+		{"expired", static, expired, jwt.HS256, "hmac", hmacKey, jwt.ErrExpired},
+		{"audience", static, wrongAud, jwt.HS256, "hmac", hmacKey, jwt.ErrAudience},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := jwt.Sign(tt.claims, tt.alg, tt.kid, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := jwt.NewVerifier(tt.keys, opts...).Verify(context.Background(), token)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("want error %v, got %v", tt.err, err)
+			}
+			if err == nil && (claims.Subject != "user" || !claims.HasScope("write")) {
+				t.Errorf("unexpected claims %+v", claims)
+			}
+		})
+	}
+}
+
+func TestRemoteJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "n": b64(rsaKey.N.Bytes()), "e": "AQAB"},
+		{"kty": "oct", "kid": "hmac", "k": b64([]byte("secret"))},
+	}})
+
+	var hits int32
+	var fail int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		time.Sleep(20 * time.Millisecond)
+		if atomic.LoadInt32(&fail) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(jwks)
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	// Concurrent requests share the same fetch.
+	remote := jwt.NewRemoteJWKS(srv.URL, srv.Client(), time.Hour)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := remote.Key(ctx, "rsa", jwt.RS256); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Errorf("want 1 fetch, got %d", n)
+	}
+
+	// Symmetric keys of remote sets are never accepted.
+	if _, err := remote.Key(ctx, "hmac", jwt.HS256); !errors.Is(err, jwt.ErrUnknownKey) {
+		t.Errorf("want error %v for oct key, got %v", jwt.ErrUnknownKey, err)
+	}
+
+	// Failed attempts are rate limited too.
+	atomic.StoreInt32(&fail, 1)
+	atomic.StoreInt32(&hits, 0)
+	failing := jwt.NewRemoteJWKS(srv.URL, srv.Client(), time.Hour)
+	for i := 0; i < 3; i++ {
+		if _, err := failing.Key(ctx, "rsa", jwt.RS256); err == nil {
+			t.Error("want error from failing jwks")
+		}
+	}
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Errorf("want 1 failed fetch, got %d", n)
+	}
+}
@@ -0,0 +1,264 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// KeySet provides the keys used to verify token signatures.
+type KeySet interface {
+	// Key returns the verification key identified by kid, for the algorithm alg.
+	// An empty kid is used by tokens that don't specify a key.
+	Key(ctx context.Context, kid string, alg string) (interface{}, error)
+}
+
+// StaticKeys is a KeySet of keys indexed by their ID.
+// Keys are []byte for HS256, *rsa.PublicKey for RS256 and *ecdsa.PublicKey for ES256.
+type StaticKeys map[string]interface{}
+
+// Key implements the KeySet interface.
+func (s StaticKeys) Key(ctx context.Context, kid string, alg string) (interface{}, error) {
+	if k, ok := s[kid]; ok && keyMatches(k, alg) {
+		return k, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+}
+
+// keyMatches reports whether the type of the key is the one required by alg.
+func keyMatches(key interface{}, alg string) bool {
+	switch key.(type) {
+	case []byte:
+		return alg == HS256
+	case *rsa.PublicKey:
+		return alg == RS256
+	case *ecdsa.PublicKey:
+		return alg == ES256
+	}
+	return false
+}
+
+// jwk is a JSON Web Key, limited to the fields of the supported key types.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS parses a JSON Web Key Set document into a StaticKeys set.
+// Keys not meant for signatures or of unsupported types are skipped, as
+// symmetric keys are: a key set is public, and accepting its 'oct' keys
+// would let anyone knowing them sign tokens. HMAC keys must be StaticKeys.
+func ParseJWKS(b []byte) (StaticKeys, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("cannot decode jwks: %w", err)
+	}
+
+	keys := StaticKeys{}
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.key()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", k.Kid, err)
+		}
+		if key == nil || (k.Alg != "" && !keyMatches(key, k.Alg)) {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k jwk) key() (interface{}, error) {
+	dec := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := dec(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := dec(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, nil
+		}
+		x, err := dec(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := dec(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("point not on curve")
+		}
+		return pub, nil
+	}
+	return nil, nil
+}
+
+// minRefreshInterval limits how often a JWKS is reloaded by Key,
+// because of unknown keys or after failed attempts.
+const minRefreshInterval = 30 * time.Second
+
+// JWKS is a KeySet loaded from a JSON Web Key Set document.
+// The document is reloaded when it's older than its TTL or when a token
+// refers to an unknown key, so that rotated keys are picked up, but at most
+// every 30 seconds, failed attempts included. Concurrent requests share
+// the same reload, and keep using the previous keys if it fails.
+type JWKS struct {
+	load func(ctx context.Context) ([]byte, error)
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu        sync.Mutex
+	keys      StaticKeys
+	loaded    time.Time
+	attempted time.Time
+	err       error
+	call      *jwksCall
+}
+
+// jwksCall is a reload in progress, shared by concurrent callers.
+type jwksCall struct {
+	done chan struct{}
+	err  error
+}
+
+// NewJWKSFromFile constructs a JWKS reading the document from the file at path.
+// The file is loaded immediately so that errors are reported at startup.
+func NewJWKSFromFile(path string, ttl time.Duration) (*JWKS, error) {
+	j := &JWKS{
+		load: func(ctx context.Context) ([]byte, error) { return os.ReadFile(path) },
+		ttl:  ttl,
+		now:  time.Now,
+	}
+	if err := j.Reload(context.Background()); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// NewRemoteJWKS constructs a JWKS fetching the document from url.
+// The document is fetched lazily, the first time a key is needed.
+// http.DefaultClient is used if client is nil.
+func NewRemoteJWKS(url string, client *http.Client, ttl time.Duration) *JWKS {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	load := func(ctx context.Context) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	}
+	return &JWKS{load: load, ttl: ttl, now: time.Now}
+}
+
+// Reload loads the document again.
+func (j *JWKS) Reload(ctx context.Context) error {
+	j.mu.Lock()
+	if c := j.call; c != nil {
+		j.mu.Unlock()
+		select {
+		case <-c.done:
+			return c.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	c := &jwksCall{done: make(chan struct{})}
+	j.call = c
+	j.mu.Unlock()
+
+	// The document is fetched without holding the lock, so that the
+	// current keys can still be read.
+	keys, err := j.fetch(ctx)
+
+	j.mu.Lock()
+	j.attempted = j.now()
+	if err == nil {
+		j.keys = keys
+		j.loaded = j.attempted
+	}
+	j.err = err
+	j.call = nil
+	j.mu.Unlock()
+
+	c.err = err
+	close(c.done)
+	return err
+}
+
+func (j *JWKS) fetch(ctx context.Context) (StaticKeys, error) {
+	b, err := j.load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load jwks: %w", err)
+	}
+	return ParseJWKS(b)
+}
+
+// Key implements the KeySet interface.
+func (j *JWKS) Key(ctx context.Context, kid string, alg string) (interface{}, error) {
+	j.mu.Lock()
+	now := j.now()
+	keys, lastErr := j.keys, j.err
+	fresh := keys != nil && (j.ttl <= 0 || now.Sub(j.loaded) <= j.ttl)
+	retry := j.attempted.IsZero() || now.Sub(j.attempted) > minRefreshInterval
+	j.mu.Unlock()
+
+	if fresh {
+		if k, err := keys.Key(ctx, kid, alg); err == nil {
+			return k, nil
+		}
+		// Unknown key, the set may have been rotated.
+	}
+	if retry {
+		if err := j.Reload(ctx); err != nil && keys == nil {
+			return nil, err
+		}
+		j.mu.Lock()
+		keys = j.keys
+		j.mu.Unlock()
+	}
+	if keys == nil {
+		return nil, lastErr
+	}
+	return keys.Key(ctx, kid, alg)
+}
@@ -0,0 +1,125 @@
+// Package maintenance switches the API, or groups of its routes,
+// in and out of maintenance at runtime.
+package maintenance
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// State describes the maintenance of the API.
+type State struct {
+	Enabled bool
+
+	// Groups are the route groups under maintenance, all if empty.
+	Groups []string
+
+	// Message is returned to the rejected clients.
+	Message string
+
+	// RetryAfter is the time after which clients should retry.
+	RetryAfter time.Duration
+
+	// Since is the time of the last change of state.
+	Since time.Time
+}
+
+// Covers reports whether the state puts the route group under maintenance.
+func (s State) Covers(group string) bool {
+	if !s.Enabled {
+		return false
+	}
+	if len(s.Groups) == 0 {
+		return true
+	}
+	for _, g := range s.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// Switch holds the maintenance state. It's safe for concurrent use.
+type Switch struct {
+	log logrus.FieldLogger
+	now func() time.Time
+
+	mu    sync.RWMutex
+	state State
+}
+
+// NewSwitch constructs a Switch, initially disabled.
+// Changes of state are logged to log.
+func NewSwitch(log logrus.FieldLogger) *Switch {
+	return &Switch{log: log, now: time.Now, state: State{Since: time.Now().UTC()}}
+}
+
+// State returns the current state.
+func (s *Switch) State() State {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state
+}
+
+// Enable puts the given route groups under maintenance, or all the routes
+// if none is given, replacing the previous state.
+func (s *Switch) Enable(message string, retryAfter time.Duration, groups ...string) {
+	s.update(func(State) State {
+		return State{Enabled: true, Groups: groups, Message: message, RetryAfter: retryAfter}
+	})
+}
+
+// Disable ends the maintenance. The settings are kept for Toggle.
+func (s *Switch) Disable() {
+	s.update(func(old State) State {
+		old.Enabled = false
+		return old
+	})
+}
+
+// Toggle enables or disables the maintenance, keeping the settings
+// of the last time it was enabled.
+func (s *Switch) Toggle() {
+	s.update(func(old State) State {
+		old.Enabled = !old.Enabled
+		return old
+	})
+}
+
+// update changes the state and logs the change.
+func (s *Switch) update(change func(old State) State) {
+	s.mu.Lock()
+	st := change(s.state)
+	st.Since = s.now().UTC()
+	s.state = st
+	s.mu.Unlock()
+
+	s.log.WithFields(logrus.Fields{
+		"enabled":     st.Enabled,
+		"groups":      strings.Join(st.Groups, ","),
+		"retry_after": st.RetryAfter.String(),
+	}).Info("maintenance changed")
+}
+
+// ToggleOnSignal toggles the maintenance whenever one of the signals,
+// like SIGUSR1, is received, until ctx is done.
+func (s *Switch) ToggleOnSignal(ctx context.Context, sig ...os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sig...)
+	defer signal.Stop(ch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch:
+			s.Toggle()
+		}
+	}
+}
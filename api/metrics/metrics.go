@@ -0,0 +1,118 @@
+// Package metrics implements a minimal set of metrics exposed in the
+// Prometheus text exposition format (version 0.0.4).
+//
+// Metrics are registered in a Registry, which can be shared between the
+// framework middleware and the handlers that need their own metrics.
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// ContentType is the content type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Collector is a metric family that can be written in the text exposition format.
+type Collector interface {
+	// Name returns the name of the metric family, unique within a registry.
+	Name() string
+
+	// Write writes the HELP and TYPE lines followed by all the samples.
+	Write(w io.Writer) error
+}
+
+// Registry holds a set of collectors.
+type Registry interface {
+	// Register adds a collector to the registry.
+	// It fails if a collector with the same name is already registered.
+	Register(c Collector) error
+
+	// Unregister removes the collector with the given name, if present.
+	Unregister(name string) bool
+
+	// WriteText writes all the registered collectors, sorted by name.
+	WriteText(w io.Writer) error
+}
+
+// NewRegistry constructs an empty, concurrency safe, Registry.
+func NewRegistry() Registry {
+	return &registry{collectors: map[string]Collector{}}
+}
+
+// MustRegister registers the collectors, panicking on failure.
+// It is intended for the setup of the application.
+func MustRegister(reg Registry, cs ...Collector) {
+	for _, c := range cs {
+		if err := reg.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+var nameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// registry is the default Registry implementation.
+type registry struct {
+	mu         sync.RWMutex
+	collectors map[string]Collector
+}
+
+func (r *registry) Register(c Collector) error {
+	name := c.Name()
+	if !nameRE.MatchString(name) {
+		return fmt.Errorf("invalid metric name %q", name)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.collectors[name]; ok {
+		return fmt.Errorf("metric %q already registered", name)
+	}
+	r.collectors[name] = c
+	return nil
+}
+
+func (r *registry) Unregister(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.collectors[name]
+	delete(r.collectors, name)
+	return ok
+}
+
+func (r *registry) WriteText(w io.Writer) error {
+	r.mu.RLock()
+	cs := make([]Collector, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		cs = append(cs, c)
+	}
+	r.mu.RUnlock()
+	sort.Slice(cs, func(i, j int) bool { return cs[i].Name() < cs[j].Name() })
+
+	bw := bufio.NewWriter(w)
+	for _, c := range cs {
+		if err := c.Write(bw); err != nil {
+			return fmt.Errorf("cannot write metric %q: %w", c.Name(), err)
+		}
+	}
+	return bw.Flush()
+}
+
+// Handler returns an http.Handler exposing the metrics of the registry.
+func Handler(reg Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		if err := reg.WriteText(&buf); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", ContentType)
+		_, _ = buf.WriteTo(w)
+	})
+}
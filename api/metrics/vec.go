@@ -0,0 +1,258 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the default histogram buckets, in seconds,
+// tailored to measure the latency of network services.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// family contains what is shared by all the vector metrics.
+// Series are identified by the values of their labels.
+type family struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is a single labeled time series.
+type series struct {
+	values []string
+	value  float64
+
+	// Histograms only.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newFamily(name, help, typ string, labels []string) family {
+	return family{name: name, help: help, typ: typ, labels: labels, series: map[string]*series{}}
+}
+
+func (f *family) Name() string { return f.name }
+
+// get returns the series for the label values. It must be called with the lock held.
+func (f *family) get(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %q: want %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		f.series[key] = s
+	}
+	return s
+}
+
+// sorted returns the series sorted by label values. It must be called with the lock held.
+func (f *family) sorted() []*series {
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	ss := make([]*series, len(keys))
+	for i, k := range keys {
+		ss[i] = f.series[k]
+	}
+	return ss
+}
+
+func (f *family) writeHeader(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.typ)
+	return err
+}
+
+// labelPairs formats the labels of a series, with optional extra pairs
+// appended, as '{k1="v1",k2="v2"}'.
+func (f *family) labelPairs(values []string, extra ...string) string {
+	if len(values) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, l := range f.labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l + `="` + escapeLabel(values[i]) + `"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extra[i] + `="` + escapeLabel(extra[i+1]) + `"`)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// CounterVec is a set of monotonically increasing counters partitioned by labels.
+type CounterVec struct{ family }
+
+// NewCounterVec constructs a counter with the given label names.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{newFamily(name, help, "counter", labels)}
+}
+
+// Inc increments by one the counter identified by the label values.
+func (c *CounterVec) Inc(values ...string) { c.Add(1, values...) }
+
+// Add adds v, that must not be negative, to the counter identified by the label values.
+func (c *CounterVec) Add(v float64, values ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("metric %q: counters cannot decrease", c.name))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.get(values).value += v
+}
+
+// Write implements the Collector interface.
+func (c *CounterVec) Write(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.writeHeader(w); err != nil {
+		return err
+	}
+	for _, s := range c.sorted() {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(s.values), formatFloat(s.value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GaugeVec is a set of values that can go up and down, partitioned by labels.
+type GaugeVec struct{ family }
+
+// NewGaugeVec constructs a gauge with the given label names.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{newFamily(name, help, "gauge", labels)}
+}
+
+// Set sets the gauge identified by the label values.
+func (g *GaugeVec) Set(v float64, values ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.get(values).value = v
+}
+
+// Add adds v to the gauge identified by the label values.
+func (g *GaugeVec) Add(v float64, values ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.get(values).value += v
+}
+
+// Inc increments by one the gauge identified by the label values.
+func (g *GaugeVec) Inc(values ...string) { g.Add(1, values...) }
+
+// Dec decrements by one the gauge identified by the label values.
+func (g *GaugeVec) Dec(values ...string) { g.Add(-1, values...) }
+
+// Write implements the Collector interface.
+func (g *GaugeVec) Write(w io.Writer) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.writeHeader(w); err != nil {
+		return err
+	}
+	for _, s := range g.sorted() {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelPairs(s.values), formatFloat(s.value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HistogramVec is a set of histograms partitioned by labels.
+type HistogramVec struct {
+	family
+	buckets []float64
+}
+
+// NewHistogramVec constructs a histogram with the given upper bounds and label names.
+// DefaultBuckets are used if buckets is empty.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	return &HistogramVec{family: newFamily(name, help, "histogram", labels), buckets: b}
+}
+
+// Observe adds an observation to the histogram identified by the label values.
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(values)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(h.buckets))
+	}
+	for i, ub := range h.buckets {
+		if v <= ub {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// Write implements the Collector interface.
+func (h *HistogramVec) Write(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.writeHeader(w); err != nil {
+		return err
+	}
+	for _, s := range h.sorted() {
+		for i, ub := range h.buckets {
+			le := h.labelPairs(s.values, "le", formatFloat(ub))
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, le, s.counts[i]); err != nil {
+				return err
+			}
+		}
+		inf := h.labelPairs(s.values, "le", "+Inf")
+		lp := h.labelPairs(s.values)
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n%s_sum%s %s\n%s_count%s %d\n",
+			h.name, inf, s.count, h.name, lp, formatFloat(s.sum), h.name, lp, s.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
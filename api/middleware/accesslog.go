@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AccessEntry describes a completed request for the access log.
+type AccessEntry struct {
+	Time      time.Time
+	RequestID string
+	TraceID   string
+	Method    string
+	Path      string
+
+	// RemoteAddr is the client address resolved by RealIP, if in use,
+	// or the remote address of the request.
+	RemoteAddr string
+
+	Route         string
+	Principal     string
+	Status        int
+	RequestBytes  int64
+	ResponseBytes int64
+	Duration      time.Duration
+
+	r *http.Request
+}
+
+// AccessFormat formats an entry of the access log, with the optional fields.
+type AccessFormat func(e *AccessEntry, fields []LogField) []byte
+
+// baseFields returns the fields known when the request starts.
+func (e *AccessEntry) baseFields() logrus.Fields {
+	f := logrus.Fields{
+		"method":     e.Method,
+		"path":       e.Path,
+		"remoteaddr": e.RemoteAddr,
+	}
+	if e.RequestID != "" {
+		f["req_id"] = e.RequestID
+	}
+	if e.TraceID != "" {
+		f["trace_id"] = e.TraceID
+	}
+	return f
+}
+
+// completionFields returns the fields of a completed request,
+// without the base ones.
+func (e *AccessEntry) completionFields(optional []LogField) logrus.Fields {
+	f := logrus.Fields{
+		"statuscode": e.Status,
+		"bytes":      e.ResponseBytes,
+		"since":      e.Duration.Nanoseconds(),
+	}
+	for _, name := range optional {
+		switch name {
+		case LogUserAgent:
+			f[string(name)] = e.r.UserAgent()
+		case LogReferer:
+			f[string(name)] = e.r.Referer()
+		case LogRoute:
+			f[string(name)] = e.Route
+		case LogRequestBytes:
+			f[string(name)] = e.RequestBytes
+		case LogPrincipal:
+			f[string(name)] = e.Principal
+		}
+	}
+	return f
+}
+
+// fields returns all the fields of the entry.
+func (e *AccessEntry) fields(optional []LogField) logrus.Fields {
+	f := e.baseFields()
+	for k, v := range e.completionFields(optional) {
+		f[k] = v
+	}
+	return f
+}
+
+// FormatAccessJSON formats an entry as a JSON object, with the time
+// in RFC 3339 format.
+func FormatAccessJSON(e *AccessEntry, optional []LogField) []byte {
+	f := e.fields(optional)
+	f["time"] = e.Time.Format(time.RFC3339Nano)
+	b, _ := json.Marshal(f)
+	return b
+}
+
+// FormatAccessLogfmt formats an entry as logfmt key=value pairs,
+// with the time first and the other keys sorted.
+func FormatAccessLogfmt(e *AccessEntry, optional []LogField) []byte {
+	f := e.fields(optional)
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("time=" + e.Time.Format(time.RFC3339Nano))
+	for _, k := range keys {
+		b.WriteString(" " + k + "=")
+		switch v := f[k].(type) {
+		case string:
+			b.WriteString(logfmtValue(v))
+		case int:
+			b.WriteString(strconv.Itoa(v))
+		case int64:
+			b.WriteString(strconv.FormatInt(v, 10))
+		}
+	}
+	return []byte(b.String())
+}
+
+// logfmtValue quotes a value if needed.
+func logfmtValue(v string) string {
+	if v == "" || strings.ContainsAny(v, " =\"\\\t\n") {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+// FormatAccessCombined formats an entry in the NCSA combined log format.
+// The optional fields are ignored, as the format is fixed, and the
+// authenticated principal is used as user.
+func FormatAccessCombined(e *AccessEntry, _ []LogField) []byte {
+	host := remoteHost(e.RemoteAddr)
+	user := "-"
+	if e.Principal != "" {
+		user = e.Principal
+	}
+	size := "-"
+	if e.ResponseBytes > 0 {
+		size = strconv.FormatInt(e.ResponseBytes, 10)
+	}
+	line := combinedValue(host) + " - " + strings.ReplaceAll(user, " ", "_") +
+		" [" + e.Time.Format("02/Jan/2006:15:04:05 -0700") + "] " +
+		strconv.Quote(e.Method+" "+e.r.URL.RequestURI()+" "+e.r.Proto) + " " +
+		strconv.Itoa(e.Status) + " " + size + " " +
+		strconv.Quote(combinedValue(e.r.Referer())) + " " + strconv.Quote(combinedValue(e.r.UserAgent()))
+	return []byte(line)
+}
+
+// combinedValue replaces the empty values with a dash.
+func combinedValue(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/polldo/patweb/api/audit"
+	"github.com/polldo/patweb/api/web"
+	"github.com/sirupsen/logrus"
+	"github.com/zenazn/goji/web/mutil"
+)
+
+// auditEvents collects the events recorded by handlers with web.Audit.
+type auditEvents struct {
+	mu     sync.Mutex
+	events []audit.Event
+}
+
+func (a *auditEvents) RecordAudit(e audit.Event) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.events = append(a.events, e)
+}
+
+// Audit writes to sink the audit events recorded by handlers with web.Audit.
+// Requests with unsafe methods that don't record any event get a default one,
+// whose action is the method and the route of the request.
+//
+// Events are completed with the request ID, the actor, if empty, and the
+// outcome of the request. Since the outcome includes the status of the error
+// responses, it must be placed before the Errors middleware.
+// Failures of the sink are logged, as the response has already been sent.
+func Audit(sink audit.Sink, log logrus.FieldLogger) web.Middleware {
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			rec := &auditEvents{}
+			ctx = web.ContextWithAuditRecorder(ctx, rec)
+			ctx, slot := withPrincipalSlot(ctx)
+
+			lw := mutil.WrapWriter(w)
+			err := handler(ctx, lw, r)
+
+			rec.mu.Lock()
+			events := rec.events
+			rec.mu.Unlock()
+			var actor string
+			if p := slot.get(); p != nil {
+				actor = p.ID
+			}
+
+			route, _ := routeTemplate(r)
+			if len(events) == 0 && unsafeMethod(r.Method) {
+				events = []audit.Event{{Action: r.Method + " " + route, Resource: r.URL.Path}}
+			}
+
+			status := lw.Status()
+			if status == 0 {
+				status = http.StatusOK
+				if err != nil {
+					status = errorStatus(err)
+				}
+			}
+			now := time.Now().UTC()
+			for _, e := range events {
+				if e.Time.IsZero() {
+					e.Time = now
+				}
+				if e.Actor == "" {
+					e.Actor = actor
+				}
+				e.RequestID = ContextRequestID(ctx)
+				e.Method, e.Route = r.Method, route
+				e.ClientIP = ClientIP(ctx, r)
+				e.Outcome = audit.Outcome{Status: status, Success: status < http.StatusBadRequest}
+
+				if serr := sink.Write(web.Detach(ctx), e); serr != nil {
+					log.WithFields(logrus.Fields{
+						"req_id":  e.RequestID,
+						"action":  e.Action,
+						"message": serr,
+					}).Error("cannot write audit event")
+				}
+			}
+			return err
+		}
+		return h
+	}
+	return m
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/polldo/patweb/api/audit"
+	"github.com/polldo/patweb/api/auth"
+	"github.com/polldo/patweb/api/web"
+	"github.com/sirupsen/logrus"
+)
+
+func TestAudit(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	sink := audit.NewMemorySink()
+	store := auth.NewMemoryStore()
+	if err := store.AddAPIKey("alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		if r.Method == http.MethodDelete {
+			return newRequestError(errors.New("missing"), http.StatusNotFound, "order not found")
+		}
+		web.Audit(ctx, audit.Event{Action: "order.update", Resource: "orders/1", Before: 1, After: 2})
+		return web.Respond(ctx, w, nil, http.StatusNoContent)
+	}
+	global := []web.Middleware{RequestID(), Audit(sink, log), Errors(log)}
+	h := web.WrapMiddleware(global, web.WrapMiddleware([]web.Middleware{APIKey(store, "")}, handler))
+
+	for _, method := range []string{http.MethodPut, http.MethodDelete, http.MethodGet} {
+		r := httptest.NewRequest(method, "/orders/1", nil)
+		r.Header.Set(APIKeyHeader, "secret")
+		if err := h(r.Context(), httptest.NewRecorder(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events := sink.Events()
+	if len(events) != 3 {
+		t.Fatalf("want 3 events, got %d", len(events))
+	}
+	want := []struct {
+		method, action string
+		status         int
+	}{
+		{http.MethodPut, "order.update", http.StatusNoContent},
+		{http.MethodDelete, "DELETE /orders/1", http.StatusNotFound},
+		{http.MethodGet, "order.update", http.StatusNoContent},
+	}
+	for i, e := range events {
+		if e.Method != want[i].method || e.Action != want[i].action || e.Outcome.Status != want[i].status {
+			t.Errorf("event %d: want %s %s %d, got %s %s %d", i, want[i].method, want[i].action, want[i].status, e.Method, e.Action, e.Outcome.Status)
+		}
+		if e.Actor != "alice" || e.RequestID == "" || e.Time.IsZero() {
+			t.Errorf("event %d: want actor, request id and time, got %+v", i, e)
+		}
+	}
+}
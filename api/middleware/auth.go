@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/polldo/patweb/api/auth"
+	"github.com/polldo/patweb/api/web"
+	"github.com/polldo/patweb/api/weberr"
+)
+
+// principalKeyCtx is the private type used to store the principal in the context.
+type principalKeyCtx int
+
+// principalKey is the context key used to store the authenticated principal.
+const principalKey principalKeyCtx = 1
+
+// APIKeyHeader is the default header carrying API keys.
+const APIKeyHeader = "X-Api-Key"
+
+// APIKey authenticates requests carrying an API key in the given header,
+// APIKeyHeader if empty. The principal owning the key is stored in the
+// context, retrieve it using ContextPrincipal.
+// Requests without a valid key fail with a quiet 401 error.
+func APIKey(v auth.KeyVerifier, header string) web.Middleware {
+	if header == "" {
+		header = APIKeyHeader
+	}
+	challenge := `ApiKey header="` + header + `"`
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			key := r.Header.Get(header)
+			if key == "" {
+				return unauthorized(errors.New("missing api key"), challenge)
+			}
+
+			p, err := v.VerifyAPIKey(ctx, key)
+			if errors.Is(err, auth.ErrInvalidCredentials) {
+				return unauthorized(fmt.Errorf("invalid api key: %w", err), challenge)
+			}
+			if err != nil {
+				return fmt.Errorf("cannot verify api key: %w", err)
+			}
+
+			ctx = contextWithPrincipal(ctx, p)
+			return handler(ctx, w, r)
+		}
+		return h
+	}
+	return m
+}
+
+// BasicAuth authenticates requests with the Basic HTTP authentication scheme.
+// The authenticated principal is stored in the context, retrieve it using ContextPrincipal.
+// Requests without valid credentials fail with a quiet 401 error
+// carrying the WWW-Authenticate challenge for the given realm.
+func BasicAuth(v auth.PasswordVerifier, realm string) web.Middleware {
+	challenge := `Basic realm="` + quoteEscape(realm) + `", charset="UTF-8"`
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			user, password, ok := r.BasicAuth()
+			if !ok {
+				return unauthorized(errors.New("missing basic credentials"), challenge)
+			}
+
+			p, err := v.VerifyPassword(ctx, user, password)
+			if errors.Is(err, auth.ErrInvalidCredentials) {
+				err = weberr.Wrap(err, weberr.WithFields(map[string]interface{}{"user": user}))
+				return unauthorized(fmt.Errorf("invalid basic credentials: %w", err), challenge)
+			}
+			if err != nil {
+				return fmt.Errorf("cannot verify basic credentials: %w", err)
+			}
+
+			ctx = contextWithPrincipal(ctx, p)
+			return handler(ctx, w, r)
+		}
+		return h
+	}
+	return m
+}
+
+// RequireRoles fails with a quiet 403 error the requests whose principal
+// has not been granted all the roles, or with a quiet 401 error the
+// unauthenticated ones. It must follow an authentication middleware,
+// usually as a route specific middleware passed to api.Handle.
+func RequireRoles(roles ...string) web.Middleware {
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			p, ok := ContextPrincipal(ctx)
+			if !ok {
+				return unauthorized(errors.New("missing principal"), "")
+			}
+			for _, role := range roles {
+				if !p.HasRole(role) {
+					err := fmt.Errorf("principal %q misses role %q", p.ID, role)
+					return newRequestError(err, http.StatusForbidden, "forbidden",
+						weberr.WithFields(map[string]interface{}{"principal": p.ID, "roles": strings.Join(roles, ",")}),
+						weberr.WithQuiet(true),
+					)
+				}
+			}
+			return handler(ctx, w, r)
+		}
+		return h
+	}
+	return m
+}
+
+// principalSlotKeyCtx is the private type used to store the principal slot in the context.
+type principalSlotKeyCtx int
+
+// principalSlotKey is the context key used to store the principal slot.
+const principalSlotKey principalSlotKeyCtx = 1
+
+// principalSlot lets the middlewares that run before authentication, like
+// Logger and Audit, know the principal authenticated by the following ones.
+type principalSlot struct {
+	mu sync.Mutex
+	p  *auth.Principal
+}
+
+func (s *principalSlot) get() *auth.Principal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.p
+}
+
+// withPrincipalSlot returns the principal slot of the request,
+// adding it to the context if missing.
+func withPrincipalSlot(ctx context.Context) (context.Context, *principalSlot) {
+	if s, ok := ctx.Value(principalSlotKey).(*principalSlot); ok {
+		return ctx, s
+	}
+	s := &principalSlot{}
+	s.p, _ = ContextPrincipal(ctx)
+	return context.WithValue(ctx, principalSlotKey, s), s
+}
+
+// contextWithPrincipal stores the authenticated principal in the context,
+// and in the principal slot of the request, if any.
+func contextWithPrincipal(ctx context.Context, p *auth.Principal) context.Context {
+	if s, ok := ctx.Value(principalSlotKey).(*principalSlot); ok {
+		s.mu.Lock()
+		s.p = p
+		s.mu.Unlock()
+	}
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// ContextPrincipal extracts the authenticated principal from the context.
+func ContextPrincipal(ctx context.Context) (*auth.Principal, bool) {
+	p, ok := ctx.Value(principalKey).(*auth.Principal)
+	return p, ok
+}
+
+// ContextSubject returns the ID of the authenticated principal,
+// or an empty string if the request is not authenticated.
+// It can be used with KeyBySubject to rate limit principals.
+func ContextSubject(ctx context.Context) string {
+	if p, ok := ContextPrincipal(ctx); ok {
+		return p.ID
+	}
+	return ""
+}
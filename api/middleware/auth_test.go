@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/polldo/patweb/api/auth"
+	"github.com/polldo/patweb/api/web"
+	"github.com/sirupsen/logrus"
+)
+
+func TestAuth(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	store := auth.NewMemoryStore()
+	if err := store.AddAPIKey("ci", "key", "deploy"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetPassword("ops", "pass", "read"); err != nil {
+		t.Fatal(err)
+	}
+
+	ok := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return web.Respond(ctx, w, ContextSubject(ctx), http.StatusOK)
+	}
+	apiKey := web.WrapMiddleware([]web.Middleware{Errors(log), APIKey(store, ""), RequireRoles("deploy")}, ok)
+	basic := web.WrapMiddleware([]web.Middleware{Errors(log), BasicAuth(store, "tools"), RequireRoles("deploy")}, ok)
+
+	tests := []struct {
+		name   string
+		h      web.Handler
+		setup  func(r *http.Request)
+		status int
+	}{
+		{"api key", apiKey, func(r *http.Request) { r.Header.Set(APIKeyHeader, "key") }, http.StatusOK},
+		{"missing api key", apiKey, func(r *http.Request) {}, http.StatusUnauthorized},
+		{"invalid api key", apiKey, func(r *http.Request) { r.Header.Set(APIKeyHeader, "bad") }, http.StatusUnauthorized},
+		{"basic without role", basic, func(r *http.Request) { r.SetBasicAuth("ops", "pass") }, http.StatusForbidden},
+		{"basic wrong password", basic, func(r *http.Request) { r.SetBasicAuth("ops", "bad") }, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			tt.setup(r)
+			w := httptest.NewRecorder()
+			if err := tt.h(r.Context(), w, r); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.status {
+				t.Errorf("want status %d, got %d", tt.status, w.Code)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("want authentication challenge")
+			}
+		})
+	}
+}
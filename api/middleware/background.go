@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/polldo/patweb/api/trace"
+	"github.com/polldo/patweb/api/web"
+	"github.com/sirupsen/logrus"
+)
+
+// Background makes b available to handlers through web.Go,
+// so that the goroutines they spawn are recovered and awaited on shutdown.
+func Background(b *web.Background) web.Middleware {
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			return handler(web.ContextWithBackground(ctx, b), w, r)
+		}
+		return h
+	}
+	return m
+}
+
+// BackgroundLogFields returns the fields identifying the request that spawned
+// a background goroutine, to be used with web.WithLogFields.
+func BackgroundLogFields(ctx context.Context) logrus.Fields {
+	fields := logrus.Fields{"req_id": ContextRequestID(ctx)}
+	if span := trace.SpanFromContext(ctx); span != nil {
+		fields["trace_id"] = span.TraceID()
+	}
+	return fields
+}
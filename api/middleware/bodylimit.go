@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/polldo/patweb/api/web"
+	"github.com/polldo/patweb/api/weberr"
+)
+
+// ErrBodyTooLarge is returned by the request body when it exceeds the limit
+// set by BodyLimit. Check it with errors.Is.
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// bodyLimitKeyCtx is the private type used to store the body limit in the context.
+type bodyLimitKeyCtx int
+
+// bodyLimitKey is the context key used to store the body limit state.
+const bodyLimitKey bodyLimitKeyCtx = 1
+
+// bodyLimit is the state of the limit applied to a request body.
+// The body may still be read by a handler abandoned by Timeout,
+// so exceeded is accessed atomically.
+type bodyLimit struct {
+	orig     io.ReadCloser
+	limit    int64
+	exceeded int32
+}
+
+// BodyLimit limits the size of request bodies to n bytes, using http.MaxBytesReader.
+// Reading past the limit fails with an error matching ErrBodyTooLarge, that
+// responds with a quiet 413 error. The same error is returned by the middleware
+// if the handler fails after the limit has been exceeded, whatever error it
+// returns, so that decode errors are not mistaken for server errors.
+//
+// The last BodyLimit of a chain overrides the previous ones, so that a route
+// specific limit passed to api.Handle can either lower or raise the global one.
+func BodyLimit(n int64) web.Middleware {
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			if r.Body == nil || r.Body == http.NoBody {
+				return handler(ctx, w, r)
+			}
+
+			// Always limit the original body, so that limits override each other.
+			orig := r.Body
+			if bl, ok := ctx.Value(bodyLimitKey).(*bodyLimit); ok {
+				orig = bl.orig
+			}
+			bl := &bodyLimit{orig: orig, limit: n}
+			ctx = context.WithValue(ctx, bodyLimitKey, bl)
+
+			route, _ := routeTemplate(r)
+			r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, orig, n), bl: bl, route: route}
+
+			err := handler(ctx, w, r)
+			if err != nil && atomic.LoadInt32(&bl.exceeded) == 1 && errorStatus(err) != http.StatusRequestEntityTooLarge {
+				return bodyTooLarge(err, n, route)
+			}
+			return err
+		}
+		return h
+	}
+	return m
+}
+
+// limitedBody translates the error of http.MaxBytesReader into ErrBodyTooLarge.
+type limitedBody struct {
+	io.ReadCloser
+	bl    *bodyLimit
+	route string
+	read  int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+
+	// http.MaxBytesReader fails only after returning exactly 'limit' bytes.
+	if err != nil && err != io.EOF && b.read >= b.bl.limit {
+		atomic.StoreInt32(&b.bl.exceeded, 1)
+		return n, bodyTooLarge(err, b.bl.limit, b.route)
+	}
+	return n, err
+}
+
+// bodyTooLarge builds the quiet 413 error of a request exceeding the body limit.
+func bodyTooLarge(err error, limit int64, route string) error {
+	err = fmt.Errorf("%w: %v", ErrBodyTooLarge, err)
+	return newRequestError(err, http.StatusRequestEntityTooLarge,
+		fmt.Sprintf("request body exceeds the limit of %d bytes", limit),
+		weberr.WithFields(map[string]interface{}{"body_limit": limit, "route": route}),
+		weberr.WithQuiet(true),
+	)
+}
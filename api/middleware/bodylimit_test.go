@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/polldo/patweb/api/web"
+)
+
+func TestBodyLimitAbandoned(t *testing.T) {
+	read := make(chan struct{})
+	h := web.WrapMiddleware([]web.Middleware{BodyLimit(4), Timeout(10 * time.Millisecond)},
+		func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			<-ctx.Done()
+
+			// The abandoned handler keeps reading while BodyLimit returns.
+			defer close(read)
+			_, err := io.ReadAll(r.Body)
+			return err
+		})
+
+	// Depending on the timing, the limit is exceeded before or after
+	// BodyLimit checks it: the race detector must not complain anyway.
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("too large"))
+	err := h(r.Context(), httptest.NewRecorder(), r)
+	if s := errorStatus(err); s != http.StatusServiceUnavailable && s != http.StatusRequestEntityTooLarge {
+		t.Errorf("want 503 or 413, got %v", err)
+	}
+	<-read
+}
@@ -0,0 +1,403 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/polldo/patweb/api/cache"
+	"github.com/polldo/patweb/api/web"
+)
+
+// CacheStatusHeader reports whether a response was served from the cache.
+const CacheStatusHeader = "X-Cache"
+
+// cacheableStatus lists the status codes of the responses that can be cached.
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+// cacheConfig contains the settings of a Cache middleware.
+type cacheConfig struct {
+	query     []string
+	headers   []string
+	principal bool
+	ttl       time.Duration
+	now       func() time.Time
+	onErr     func(error)
+}
+
+// CacheOpt defines the type for Cache options.
+type CacheOpt func(*cacheConfig)
+
+// WithCacheKeyQuery returns an option that restricts the query parameters
+// of the cache key to the given ones. Other parameters are ignored,
+// so they must not change the response. By default all of them are used.
+func WithCacheKeyQuery(params ...string) CacheOpt {
+	return func(c *cacheConfig) {
+		c.query = append(c.query, params...)
+	}
+}
+
+// WithCacheKeyHeaders returns an option that adds the given request headers
+// to the cache key. It must list the headers the response varies on, since
+// responses varying on other headers are not cached.
+func WithCacheKeyHeaders(names ...string) CacheOpt {
+	return func(c *cacheConfig) {
+		for _, n := range names {
+			c.headers = append(c.headers, http.CanonicalHeaderKey(n))
+		}
+	}
+}
+
+// WithCacheKeyPrincipal returns an option that adds the authenticated subject
+// to the cache key, so that each client has its own entries. It also allows
+// caching the responses marked as private and the responses to requests
+// with credentials.
+func WithCacheKeyPrincipal() CacheOpt {
+	return func(c *cacheConfig) {
+		c.principal = true
+	}
+}
+
+// WithCacheTTL returns an option that sets for how long the responses without
+// a max-age directive are cached. By default they are not cached.
+func WithCacheTTL(ttl time.Duration) CacheOpt {
+	return func(c *cacheConfig) {
+		c.ttl = ttl
+	}
+}
+
+// WithCacheErrorHandler returns an option that sets the function called
+// when the store fails. Requests are served anyway, and errors are ignored
+// by default.
+func WithCacheErrorHandler(fn func(error)) CacheOpt {
+	return func(c *cacheConfig) {
+		c.onErr = fn
+	}
+}
+
+// Cache serves GET requests from store, keyed by method, path and query
+// plus the headers and principal selected by the options.
+//
+// Responses are cached according to the Cache-Control header set by handlers:
+// s-maxage or max-age set their lifetime, while no-store and no-cache prevent
+// caching, as does private unless entries are keyed by principal. Responses
+// to requests with an Authorization header are cached only if public or keyed
+// by principal, as are the ones to requests with cookies. Responses setting
+// cookies, and responses varying on headers not in the key, are never cached.
+// Handlers can tag their responses with AddCacheTags, and invalidate them
+// with the InvalidateTags method of the store. Hits matching the conditional
+// headers of the request are answered with 304 Not Modified.
+//
+// Concurrent misses of the same key are collapsed: a single request executes
+// the handler and the others wait for its response. Since responses are
+// buffered, it should not be used for streaming handlers.
+// Served responses carry the X-Cache header, set to HIT or MISS.
+func Cache(store cache.Store, opts ...CacheOpt) web.Middleware {
+	cfg := cacheConfig{now: time.Now, onErr: func(error) {}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	sort.Strings(cfg.query)
+	sort.Strings(cfg.headers)
+
+	var group flightGroup
+
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			if r.Method != http.MethodGet {
+				return handler(ctx, w, r)
+			}
+
+			key := cfg.key(ctx, r)
+			e, ok, err := store.Get(ctx, key)
+			if err != nil {
+				cfg.onErr(fmt.Errorf("cannot get cached response: %w", err))
+			}
+			if ok {
+				return writeCached(w, r, e.Status, e.Header, e.Body, "HIT", cfg.now().Sub(e.StoredAt))
+			}
+
+			f, leader := group.join(key)
+			if !leader {
+				select {
+				case <-f.done:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				if f.entry == nil {
+					// The response of the first request can't be shared.
+					return handler(ctx, w, r)
+				}
+				return writeCached(w, r, f.entry.Status, f.entry.Header, f.entry.Body, "HIT", cfg.now().Sub(f.entry.StoredAt))
+			}
+			defer group.leave(key, f)
+
+			tags := &cacheTags{}
+			ctx = context.WithValue(ctx, cacheTagsKey, tags)
+			rec := &cacheRecorder{header: http.Header{}}
+			if err := handler(ctx, rec, r); err != nil {
+				return err
+			}
+
+			if e, ok := cfg.entry(r, rec, tags.list()); ok {
+				if err := store.Set(web.Detach(ctx), key, e); err != nil {
+					cfg.onErr(fmt.Errorf("cannot store cached response: %w", err))
+				}
+				f.entry = e
+			}
+			if rec.status == 0 {
+				return nil
+			}
+			return writeCached(w, r, rec.status, rec.header, rec.body.Bytes(), "MISS", 0)
+		}
+		return h
+	}
+	return m
+}
+
+// key builds the cache key of a request.
+func (c *cacheConfig) key(ctx context.Context, r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteByte(' ')
+	b.WriteString(r.URL.Path)
+	q := r.URL.Query()
+	if len(c.query) > 0 {
+		for _, p := range c.query {
+			fmt.Fprintf(&b, "\x00q:%s=%q", p, q[p])
+		}
+	} else if len(q) > 0 {
+		// Encode sorts the parameters by name.
+		fmt.Fprintf(&b, "\x00q:%s", q.Encode())
+	}
+	for _, h := range c.headers {
+		fmt.Fprintf(&b, "\x00h:%s=%q", h, r.Header.Values(h))
+	}
+	if c.principal {
+		fmt.Fprintf(&b, "\x00p:%q", ContextSubject(ctx))
+	}
+	return b.String()
+}
+
+// entry builds the cache entry of a recorded response, if it can be cached.
+func (c *cacheConfig) entry(r *http.Request, rec *cacheRecorder, tags []string) (*cache.Entry, bool) {
+	if !cacheableStatus[rec.status] || rec.header.Get("Set-Cookie") != "" {
+		return nil, false
+	}
+	if !c.keyedBy(rec.header.Values("Vary")) {
+		return nil, false
+	}
+
+	cc := parseCacheControl(rec.header.Get("Cache-Control"))
+	if _, ok := cc["no-store"]; ok {
+		return nil, false
+	}
+	if _, ok := cc["no-cache"]; ok {
+		return nil, false
+	}
+	if _, ok := cc["private"]; ok && !c.principal {
+		return nil, false
+	}
+	if _, ok := cc["public"]; !ok && !c.principal && hasCredentials(r) {
+		return nil, false
+	}
+
+	ttl := c.ttl
+	if v, ok := cc["s-maxage"]; ok {
+		ttl = parseSeconds(v)
+	} else if v, ok := cc["max-age"]; ok {
+		ttl = parseSeconds(v)
+	}
+	if ttl <= 0 {
+		return nil, false
+	}
+
+	now := c.now()
+	return &cache.Entry{
+		Status:    rec.status,
+		Header:    rec.header.Clone(),
+		Body:      append([]byte(nil), rec.body.Bytes()...),
+		Tags:      tags,
+		StoredAt:  now,
+		ExpiresAt: now.Add(ttl),
+	}, true
+}
+
+// hasCredentials reports whether r carries credentials, in the Authorization
+// header or in cookies like the session one, so its response may be personal.
+func hasCredentials(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != ""
+}
+
+// keyedBy reports whether the headers listed by Vary values are in the key.
+func (c *cacheConfig) keyedBy(vary []string) bool {
+	for _, v := range vary {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if name == "*" {
+				return false
+			}
+			i := sort.SearchStrings(c.headers, http.CanonicalHeaderKey(name))
+			if i == len(c.headers) || c.headers[i] != http.CanonicalHeaderKey(name) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// parseCacheControl parses the directives of a Cache-Control header.
+func parseCacheControl(s string) map[string]string {
+	cc := map[string]string{}
+	for _, d := range strings.Split(s, ",") {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		name, value := d, ""
+		if i := strings.IndexByte(d, '='); i >= 0 {
+			name, value = d[:i], strings.Trim(d[i+1:], `"`)
+		}
+		cc[strings.ToLower(name)] = value
+	}
+	return cc
+}
+
+func parseSeconds(s string) time.Duration {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
+
+// writeCached writes a response. Headers already set by previous middlewares,
+// like the request ID, are kept. Hits already cached by the client, as told
+// by its conditional headers, are answered with 304 Not Modified.
+func writeCached(w http.ResponseWriter, r *http.Request, status int, header http.Header, body []byte, cacheStatus string, age time.Duration) error {
+	h := w.Header()
+	for k, v := range header {
+		if _, ok := h[k]; !ok {
+			h[k] = v
+		}
+	}
+	h.Set(CacheStatusHeader, cacheStatus)
+	if cacheStatus == "HIT" {
+		h.Set("Age", strconv.Itoa(int(age.Seconds())))
+		lastModified, _ := http.ParseTime(header.Get("Last-Modified"))
+		if status == http.StatusOK && web.NotModified(r, header.Get("ETag"), lastModified) {
+			h.Del("Content-Length")
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+	}
+	w.WriteHeader(status)
+	if _, err := w.Write(body); err != nil {
+		return fmt.Errorf("cannot write cached response: %w", err)
+	}
+	return nil
+}
+
+// cacheTagsKeyCtx is the private type used to store the cache tags in the context.
+type cacheTagsKeyCtx int
+
+// cacheTagsKey is the context key used to store the tags of a cached response.
+const cacheTagsKey cacheTagsKeyCtx = 1
+
+// cacheTags collects the tags of a response.
+type cacheTags struct {
+	mu   sync.Mutex
+	tags []string
+}
+
+func (t *cacheTags) list() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tags
+}
+
+// AddCacheTags attaches tags to the response being cached, so that it can be
+// invalidated later on. It does nothing outside of the Cache middleware.
+func AddCacheTags(ctx context.Context, tags ...string) {
+	t, ok := ctx.Value(cacheTagsKey).(*cacheTags)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tags = append(t.tags, tags...)
+}
+
+// cacheRecorder buffers the response of a handler.
+type cacheRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *cacheRecorder) Header() http.Header { return rec.header }
+
+func (rec *cacheRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+}
+
+func (rec *cacheRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(b)
+}
+
+// flightGroup collapses the concurrent executions of a handler for a key.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// flight is an execution in progress. entry is set, before done is closed,
+// if the response can be shared.
+type flight struct {
+	done  chan struct{}
+	entry *cache.Entry
+}
+
+// join returns the flight of key, and whether the caller leads it.
+func (g *flightGroup) join(key string) (*flight, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f, ok := g.flights[key]; ok {
+		return f, false
+	}
+	if g.flights == nil {
+		g.flights = map[string]*flight{}
+	}
+	f := &flight{done: make(chan struct{})}
+	g.flights[key] = f
+	return f, true
+}
+
+// leave completes the flight of key, waking up the waiting requests.
+func (g *flightGroup) leave(key string, f *flight) {
+	g.mu.Lock()
+	delete(g.flights, key)
+	g.mu.Unlock()
+	close(f.done)
+}
@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/polldo/patweb/api/cache"
+)
+
+func TestCache(t *testing.T) {
+	store := cache.NewLRU(100, 0)
+	var calls int32
+	h := Cache(store, WithCacheKeyQuery("page"))(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		n := atomic.AddInt32(&calls, 1)
+		AddCacheTags(ctx, "orders")
+		w.Header().Set("Cache-Control", r.URL.Query().Get("cc"))
+		_, err := fmt.Fprintf(w, "response %d", n)
+		return err
+	})
+
+	get := func(target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		w := httptest.NewRecorder()
+		if err := h(r.Context(), w, r); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		status string
+	}{
+		{"miss", "/orders?page=1&cc=max-age%3D60", "response 1", "MISS"},
+		{"hit", "/orders?page=1&cc=max-age%3D60", "response 1", "HIT"},
+		{"ignored query param", "/orders?page=1&cc=max-age%3D60&x=1", "response 1", "HIT"},
+		{"other key", "/orders?page=2&cc=max-age%3D60", "response 2", "MISS"},
+		{"no-store", "/users?cc=no-store", "response 3", "MISS"},
+		{"not stored", "/users?cc=no-store", "response 4", "MISS"},
+		{"private", "/private?cc=private,max-age%3D60", "response 5", "MISS"},
+		{"private not stored", "/private?cc=private,max-age%3D60", "response 6", "MISS"},
+	}
+	for _, tt := range tests {
+		w := get(tt.target)
+		if w.Body.String() != tt.body || w.Header().Get(CacheStatusHeader) != tt.status {
+			t.Errorf("%s: want %q %s, got %q %s", tt.name, tt.body, tt.status, w.Body.String(), w.Header().Get(CacheStatusHeader))
+		}
+	}
+
+	_ = store.InvalidateTags(context.Background(), "orders")
+	if w := get("/orders?page=1&cc=max-age%3D60"); w.Header().Get(CacheStatusHeader) != "MISS" {
+		t.Errorf("want invalidated entry missing")
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	store := cache.NewLRU(100, 0)
+	var calls int32
+	h := Cache(store, WithCacheKeyHeaders("Accept-Language"))(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		n := atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", r.URL.Query().Get("cc"))
+		w.Header().Set("Vary", r.URL.Query().Get("vary"))
+		_, err := fmt.Fprintf(w, "response %d", n)
+		return err
+	})
+
+	get := func(target, auth string) string {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		switch auth {
+		case "":
+		case "cookie":
+			r.AddCookie(&http.Cookie{Name: "session", Value: "alice"})
+		default:
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		if err := h(r.Context(), w, r); err != nil {
+			t.Fatal(err)
+		}
+		return w.Header().Get(CacheStatusHeader)
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		auth   string
+		status string
+	}{
+		{"miss", "/a?cc=max-age%3D60&x=1", "", "MISS"},
+		{"sorted query", "/a?x=1&cc=max-age%3D60", "", "HIT"},
+		{"other query", "/a?cc=max-age%3D60&x=2", "", "MISS"},
+		{"vary in key", "/b?cc=max-age%3D60&vary=accept-language", "", "MISS"},
+		{"vary in key stored", "/b?cc=max-age%3D60&vary=accept-language", "", "HIT"},
+		{"vary not in key", "/c?cc=max-age%3D60&vary=Cookie", "", "MISS"},
+		{"vary not in key not stored", "/c?cc=max-age%3D60&vary=Cookie", "", "MISS"},
+		{"authorization", "/d?cc=max-age%3D60", "Bearer a", "MISS"},
+		{"authorization not stored", "/d?cc=max-age%3D60", "Bearer b", "MISS"},
+		{"authorization public", "/e?cc=public,max-age%3D60", "Bearer a", "MISS"},
+		{"authorization public stored", "/e?cc=public,max-age%3D60", "Bearer b", "HIT"},
+		{"cookie", "/f?cc=max-age%3D60", "cookie", "MISS"},
+		{"cookie not stored", "/f?cc=max-age%3D60", "cookie", "MISS"},
+	}
+	for _, tt := range tests {
+		if got := get(tt.target, tt.auth); got != tt.status {
+			t.Errorf("%s: want %s, got %s", tt.name, tt.status, got)
+		}
+	}
+}
+
+func TestCacheCollapse(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	h := Cache(cache.NewLRU(100, 0), WithCacheTTL(time.Minute))(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		_, err := w.Write([]byte("slow"))
+		return err
+	})
+
+	const n = 10
+	var wg sync.WaitGroup
+	bodies := make([]string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodGet, "/slow", nil)
+			w := httptest.NewRecorder()
+			if err := h(r.Context(), w, r); err != nil {
+				t.Error(err)
+			}
+			bodies[i] = w.Body.String()
+		}(i)
+	}
+
+	// Let the requests join the flight before releasing the handler.
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("want a single handler execution, got %d", calls)
+	}
+	for _, b := range bodies {
+		if b != "slow" {
+			t.Errorf("want shared response, got %q", b)
+		}
+	}
+}
+
+func TestCacheNotModified(t *testing.T) {
+	h := Cache(cache.NewLRU(100, 0))(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"v1"`)
+		_, err := fmt.Fprint(w, "response")
+		return err
+	})
+
+	get := func(etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		if err := h(r.Context(), w, r); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	get("")
+	if w := get(`"v1"`); w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != `"v1"` {
+		t.Errorf("want 304 for a hit cached by the client, got %d %q", w.Code, w.Body.String())
+	}
+	if w := get(`"v0"`); w.Code != http.StatusOK || w.Body.String() != "response" {
+		t.Errorf("want stored response for a stale tag, got %d %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,295 @@
+package middleware
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/polldo/patweb/api/web"
+)
+
+// DefaultCompressMinSize is the default minimum size of the bodies to compress.
+const DefaultCompressMinSize = 1024
+
+// defaultIncompressible lists the prefixes of the content types that are
+// already compressed, and would not benefit from another compression.
+var defaultIncompressible = []string{
+	"image/", "video/", "audio/",
+	"application/zip", "application/gzip", "application/x-gzip",
+	"application/x-7z-compressed", "application/x-rar-compressed",
+	"font/woff", "font/woff2",
+}
+
+// compressConfig contains the settings of a Compress middleware.
+type compressConfig struct {
+	level          int
+	minSize        int
+	incompressible []string
+}
+
+// CompressOpt defines the type for Compress options.
+type CompressOpt func(*compressConfig)
+
+// WithCompressLevel returns an option that sets the compression level,
+// between flate.BestSpeed and flate.BestCompression.
+func WithCompressLevel(level int) CompressOpt {
+	return func(c *compressConfig) {
+		c.level = level
+	}
+}
+
+// WithCompressMinSize returns an option that sets the minimum size
+// of the bodies to compress.
+func WithCompressMinSize(size int) CompressOpt {
+	return func(c *compressConfig) {
+		c.minSize = size
+	}
+}
+
+// WithIncompressibleTypes returns an option that sets the prefixes of
+// the content types that are never compressed.
+func WithIncompressibleTypes(prefixes ...string) CompressOpt {
+	return func(c *compressConfig) {
+		c.incompressible = prefixes
+	}
+}
+
+// Compress compresses response bodies with gzip or deflate, according to
+// the Accept-Encoding header of the request.
+// Bodies smaller than the minimum size, responses already encoded and
+// responses with an incompressible content type are sent as they are.
+//
+// Bodies are buffered until the minimum size is reached, or the handler
+// flushes the response, then they are streamed through the compressor.
+// It should be placed after the Logger middleware, so that the logged bytes
+// are the ones actually sent, and before the Errors middleware, so that error
+// responses are compressed as well.
+func Compress(opts ...CompressOpt) web.Middleware {
+	cfg := compressConfig{
+		level:          flate.DefaultCompression,
+		minSize:        DefaultCompressMinSize,
+		incompressible: defaultIncompressible,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.level < flate.HuffmanOnly || cfg.level > flate.BestCompression {
+		cfg.level = flate.DefaultCompression
+	}
+
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			enc := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if enc == "" || r.Method == http.MethodHead {
+				return handler(ctx, w, r)
+			}
+
+			cw := newCompressWriter(w, enc, &cfg)
+			err := handler(ctx, cw, r)
+			if cerr := cw.close(); cerr != nil && err == nil {
+				err = cerr
+			}
+			return err
+		}
+		return h
+	}
+	return m
+}
+
+// negotiateEncoding returns the supported encoding preferred by the client,
+// or an empty string if the response must not be compressed.
+// On ties gzip wins, as it's the most widely supported.
+func negotiateEncoding(accept string) string {
+	q := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		name, v := parseQuality(part)
+		if name == "x-gzip" {
+			name = "gzip"
+		}
+		q[name] = v
+	}
+	quality := func(enc string) float64 {
+		if v, ok := q[enc]; ok {
+			return v
+		}
+		return q["*"]
+	}
+
+	gz, df := quality("gzip"), quality("deflate")
+	switch {
+	case gz > 0 && gz >= df:
+		return "gzip"
+	case df > 0:
+		return "deflate"
+	}
+	return ""
+}
+
+// parseQuality parses an element of a header like Accept-Encoding: 'gzip;q=0.8'.
+func parseQuality(s string) (name string, q float64) {
+	params := strings.Split(s, ";")
+	name = strings.ToLower(strings.TrimSpace(params[0]))
+	q = 1
+	for _, p := range params[1:] {
+		p = strings.TrimSpace(p)
+		if strings.HasPrefix(p, "q=") {
+			v, err := strconv.ParseFloat(p[2:], 64)
+			if err != nil {
+				return name, 0
+			}
+			q = v
+		}
+	}
+	return name, q
+}
+
+// compressWriter compresses the response once the decision to compress is taken.
+type compressWriter struct {
+	http.ResponseWriter
+	enc string
+	cfg *compressConfig
+
+	code        int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	comp        io.WriteCloser
+	flusher     interface{ Flush() error }
+}
+
+func newCompressWriter(w http.ResponseWriter, enc string, cfg *compressConfig) *compressWriter {
+	return &compressWriter{ResponseWriter: w, enc: enc, cfg: cfg}
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.code = code
+
+	// Responses without body are sent right away. Not modified responses
+	// carry the tag of the representation the client would have received.
+	if code == http.StatusNotModified {
+		if etag := cw.Header().Get("ETag"); etag != "" {
+			cw.Header().Set("ETag", web.ETagWithCoding(etag, cw.enc))
+		}
+	}
+	if code < http.StatusOK || code == http.StatusNoContent || code == http.StatusNotModified {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < cw.cfg.minSize {
+			return len(b), nil
+		}
+		if err := cw.start(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if cw.comp != nil {
+		return cw.comp.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush sends the buffered data to the client. Since the size of a flushed
+// response is unknown, it is compressed if the content type allows it.
+func (cw *compressWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		_ = cw.start(true)
+	}
+	if cw.flusher != nil {
+		_ = cw.flusher.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// start takes the decision and writes the buffered data.
+func (cw *compressWriter) start(compress bool) error {
+	cw.decide(compress)
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.comp != nil {
+		_, err = cw.comp.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// decide sets the headers according to whether the body is compressed
+// and writes the status code.
+func (cw *compressWriter) decide(compress bool) {
+	cw.decided = true
+	h := cw.Header()
+	if compress && h.Get("Content-Encoding") == "" && cw.compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", cw.enc)
+		h.Del("Content-Length")
+
+		// The compressed body is a different representation,
+		// so it needs its own strong tag.
+		if etag := h.Get("ETag"); etag != "" {
+			h.Set("ETag", web.ETagWithCoding(etag, cw.enc))
+		}
+		switch cw.enc {
+		case "gzip":
+			gw, _ := gzip.NewWriterLevel(cw.ResponseWriter, cw.cfg.level)
+			cw.comp, cw.flusher = gw, gw
+		case "deflate":
+			// The deflate coding of HTTP is the zlib format, not raw deflate.
+			zw, _ := zlib.NewWriterLevel(cw.ResponseWriter, cw.cfg.level)
+			cw.comp, cw.flusher = zw, zw
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.code)
+}
+
+func (cw *compressWriter) compressible(contentType string) bool {
+	ct := strings.ToLower(contentType)
+	for _, p := range cw.cfg.incompressible {
+		if strings.HasPrefix(ct, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// close sends what is still buffered, uncompressed since it's smaller
+// than the minimum size, and terminates the compressed stream.
+func (cw *compressWriter) close() error {
+	if !cw.wroteHeader {
+		// Nothing has been written, let the next writers decide.
+		return nil
+	}
+	if !cw.decided {
+		return cw.start(false)
+	}
+	if cw.comp != nil {
+		return cw.comp.Close()
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/polldo/patweb/api/web"
+	"github.com/sirupsen/logrus"
+	"github.com/zenazn/goji/web/mutil"
+)
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat("a", 2*DefaultCompressMinSize)
+
+	tests := []struct {
+		name        string
+		accept      string
+		contentType string
+		body        string
+		encoding    string
+	}{
+		{"gzip", "gzip, deflate", "application/json", large, "gzip"},
+		{"deflate preferred", "gzip;q=0.5, deflate", "application/json", large, "deflate"},
+		{"not accepted", "br", "application/json", large, ""},
+		{"small body", "gzip", "application/json", "small", ""},
+		{"already compressed", "gzip", "image/png", large, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Compress()(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(http.StatusCreated)
+				_, err := io.WriteString(w, tt.body)
+				return err
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", tt.accept)
+			rec := httptest.NewRecorder()
+
+			// Wrap the writer as the Logger middleware does.
+			lw := mutil.WrapWriter(rec)
+			if err := h(r.Context(), lw, r); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := rec.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Fatalf("want encoding %q, got %q", tt.encoding, got)
+			}
+			if rec.Header().Get("Vary") != "Accept-Encoding" {
+				t.Errorf("want Vary header, got %q", rec.Header().Get("Vary"))
+			}
+			if lw.Status() != http.StatusCreated || lw.BytesWritten() != rec.Body.Len() {
+				t.Errorf("want logged status %d and %d bytes, got %d and %d",
+					http.StatusCreated, rec.Body.Len(), lw.Status(), lw.BytesWritten())
+			}
+
+			var zr io.Reader = rec.Body
+			var err error
+			switch tt.encoding {
+			case "gzip":
+				zr, err = gzip.NewReader(rec.Body)
+			case "deflate":
+				zr, err = zlib.NewReader(rec.Body)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body := string(b); body != tt.body {
+				t.Errorf("unexpected body of %d bytes", len(body))
+			}
+		})
+	}
+}
+
+func TestCompressPreconditions(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	large := strings.Repeat("a", 2*DefaultCompressMinSize)
+	h := web.WrapMiddleware([]web.Middleware{Compress(), Errors(log)}, func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		if r.Method == http.MethodPut {
+			if err := web.CheckPreconditions(ctx, `"v1"`, time.Time{}); err != nil {
+				return err
+			}
+		}
+		return web.Respond(ctx, w, large, http.StatusOK, web.WithETag("v1"))
+	})
+
+	do := func(method, header, etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		if header != "" {
+			r.Header.Set(header, etag)
+		}
+		w := httptest.NewRecorder()
+		if err := h(web.ContextWithRequest(r.Context(), r), w, r); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	w := do(http.MethodGet, "", "")
+	etag := w.Header().Get("ETag")
+	if w.Header().Get("Content-Encoding") != "gzip" || etag != `"v1-gzip"` {
+		t.Fatalf("want strong tag of the gzip representation, got %q", etag)
+	}
+	if w := do(http.MethodGet, "If-None-Match", etag); w.Code != http.StatusNotModified || w.Header().Get("ETag") != etag {
+		t.Errorf("want 304 with tag %s, got %d %q", etag, w.Code, w.Header().Get("ETag"))
+	}
+	if w := do(http.MethodPut, "If-Match", etag); w.Code != http.StatusOK {
+		t.Errorf("want If-Match with the tag of the gzip representation to succeed, got %d", w.Code)
+	}
+	if w := do(http.MethodPut, "If-Match", `"v0-gzip"`); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("want 412 for a stale tag, got %d", w.Code)
+	}
+}
+
+func TestCompressFlush(t *testing.T) {
+	h := web.WrapMiddleware([]web.Middleware{Compress()}, func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		w.(http.Flusher).Flush()
+		return nil
+	})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	if err := h(r.Context(), rec, r); err != nil {
+		t.Fatal(err)
+	}
+	if !rec.Flushed || rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("flushed responses should be streamed compressed")
+	}
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/polldo/patweb/api/concurrency"
+	"github.com/polldo/patweb/api/web"
+	"github.com/polldo/patweb/api/weberr"
+)
+
+// ConcurrencyPriority returns the priority of a request in the queue of a
+// concurrency limiter.
+type ConcurrencyPriority func(ctx context.Context, r *http.Request) concurrency.Priority
+
+// PriorityByRoute prioritizes requests by their route template,
+// like '/orders/{id}'. Other routes have priority def.
+func PriorityByRoute(routes map[string]concurrency.Priority, def concurrency.Priority) ConcurrencyPriority {
+	return func(ctx context.Context, r *http.Request) concurrency.Priority {
+		route, _ := routeTemplate(r)
+		if p, ok := routes[route]; ok {
+			return p
+		}
+		return def
+	}
+}
+
+// PriorityByHeader prioritizes requests by the value of a header.
+// Requests with other values have priority def.
+func PriorityByHeader(name string, values map[string]concurrency.Priority, def concurrency.Priority) ConcurrencyPriority {
+	return func(ctx context.Context, r *http.Request) concurrency.Priority {
+		if p, ok := values[r.Header.Get(name)]; ok {
+			return p
+		}
+		return def
+	}
+}
+
+// ConcurrencyLimit bounds the requests processed at the same time with limiter.
+// Requests over the limit wait in its queue, ordered by prio, or normal
+// priority if nil. Shed requests fail with a quiet 503 error carrying the
+// Retry-After header, and the state of the limiter in the logged fields.
+//
+// Requests failing with a timeout or a 503 error signal overload to the
+// algorithm of the limiter. It should be placed after the Errors middleware.
+func ConcurrencyLimit(limiter *concurrency.Limiter, prio ConcurrencyPriority) web.Middleware {
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			p := concurrency.PriorityNormal
+			if prio != nil {
+				p = prio(ctx, r)
+			}
+
+			token, err := limiter.Acquire(ctx, p)
+			if errors.Is(err, concurrency.ErrShed) {
+				s := limiter.Stats()
+				return newRequestError(err, http.StatusServiceUnavailable, "server overloaded, retry later",
+					weberr.WithHeaders(http.Header{"Retry-After": {ceilSeconds(limiter.RetryAfter())}}),
+					weberr.WithFields(map[string]interface{}{
+						"concurrency_limit":     s.Limit,
+						"concurrency_in_flight": s.InFlight,
+						"concurrency_queued":    s.Queued,
+						"priority":              int(p),
+					}),
+					weberr.WithQuiet(true),
+				)
+			}
+			if err != nil {
+				return err
+			}
+
+			// Release even on panics, as an overload signal.
+			dropped := true
+			defer func() { token.Release(dropped) }()
+
+			err = handler(ctx, w, r)
+			status := errorStatus(err)
+			dropped = err != nil && (errors.Is(err, context.DeadlineExceeded) ||
+				status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout)
+			return err
+		}
+		return h
+	}
+	return m
+}
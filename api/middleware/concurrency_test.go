@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/polldo/patweb/api/concurrency"
+	"github.com/polldo/patweb/api/weberr"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	limiter := concurrency.NewLimiter(concurrency.Static(1))
+	inside := make(chan struct{})
+	release := make(chan struct{})
+	h := ConcurrencyLimit(limiter, nil)(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		close(inside)
+		<-release
+		return nil
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	done := make(chan error)
+	go func() { done <- h(r.Context(), httptest.NewRecorder(), r) }()
+	<-inside
+
+	err := h(r.Context(), httptest.NewRecorder(), r)
+	if !errors.Is(err, concurrency.ErrShed) || errorStatus(err) != http.StatusServiceUnavailable || !weberr.IsQuiet(err) {
+		t.Fatalf("want quiet 503 error, got %v", err)
+	}
+	if hdr, _ := weberr.Headers(err); hdr.Get("Retry-After") != "1" {
+		t.Errorf("want Retry-After header, got %v", hdr)
+	}
+	if fields, _ := weberr.Fields(err); fields["concurrency_in_flight"] != 1 {
+		t.Errorf("want limiter state in fields, got %v", fields)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if s := limiter.Stats(); s.InFlight != 0 {
+		t.Errorf("want slot released, got %+v", s)
+	}
+}
@@ -0,0 +1,216 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/polldo/patweb/api/web"
+	"github.com/polldo/patweb/api/weberr"
+)
+
+// CORSConfig contains the Cross-Origin Resource Sharing policy.
+type CORSConfig struct {
+	// AllowedOrigins lists the origins allowed to make cross-origin requests.
+	// An origin can be exact, like 'https://example.com', or contain a single
+	// wildcard, like 'https://*.example.com'. The '*' origin allows any origin.
+	AllowedOrigins []string
+
+	// AllowedOriginPatterns lists regular expressions matching allowed origins.
+	// They are anchored, so they must match the whole origin.
+	AllowedOriginPatterns []*regexp.Regexp
+
+	// AllowedHeaders lists the request headers allowed in cross-origin requests.
+	// If empty, the headers requested by preflight requests are allowed.
+	AllowedHeaders []string
+
+	// ExposedHeaders lists the response headers that browsers expose to clients.
+	ExposedHeaders []string
+
+	// AllowCredentials allows requests with cookies and authorization headers.
+	// It can't be used with the '*' origin, which would let any site make
+	// credentialed requests.
+	AllowCredentials bool
+
+	// MaxAge is how long preflight responses can be cached. Zero omits the header.
+	MaxAge time.Duration
+}
+
+// corsPolicy is the compiled form of a CORSConfig.
+type corsPolicy struct {
+	CORSConfig
+	any       bool
+	exact     map[string]bool
+	wildcards [][2]string
+	patterns  []*regexp.Regexp
+}
+
+// newCORSPolicy panics if the configuration is not safe, since it's a programming error.
+func newCORSPolicy(cfg CORSConfig) *corsPolicy {
+	p := &corsPolicy{CORSConfig: cfg, exact: map[string]bool{}}
+	for _, o := range cfg.AllowedOrigins {
+		o = strings.ToLower(o)
+		switch i := strings.IndexByte(o, '*'); {
+		case o == "*":
+			p.any = true
+		case i >= 0:
+			p.wildcards = append(p.wildcards, [2]string{o[:i], o[i+1:]})
+		default:
+			p.exact[o] = true
+		}
+	}
+	for _, re := range cfg.AllowedOriginPatterns {
+		p.patterns = append(p.patterns, regexp.MustCompile(`^(?:`+re.String()+`)$`))
+	}
+	if p.any && p.AllowCredentials {
+		panic("cors: the '*' origin cannot be used with AllowCredentials")
+	}
+	return p
+}
+
+func (p *corsPolicy) allowed(origin string) bool {
+	if p.any {
+		return true
+	}
+	o := strings.ToLower(origin)
+	if p.exact[o] {
+		return true
+	}
+	if !validOrigin(origin) {
+		return false
+	}
+	for _, w := range p.wildcards {
+		if len(o) <= len(w[0])+len(w[1]) || !strings.HasPrefix(o, w[0]) || !strings.HasSuffix(o, w[1]) {
+			continue
+		}
+		// The wildcard matches subdomains, not ports.
+		if !strings.Contains(o[len(w[0]):len(o)-len(w[1])], ":") {
+			return true
+		}
+	}
+	for _, re := range p.patterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// validOrigin reports whether origin is a serialized origin, like
+// 'https://example.com:8443', without path, query or credentials,
+// so that patterns can't be matched by the other parts of a URL.
+func validOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Scheme != "" && u.Host != "" && u.Opaque == "" && u.User == nil &&
+		u.Path == "" && u.RawQuery == "" && u.Fragment == "" && !strings.HasSuffix(origin, "?")
+}
+
+// vary marks the response as depending on the origin, unless any origin
+// receives '*', so that shared caches don't serve the response of an origin
+// to another one, or to same-origin requests.
+func (p *corsPolicy) vary(h http.Header) {
+	if p.any {
+		return
+	}
+	for _, v := range h.Values("Vary") {
+		if strings.EqualFold(v, "Origin") {
+			return
+		}
+	}
+	h.Add("Vary", "Origin")
+}
+
+// setOrigin sets the headers common to preflight and actual requests.
+// It reports whether the origin is allowed.
+func (p *corsPolicy) setOrigin(h http.Header, origin string) bool {
+	p.vary(h)
+	if !p.allowed(origin) {
+		return false
+	}
+	if p.any {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	return true
+}
+
+// CORS adds the CORS headers to the responses of cross-origin requests
+// coming from allowed origins. Preflight requests are answered by Preflight,
+// which api.Handle registers automatically for each path.
+// It panics if cfg allows credentials from any origin.
+func CORS(cfg CORSConfig) web.Middleware {
+	p := newCORSPolicy(cfg)
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			p.vary(w.Header())
+			origin := r.Header.Get("Origin")
+			if origin == "" || isPreflight(r) {
+				return handler(ctx, w, r)
+			}
+
+			if p.setOrigin(w.Header(), origin) && len(p.ExposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+			}
+			return handler(ctx, w, r)
+		}
+		return h
+	}
+	return m
+}
+
+// Preflight returns a handler answering the OPTIONS requests of a path.
+// The methods are the ones registered for the path.
+// Preflight requests from origins not allowed fail with a quiet 403 error,
+// while plain OPTIONS requests are answered with the Allow header.
+func Preflight(cfg CORSConfig, methods []string) web.Handler {
+	p := newCORSPolicy(cfg)
+	allow := strings.Join(append(append([]string(nil), methods...), http.MethodOptions), ", ")
+	h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("Allow", allow)
+
+		if !isPreflight(r) {
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+
+		origin := r.Header.Get("Origin")
+		if !p.setOrigin(w.Header(), origin) {
+			err := errors.New("cors origin not allowed")
+			return newRequestError(err, http.StatusForbidden, "origin not allowed",
+				weberr.WithFields(map[string]interface{}{"origin": origin}),
+				weberr.WithQuiet(true),
+			)
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", allow)
+		if len(p.AllowedHeaders) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(p.AllowedHeaders, ", "))
+		} else if rh := r.Header.Get("Access-Control-Request-Headers"); rh != "" {
+			w.Header().Set("Access-Control-Allow-Headers", rh)
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+		}
+		if p.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge.Seconds())))
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return h
+}
+
+// isPreflight reports whether the request is a CORS preflight request.
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions &&
+		r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestCORSOrigins(t *testing.T) {
+	h := CORS(CORSConfig{
+		AllowedOrigins:        []string{"https://*.example.com"},
+		AllowedOriginPatterns: []*regexp.Regexp{regexp.MustCompile(`https://.*\.example\.org`)},
+	})(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"", false},
+		{"https://app.example.com", true},
+		{"https://evil.com/?.example.com", false},
+		{"https://a.example.com.evil.io", false},
+		{"https://app.example.org", true},
+		{"https://evil.com/?.example.org", false},
+		{"https://a.example.org.evil.io", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		w := httptest.NewRecorder()
+		if err := h(r.Context(), w, r); err != nil {
+			t.Fatal(err)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin") != ""; got != tt.allowed {
+			t.Errorf("origin %q: want allowed %v, got %v", tt.origin, tt.allowed, got)
+		}
+		if vary := w.Header().Values("Vary"); len(vary) != 1 || vary[0] != "Origin" {
+			t.Errorf("origin %q: want Vary Origin, got %q", tt.origin, vary)
+		}
+	}
+}
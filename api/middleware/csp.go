@@ -0,0 +1,115 @@
+package middleware
+
+import "strings"
+
+// Sources and keywords of Content-Security-Policy directives.
+const (
+	CSPSelf          = "'self'"
+	CSPNone          = "'none'"
+	CSPUnsafeInline  = "'unsafe-inline'"
+	CSPUnsafeEval    = "'unsafe-eval'"
+	CSPStrictDynamic = "'strict-dynamic'"
+	CSPReportSample  = "'report-sample'"
+	CSPSchemeHTTPS   = "https:"
+	CSPSchemeData    = "data:"
+	CSPSchemeBlob    = "blob:"
+
+	// CSPNonce is replaced, in each response, with the nonce of the request,
+	// that handlers can get with ContextCSPNonce.
+	CSPNonce = "'nonce'"
+)
+
+// CSP builds a Content-Security-Policy. Directives keep the order in which
+// they are first set, and setting a directive again replaces its sources.
+//
+//	csp := NewCSP().DefaultSrc(CSPNone).ScriptSrc(CSPSelf, CSPNonce).FrameAncestors(CSPNone)
+type CSP struct {
+	names   []string
+	sources map[string][]string
+}
+
+// NewCSP constructs an empty policy.
+func NewCSP() *CSP {
+	return &CSP{sources: map[string][]string{}}
+}
+
+// Directive sets a directive with the given sources.
+func (c *CSP) Directive(name string, sources ...string) *CSP {
+	name = strings.ToLower(name)
+	if _, ok := c.sources[name]; !ok {
+		c.names = append(c.names, name)
+	}
+	c.sources[name] = append([]string{}, sources...)
+	return c
+}
+
+// DefaultSrc sets the default-src directive.
+func (c *CSP) DefaultSrc(sources ...string) *CSP { return c.Directive("default-src", sources...) }
+
+// ScriptSrc sets the script-src directive.
+func (c *CSP) ScriptSrc(sources ...string) *CSP { return c.Directive("script-src", sources...) }
+
+// StyleSrc sets the style-src directive.
+func (c *CSP) StyleSrc(sources ...string) *CSP { return c.Directive("style-src", sources...) }
+
+// ImgSrc sets the img-src directive.
+func (c *CSP) ImgSrc(sources ...string) *CSP { return c.Directive("img-src", sources...) }
+
+// FontSrc sets the font-src directive.
+func (c *CSP) FontSrc(sources ...string) *CSP { return c.Directive("font-src", sources...) }
+
+// ConnectSrc sets the connect-src directive.
+func (c *CSP) ConnectSrc(sources ...string) *CSP { return c.Directive("connect-src", sources...) }
+
+// ObjectSrc sets the object-src directive.
+func (c *CSP) ObjectSrc(sources ...string) *CSP { return c.Directive("object-src", sources...) }
+
+// FrameSrc sets the frame-src directive.
+func (c *CSP) FrameSrc(sources ...string) *CSP { return c.Directive("frame-src", sources...) }
+
+// FrameAncestors sets the frame-ancestors directive.
+func (c *CSP) FrameAncestors(sources ...string) *CSP {
+	return c.Directive("frame-ancestors", sources...)
+}
+
+// BaseURI sets the base-uri directive.
+func (c *CSP) BaseURI(sources ...string) *CSP { return c.Directive("base-uri", sources...) }
+
+// FormAction sets the form-action directive.
+func (c *CSP) FormAction(sources ...string) *CSP { return c.Directive("form-action", sources...) }
+
+// UpgradeInsecureRequests sets the upgrade-insecure-requests directive.
+func (c *CSP) UpgradeInsecureRequests() *CSP { return c.Directive("upgrade-insecure-requests") }
+
+// ReportURI sets the report-uri directive, where browsers send violations.
+func (c *CSP) ReportURI(uri string) *CSP { return c.Directive("report-uri", uri) }
+
+// Clone returns a copy of the policy, to be modified for a specific route.
+func (c *CSP) Clone() *CSP {
+	cp := NewCSP()
+	for _, n := range c.names {
+		cp.Directive(n, c.sources[n]...)
+	}
+	return cp
+}
+
+// String returns the policy with the CSPNonce placeholder not replaced.
+func (c *CSP) String() string {
+	parts := make([]string, 0, len(c.names))
+	for _, n := range c.names {
+		parts = append(parts, strings.TrimSpace(n+" "+strings.Join(c.sources[n], " ")))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// usesNonce reports whether the policy contains the CSPNonce placeholder.
+func (c *CSP) usesNonce() bool {
+	for _, srcs := range c.sources {
+		for _, s := range srcs {
+			if s == CSPNonce {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,364 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/polldo/patweb/api/session"
+	"github.com/polldo/patweb/api/web"
+	"github.com/polldo/patweb/api/weberr"
+)
+
+// Defaults of the CSRF settings.
+const (
+	CSRFHeader     = "X-Csrf-Token"
+	CSRFFormField  = "csrf_token"
+	CSRFCookieName = "csrf_token"
+)
+
+// Codes of the errors of the CSRF middleware.
+const (
+	CSRFCodeOriginMismatch = "csrf_origin_mismatch"
+	CSRFCodeRefererMissing = "csrf_referer_missing"
+	CSRFCodeTokenMissing   = "csrf_token_missing"
+	CSRFCodeTokenInvalid   = "csrf_token_invalid"
+)
+
+// csrfSessionKey is the session key of synchronizer tokens.
+const csrfSessionKey = "csrf_token"
+
+// hostCookiePrefix makes browsers accept a cookie only if it's secure and
+// set by the host itself, so that sibling subdomains can't overwrite it.
+const hostCookiePrefix = "__Host-"
+
+// csrfTokenKeyCtx is the private type used to store the CSRF token in the context.
+type csrfTokenKeyCtx int
+
+// csrfTokenKey is the context key used to store the function returning the CSRF token.
+const csrfTokenKey csrfTokenKeyCtx = 1
+
+// ContextCSRFToken returns the CSRF token that clients must send back
+// with unsafe requests, to be embedded in forms or pages.
+// Synchronizer tokens are generated the first time they are read, so it
+// must be called before the response header is written.
+func ContextCSRFToken(ctx context.Context) string {
+	token, ok := ctx.Value(csrfTokenKey).(func() string)
+	if !ok {
+		return ""
+	}
+	return token()
+}
+
+// csrfConfig contains the settings of a CSRF middleware.
+type csrfConfig struct {
+	header   string
+	field    string
+	cookie   string
+	secret   []byte
+	insecure bool
+	origins  map[string]bool
+	exempt   map[string]bool
+}
+
+// CSRFOpt defines the type for CSRF options.
+type CSRFOpt func(*csrfConfig)
+
+// WithCSRFToken returns an option that sets the header and the form field
+// carrying the token, by default CSRFHeader and CSRFFormField.
+func WithCSRFToken(header, field string) CSRFOpt {
+	return func(c *csrfConfig) {
+		c.header, c.field = header, field
+	}
+}
+
+// WithCSRFCookie returns an option that sets the name of the cookie
+// of double-submit tokens, by default CSRFCookieName. The name gets
+// the '__Host-' prefix unless the cookie is insecure.
+func WithCSRFCookie(name string) CSRFOpt {
+	return func(c *csrfConfig) {
+		c.cookie = name
+	}
+}
+
+// WithCSRFSecret returns an option that signs the double-submit tokens
+// with secret, so that cookies not set by the API are rejected.
+// It should be a random key of at least 32 bytes.
+func WithCSRFSecret(secret []byte) CSRFOpt {
+	return func(c *csrfConfig) {
+		c.secret = secret
+	}
+}
+
+// WithCSRFInsecureCookie returns an option that sends the cookie of
+// double-submit tokens on plain HTTP too, without the '__Host-' prefix.
+// It's meant for development.
+func WithCSRFInsecureCookie() CSRFOpt {
+	return func(c *csrfConfig) {
+		c.insecure = true
+	}
+}
+
+// WithCSRFTrustedOrigins returns an option that accepts unsafe requests
+// from other origins, like 'https://app.example.com'.
+func WithCSRFTrustedOrigins(origins ...string) CSRFOpt {
+	return func(c *csrfConfig) {
+		for _, o := range origins {
+			c.origins[strings.ToLower(o)] = true
+		}
+	}
+}
+
+// WithCSRFExempt returns an option that exempts routes from the checks,
+// identified by their path templates, like '/webhooks/{provider}'.
+func WithCSRFExempt(routes ...string) CSRFOpt {
+	return func(c *csrfConfig) {
+		for _, r := range routes {
+			c.exempt[r] = true
+		}
+	}
+}
+
+// CSRF protects from cross-site request forgery the requests with unsafe
+// methods, rejecting them with a quiet 403 error carrying one of the
+// CSRFCode codes.
+//
+// Unsafe requests must come from the origin of the API, or a trusted one,
+// as told by the Origin header or, failing that, by the Referer header,
+// which is required for HTTPS requests. They must also carry the token
+// returned by ContextCSRFToken, in a header or in a form field. The header
+// is read first: the body is parsed only if the header is missing and it's
+// a form, so the middleware should follow BodyLimit.
+// If the request has a session, a synchronizer token is kept in it, so
+// the middleware must follow Sessions. The token is generated only when
+// read, so that anonymous visits don't create sessions. Otherwise the token
+// is kept in a '__Host-' cookie readable by scripts, signed if a secret is
+// configured, and checked as a double-submit token.
+func CSRF(opts ...CSRFOpt) web.Middleware {
+	cfg := csrfConfig{
+		header:  CSRFHeader,
+		field:   CSRFFormField,
+		cookie:  CSRFCookieName,
+		origins: map[string]bool{},
+		exempt:  map[string]bool{},
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			var expected string
+			var token func() string
+			if s, ok := ContextSession(ctx); ok {
+				expected, _ = s.Get(csrfSessionKey)
+				token = sessionCSRFToken(s)
+			} else {
+				expected = cfg.cookieToken(r)
+				current := expected
+				if current == "" {
+					t, err := cfg.newCookieToken(w)
+					if err != nil {
+						return err
+					}
+					current = t
+				}
+				token = func() string { return current }
+			}
+			ctx = context.WithValue(ctx, csrfTokenKey, token)
+
+			route, _ := routeTemplate(r)
+			if safeMethod(r.Method) || cfg.exempt[route] {
+				return handler(ctx, w, r)
+			}
+
+			if code, err := cfg.checkOrigin(ctx, r); err != nil {
+				return csrfError(err, code)
+			}
+
+			got := r.Header.Get(cfg.header)
+			if got == "" && cfg.field != "" {
+				got = formToken(r, cfg.field)
+			}
+			if got == "" {
+				return csrfError(errors.New("missing CSRF token"), CSRFCodeTokenMissing)
+			}
+			// Unsafe requests without a token of their own fail,
+			// since they can't carry a new one.
+			if expected == "" || subtle.ConstantTimeCompare([]byte(got), []byte(expected)) != 1 {
+				return csrfError(errors.New("invalid CSRF token"), CSRFCodeTokenInvalid)
+			}
+			return handler(ctx, w, r)
+		}
+		return h
+	}
+	return m
+}
+
+// sessionCSRFToken returns a function returning the token kept in s,
+// generating and storing it on the first call if missing.
+func sessionCSRFToken(s *session.Session) func() string {
+	var once sync.Once
+	var t string
+	return func() string {
+		once.Do(func() {
+			if v, ok := s.Get(csrfSessionKey); ok {
+				t = v
+				return
+			}
+			v, err := newCSRFToken()
+			if err != nil {
+				return
+			}
+			s.Set(csrfSessionKey, v)
+			t = v
+		})
+		return t
+	}
+}
+
+// cookieName returns the name of the cookie of double-submit tokens.
+func (c *csrfConfig) cookieName() string {
+	if c.insecure || strings.HasPrefix(c.cookie, hostCookiePrefix) {
+		return c.cookie
+	}
+	return hostCookiePrefix + c.cookie
+}
+
+// cookieToken returns the double-submit token of the request,
+// or an empty string if it's missing or its signature is invalid.
+func (c *csrfConfig) cookieToken(r *http.Request) string {
+	ck, err := r.Cookie(c.cookieName())
+	if err != nil || ck.Value == "" {
+		return ""
+	}
+	if c.secret == nil {
+		return ck.Value
+	}
+	t, sig, ok := strings.Cut(ck.Value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(c.sign(t))) {
+		return ""
+	}
+	return ck.Value
+}
+
+// newCookieToken generates a double-submit token and sets its cookie.
+func (c *csrfConfig) newCookieToken(w http.ResponseWriter) (string, error) {
+	t, err := newCSRFToken()
+	if err != nil {
+		return "", err
+	}
+	if c.secret != nil {
+		t += "." + c.sign(t)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.cookieName(),
+		Value:    t,
+		Path:     "/",
+		Secure:   !c.insecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return t, nil
+}
+
+// sign returns the signature of a double-submit token.
+func (c *csrfConfig) sign(token string) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// csrfMaxFormMemory is the part of multipart bodies kept in memory when
+// looking for the token, the rest is stored in temporary files.
+const csrfMaxFormMemory = 1 << 20
+
+// formToken returns the token in the form field of r, parsing the body only
+// for form content types. Parsing failures, like bodies over the limit set
+// by BodyLimit, result in a missing token.
+func formToken(r *http.Request, field string) string {
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch ct {
+	case "application/x-www-form-urlencoded":
+		if err := r.ParseForm(); err != nil {
+			return ""
+		}
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(csrfMaxFormMemory); err != nil {
+			return ""
+		}
+	default:
+		return ""
+	}
+	return r.PostForm.Get(field)
+}
+
+// checkOrigin verifies that the request comes from the host it's sent to,
+// as told by the Host header, or from a trusted origin. The scheme resolved
+// by RealIP, if in use, can only make the check stricter, requiring the
+// Referer header for HTTPS requests.
+func (c *csrfConfig) checkOrigin(ctx context.Context, r *http.Request) (string, error) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if addr, ok := ContextClientAddr(ctx); ok && addr.Scheme == "https" {
+		scheme = "https"
+	}
+	self := strings.ToLower(scheme + "://" + r.Host)
+
+	source := r.Header.Get("Origin")
+	if source == "" {
+		ref := r.Referer()
+		if ref == "" {
+			if scheme == "https" {
+				return CSRFCodeRefererMissing, errors.New("missing referer")
+			}
+			return "", nil
+		}
+		u, err := url.Parse(ref)
+		if err != nil || u.Host == "" {
+			return CSRFCodeOriginMismatch, fmt.Errorf("invalid referer %q", ref)
+		}
+		source = u.Scheme + "://" + u.Host
+	}
+	source = strings.ToLower(source)
+	if source != self && !c.origins[source] {
+		return CSRFCodeOriginMismatch, fmt.Errorf("origin %q not allowed", source)
+	}
+	return "", nil
+}
+
+// csrfError builds the error of a rejected request.
+func csrfError(err error, code string) error {
+	return newRequestError(err, http.StatusForbidden, "forbidden",
+		weberr.WithCode(code),
+		weberr.WithQuiet(true),
+	)
+}
+
+// safeMethod reports whether method is safe, i.e. it doesn't change the state.
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func newCSRFToken() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("cannot generate CSRF token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b[:]), nil
+}
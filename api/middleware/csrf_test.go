@@ -0,0 +1,227 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/polldo/patweb/api/cidr"
+	"github.com/polldo/patweb/api/session"
+	"github.com/polldo/patweb/api/web"
+	"github.com/polldo/patweb/api/weberr"
+)
+
+func TestCSRFDoubleSubmit(t *testing.T) {
+	h := CSRF(WithCSRFTrustedOrigins("https://app.example.com"), WithCSRFSecret([]byte("0123456789abcdef0123456789abcdef")))(
+		func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			return web.Respond(ctx, w, map[string]string{"token": ContextCSRFToken(ctx)}, http.StatusOK)
+		})
+
+	// A safe request gets the token cookie.
+	r := httptest.NewRequest(http.MethodGet, "https://api.example.com/form", nil)
+	w := httptest.NewRecorder()
+	if err := h(r.Context(), w, r); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "__Host-"+CSRFCookieName || !strings.Contains(w.Body.String(), cookies[0].Value) {
+		t.Fatalf("want token cookie, got %v %s", cookies, w.Body)
+	}
+	token := cookies[0]
+
+	tests := []struct {
+		name   string
+		origin string
+		ref    string
+		header string
+		form   string
+		cookie string
+		code   string
+	}{
+		{name: "header", origin: "https://api.example.com", header: token.Value},
+		{name: "form field", ref: "https://app.example.com/page", form: token.Value},
+		{name: "cross origin", origin: "https://evil.com", header: token.Value, code: CSRFCodeOriginMismatch},
+		{name: "no referer", header: token.Value, code: CSRFCodeRefererMissing},
+		{name: "no token", origin: "https://api.example.com", code: CSRFCodeTokenMissing},
+		{name: "wrong token", origin: "https://api.example.com", header: "forged", code: CSRFCodeTokenInvalid},
+		{name: "unsigned cookie", origin: "https://api.example.com", header: "forged", cookie: "forged", code: CSRFCodeTokenInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := ""
+			if tt.form != "" {
+				body = url.Values{CSRFFormField: {tt.form}}.Encode()
+			}
+			r := httptest.NewRequest(http.MethodPost, "https://api.example.com/form", strings.NewReader(body))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: token.Name, Value: tt.cookie})
+			} else {
+				r.AddCookie(token)
+			}
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.ref != "" {
+				r.Header.Set("Referer", tt.ref)
+			}
+			if tt.header != "" {
+				r.Header.Set(CSRFHeader, tt.header)
+			}
+
+			err := h(r.Context(), httptest.NewRecorder(), r)
+			if tt.code == "" {
+				if err != nil {
+					t.Fatalf("want request accepted, got %v", err)
+				}
+				return
+			}
+			code, _ := weberr.Code(err)
+			if code != tt.code || errorStatus(err) != http.StatusForbidden || !weberr.IsQuiet(err) {
+				t.Fatalf("want quiet 403 with code %s, got %v %q", tt.code, err, code)
+			}
+			body2, _, _ := weberr.Response(err)
+			if resp, ok := body2.(*web.ErrorResponse); !ok || resp.Code != tt.code {
+				t.Errorf("want code in response, got %+v", body2)
+			}
+		})
+	}
+}
+
+func TestCSRFSynchronizer(t *testing.T) {
+	m := session.NewStoreManager(session.NewMemoryStore())
+	var token string
+	h := web.WrapMiddleware([]web.Middleware{Sessions(m), CSRF(WithCSRFExempt("/webhook"))},
+		func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			if r.URL.Path != "/page" {
+				token = ContextCSRFToken(ctx)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		})
+
+	// The token is generated only when read, so pages without forms
+	// don't create sessions.
+	r := httptest.NewRequest(http.MethodGet, "http://api.example.com/page", nil)
+	w := httptest.NewRecorder()
+	if err := h(r.Context(), w, r); err != nil {
+		t.Fatal(err)
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 0 {
+		t.Fatalf("want no session without reading the token, got %v", cookies)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "http://api.example.com/form", nil)
+	w = httptest.NewRecorder()
+	if err := h(r.Context(), w, r); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != session.DefaultCookieName || token == "" {
+		t.Fatalf("want token kept in the session, got %v", cookies)
+	}
+
+	post := func(path, tok string) error {
+		r := httptest.NewRequest(http.MethodPost, "http://api.example.com"+path, nil)
+		r.AddCookie(cookies[0])
+		r.Header.Set(CSRFHeader, tok)
+		return h(r.Context(), httptest.NewRecorder(), r)
+	}
+	if err := post("/form", token); err != nil {
+		t.Fatalf("want session token accepted, got %v", err)
+	}
+	if err := post("/form", "forged"); err == nil {
+		t.Fatal("want forged token rejected")
+	}
+	if err := post("/webhook", ""); err != nil {
+		t.Fatalf("want exempt route accepted, got %v", err)
+	}
+}
+
+func TestCSRFRequest(t *testing.T) {
+	var body string
+	h := web.WrapMiddleware([]web.Middleware{RealIP(cidr.MustParse("10.0.0.0/8")), CSRF(WithCSRFInsecureCookie())},
+		func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			b, err := io.ReadAll(r.Body)
+			body = string(b)
+			return err
+		})
+
+	r := httptest.NewRequest(http.MethodGet, "http://api.example.com/form", nil)
+	w := httptest.NewRecorder()
+	if err := h(r.Context(), w, r); err != nil {
+		t.Fatal(err)
+	}
+	token := w.Result().Cookies()[0]
+
+	var multi bytes.Buffer
+	mw := multipart.NewWriter(&multi)
+	_ = mw.WriteField(CSRFFormField, token.Value)
+	mw.Close()
+
+	tests := []struct {
+		name        string
+		header      http.Header
+		contentType string
+		body        string
+		code        string
+	}{
+		{
+			name:        "header token keeps the body",
+			header:      http.Header{"Origin": {"http://api.example.com"}, CSRFHeader: {token.Value}},
+			contentType: "application/json",
+			body:        `{"csrf_token":"ignored"}`,
+		},
+		{
+			name:        "multipart field",
+			header:      http.Header{"Origin": {"http://api.example.com"}},
+			contentType: mw.FormDataContentType(),
+			body:        multi.String(),
+		},
+		{
+			name:        "body not a form",
+			header:      http.Header{"Origin": {"http://api.example.com"}},
+			contentType: "text/plain",
+			body:        CSRFFormField + "=" + token.Value,
+			code:        CSRFCodeTokenMissing,
+		},
+		{
+			name: "forwarded host not trusted",
+			header: http.Header{
+				"Origin":           {"http://evil.com"},
+				"X-Forwarded-For":  {"198.51.100.1"},
+				"X-Forwarded-Host": {"evil.com"},
+				CSRFHeader:         {token.Value},
+			},
+			code: CSRFCodeOriginMismatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body = ""
+			r := httptest.NewRequest(http.MethodPost, "http://api.example.com/form", strings.NewReader(tt.body))
+			r.RemoteAddr = "10.0.0.1:1234"
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			r.AddCookie(token)
+
+			err := h(r.Context(), httptest.NewRecorder(), r)
+			if code, _ := weberr.Code(err); code != tt.code {
+				t.Fatalf("want code %q, got %v", tt.code, err)
+			}
+			if tt.code == "" && strings.HasPrefix(tt.contentType, "application/json") && body != tt.body {
+				t.Errorf("want body left to the handler, got %q", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,368 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/polldo/patweb/api/web"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultDumpMaxBody is the default maximum size of the dumped bodies.
+const DefaultDumpMaxBody = 4096
+
+// redacted replaces the redacted values in dumps.
+const redacted = "[REDACTED]"
+
+// defaultRedactedHeaders lists the headers always redacted in dumps.
+var defaultRedactedHeaders = []string{
+	"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", APIKeyHeader, CSRFHeader,
+}
+
+// defaultRedactedFields lists the form fields always redacted in dumps.
+var defaultRedactedFields = []string{"password", CSRFFormField}
+
+// dumpConfig contains the settings of a Dump middleware.
+type dumpConfig struct {
+	sampleRate float64
+	header     string
+	secret     string
+	maxBody    int
+	headers    map[string]bool
+	paths      [][]string
+	fields     map[string]bool
+}
+
+// DumpOpt defines the type for Dump options.
+type DumpOpt func(*dumpConfig)
+
+// WithDumpSampleRate returns an option that dumps a random fraction
+// of the requests, between 0 and 1.
+func WithDumpSampleRate(rate float64) DumpOpt {
+	return func(c *dumpConfig) {
+		c.sampleRate = rate
+	}
+}
+
+// WithDumpTrigger returns an option that dumps the requests carrying
+// the header with the given secret value. The header is redacted.
+func WithDumpTrigger(header, secret string) DumpOpt {
+	return func(c *dumpConfig) {
+		c.header = header
+		c.secret = secret
+		c.headers[http.CanonicalHeaderKey(header)] = true
+	}
+}
+
+// WithDumpMaxBody returns an option that sets the maximum size of the dumped
+// bodies. Longer bodies are truncated in the dump, not for the handler.
+func WithDumpMaxBody(n int) DumpOpt {
+	return func(c *dumpConfig) {
+		c.maxBody = n
+	}
+}
+
+// WithDumpRedactHeaders returns an option that redacts the given headers,
+// in addition to the ones carrying credentials.
+func WithDumpRedactHeaders(names ...string) DumpOpt {
+	return func(c *dumpConfig) {
+		for _, n := range names {
+			c.headers[http.CanonicalHeaderKey(n)] = true
+		}
+	}
+}
+
+// WithDumpRedactJSON returns an option that redacts the values of JSON bodies
+// at the given dotted paths, like 'password' or 'cards.*.number', where '*'
+// matches any key or array element.
+func WithDumpRedactJSON(paths ...string) DumpOpt {
+	return func(c *dumpConfig) {
+		for _, p := range paths {
+			c.paths = append(c.paths, strings.Split(p, "."))
+		}
+	}
+}
+
+// WithDumpRedactFields returns an option that redacts the values of the
+// given fields of form-encoded and multipart bodies, in addition to the
+// password and CSRF token fields.
+func WithDumpRedactFields(names ...string) DumpOpt {
+	return func(c *dumpConfig) {
+		for _, n := range names {
+			c.fields[n] = true
+		}
+	}
+}
+
+// Dump logs the headers and bodies of requests and responses, to debug the
+// interactions with clients. Without options every request is dumped, which
+// suits routes passed to api.Handle; WithDumpSampleRate and WithDumpTrigger
+// restrict the dumps to sampled or explicitly triggered requests.
+//
+// Bodies are captured up to a maximum size, and the request body stays
+// readable by the handler. Headers with credentials are redacted, as are
+// the configured headers, JSON paths and form fields, and the contents of
+// uploaded files are omitted. JSON and form bodies that can't be parsed are
+// omitted, as are truncated JSON bodies when JSON paths are redacted.
+// Error responses are dumped only if it's placed before the Errors middleware.
+func Dump(log logrus.FieldLogger, opts ...DumpOpt) web.Middleware {
+	cfg := dumpConfig{maxBody: DefaultDumpMaxBody, headers: map[string]bool{}, fields: map[string]bool{}}
+	for _, h := range defaultRedactedHeaders {
+		cfg.headers[h] = true
+	}
+	for _, f := range defaultRedactedFields {
+		cfg.fields[f] = true
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	triggered := cfg.sampleRate > 0 || cfg.header != ""
+
+	var mu sync.Mutex
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	sampled := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return rnd.Float64() < cfg.sampleRate
+	}
+
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			dump := !triggered ||
+				(cfg.header != "" && cfg.secret != "" &&
+					subtle.ConstantTimeCompare([]byte(r.Header.Get(cfg.header)), []byte(cfg.secret)) == 1) ||
+				(cfg.sampleRate > 0 && sampled())
+			if !dump {
+				return handler(ctx, w, r)
+			}
+
+			reqBody, reqTruncated := peekBody(r, cfg.maxBody)
+			reqHeader := cfg.redactHeaders(r.Header)
+
+			dw := &dumpWriter{ResponseWriter: w, max: cfg.maxBody}
+			err := handler(ctx, dw, r)
+
+			fields := logrus.Fields{
+				"req_id":          ContextRequestID(ctx),
+				"method":          r.Method,
+				"path":            r.URL.Path,
+				"request_header":  reqHeader,
+				"request_body":    cfg.redactBody(r.Header, reqBody, reqTruncated),
+				"response_status": dw.status,
+				"response_header": cfg.redactHeaders(dw.header),
+				"response_body":   cfg.redactBody(dw.header, dw.body.Bytes(), dw.truncated),
+			}
+			if err != nil {
+				fields["error"] = err.Error()
+			}
+			log.WithFields(fields).Info("dump")
+			return err
+		}
+		return h
+	}
+	return m
+}
+
+// peekBody reads up to max bytes of the request body, then restores it.
+func peekBody(r *http.Request, max int) (body []byte, truncated bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, false
+	}
+	buf := make([]byte, max+1)
+	n, err := io.ReadFull(r.Body, buf)
+	buf = buf[:n]
+
+	var rest io.Reader
+	switch err {
+	case nil:
+		rest = r.Body
+	case io.EOF, io.ErrUnexpectedEOF:
+		rest = bytes.NewReader(nil)
+	default:
+		rest = errReader{err}
+	}
+	r.Body = &peekedBody{Reader: io.MultiReader(bytes.NewReader(buf), rest), Closer: r.Body}
+
+	if n > max {
+		return buf[:max], true
+	}
+	return buf, false
+}
+
+// peekedBody is a request body whose beginning has already been read.
+type peekedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// errReader fails with its error, to return the failure of a peeked body
+// after the data read.
+type errReader struct{ err error }
+
+func (e errReader) Read([]byte) (int, error) { return 0, e.err }
+
+// redactHeaders returns a copy of h with the redacted headers masked.
+func (c *dumpConfig) redactHeaders(h http.Header) http.Header {
+	cp := h.Clone()
+	for k := range cp {
+		if c.headers[k] {
+			cp[k] = []string{redacted}
+		}
+	}
+	return cp
+}
+
+// redactBody returns the body to log.
+func (c *dumpConfig) redactBody(h http.Header, body []byte, truncated bool) string {
+	suffix := ""
+	if truncated {
+		suffix = "...[TRUNCATED]"
+	}
+	ct, params, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	switch {
+	case len(body) == 0:
+		return ""
+	case ct == "application/x-www-form-urlencoded":
+		return c.redactForm(body, truncated) + suffix
+	case ct == "multipart/form-data":
+		return c.redactMultipart(body, params["boundary"], truncated) + suffix
+	}
+	isJSON := ct == "application/json" || strings.HasSuffix(ct, "+json")
+	if len(c.paths) == 0 || !isJSON {
+		return string(body) + suffix
+	}
+
+	var v interface{}
+	if truncated || json.Unmarshal(body, &v) != nil {
+		return "[OMITTED: JSON body of " + strconv.Itoa(len(body)) + " bytes cannot be redacted]"
+	}
+	for _, p := range c.paths {
+		v = redactPath(v, p)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "[OMITTED: JSON body cannot be redacted]"
+	}
+	return string(b)
+}
+
+// redactForm masks the redacted fields of a form-encoded body.
+func (c *dumpConfig) redactForm(body []byte, truncated bool) string {
+	form, err := url.ParseQuery(string(body))
+	if err != nil && !truncated {
+		return "[OMITTED: form body of " + strconv.Itoa(len(body)) + " bytes cannot be redacted]"
+	}
+	for k := range form {
+		if c.fields[k] {
+			form[k] = []string{redacted}
+		}
+	}
+	return form.Encode()
+}
+
+// redactMultipart summarizes a multipart body as a form, with the redacted
+// fields masked and the files replaced by their name and size. The parts
+// of a truncated body are kept up to the truncation.
+func (c *dumpConfig) redactMultipart(body []byte, boundary string, truncated bool) string {
+	form := url.Values{}
+	mr := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF || (err != nil && truncated) {
+			break
+		}
+		if err != nil {
+			return "[OMITTED: multipart body of " + strconv.Itoa(len(body)) + " bytes cannot be redacted]"
+		}
+		name := p.FormName()
+		v, err := io.ReadAll(p)
+		if err != nil && !truncated {
+			return "[OMITTED: multipart body of " + strconv.Itoa(len(body)) + " bytes cannot be redacted]"
+		}
+		switch {
+		case c.fields[name]:
+			form.Add(name, redacted)
+		case p.FileName() != "":
+			form.Add(name, "[FILE "+p.FileName()+", "+strconv.Itoa(len(v))+" bytes]")
+		default:
+			form.Add(name, string(v))
+		}
+		if err != nil {
+			break
+		}
+	}
+	return form.Encode()
+}
+
+// redactPath masks the values of v at path.
+func redactPath(v interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return redacted
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			if path[0] == "*" || path[0] == k {
+				t[k] = redactPath(child, path[1:])
+			}
+		}
+	case []interface{}:
+		for i, child := range t {
+			if path[0] == "*" || path[0] == strconv.Itoa(i) {
+				t[i] = redactPath(child, path[1:])
+			}
+		}
+	}
+	return v
+}
+
+// dumpWriter captures the response while sending it.
+type dumpWriter struct {
+	http.ResponseWriter
+	max       int
+	status    int
+	header    http.Header
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (dw *dumpWriter) WriteHeader(code int) {
+	if dw.status == 0 {
+		dw.status = code
+		dw.header = dw.Header().Clone()
+	}
+	dw.ResponseWriter.WriteHeader(code)
+}
+
+func (dw *dumpWriter) Write(b []byte) (int, error) {
+	if dw.status == 0 {
+		dw.WriteHeader(http.StatusOK)
+	}
+	if room := dw.max - dw.body.Len(); room < len(b) {
+		if room > 0 {
+			dw.body.Write(b[:room])
+		}
+		dw.truncated = true
+	} else {
+		dw.body.Write(b)
+	}
+	return dw.ResponseWriter.Write(b)
+}
+
+func (dw *dumpWriter) Flush() {
+	if f, ok := dw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/polldo/patweb/api/web"
+	"github.com/sirupsen/logrus"
+)
+
+func TestDump(t *testing.T) {
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(&buf)
+	log.SetFormatter(&logrus.JSONFormatter{})
+
+	h := Dump(log,
+		WithDumpTrigger("X-Debug", "s3cret"),
+		WithDumpRedactJSON("password", "cards.*.number"),
+	)(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		var in map[string]interface{}
+		if err := web.Decode(r, &in); err != nil {
+			return err
+		}
+		return web.Respond(ctx, w, in, http.StatusOK)
+	})
+
+	body := `{"user":"bob","password":"hunter2","cards":[{"number":"4111","exp":"12/30"}]}`
+	serve := func(secret string) map[string]interface{} {
+		buf.Reset()
+		r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Authorization", "Bearer token")
+		r.Header.Set("X-Debug", secret)
+		w := httptest.NewRecorder()
+		if err := h(r.Context(), w, r); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(w.Body.String(), "hunter2") {
+			t.Fatalf("handler must read the whole body, got %q", w.Body.String())
+		}
+		if buf.Len() == 0 {
+			return nil
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		return entry
+	}
+
+	if entry := serve("wrong"); entry != nil {
+		t.Fatalf("want no dump without the secret, got %v", entry)
+	}
+
+	entry := serve("s3cret")
+	if entry == nil {
+		t.Fatal("want dump with the secret")
+	}
+	dump := buf.String()
+	for _, secret := range []string{"hunter2", "4111", "Bearer token", "s3cret"} {
+		if strings.Contains(dump, secret) {
+			t.Errorf("dump leaks %q: %s", secret, dump)
+		}
+	}
+	if !strings.Contains(entry["request_body"].(string), `"exp":"12/30"`) || entry["response_status"] != float64(http.StatusOK) {
+		t.Errorf("unexpected dump %v", entry)
+	}
+}
+
+func TestDumpTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(&buf)
+
+	body := strings.Repeat("a", 100)
+	var got string
+	h := Dump(log, WithDumpMaxBody(10))(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		b := new(bytes.Buffer)
+		_, err := b.ReadFrom(r.Body)
+		got = b.String()
+		return err
+	})
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	if err := h(r.Context(), httptest.NewRecorder(), r); err != nil {
+		t.Fatal(err)
+	}
+	if got != body {
+		t.Errorf("want whole body for the handler, got %d bytes", len(got))
+	}
+	if !strings.Contains(buf.String(), strings.Repeat("a", 10)+"...[TRUNCATED]") || strings.Contains(buf.String(), strings.Repeat("a", 11)) {
+		t.Errorf("want truncated body in dump, got %s", buf.String())
+	}
+}
+
+func TestDumpForms(t *testing.T) {
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(&buf)
+
+	h := Dump(log, WithDumpRedactFields("pin"))(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		if err := r.ParseMultipartForm(1 << 20); err != nil && err != http.ErrNotMultipart {
+			return err
+		}
+		if r.PostFormValue("password") != "hunter2" {
+			t.Errorf("handler must read the whole form, got %v", r.PostForm)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+
+	var multi bytes.Buffer
+	mw := multipart.NewWriter(&multi)
+	_ = mw.WriteField("user", "bob")
+	_ = mw.WriteField("password", "hunter2")
+	fw, _ := mw.CreateFormFile("avatar", "bob.png")
+	_, _ = fw.Write([]byte("png-secret-bytes"))
+	mw.Close()
+
+	tests := []struct {
+		name, contentType, body string
+	}{
+		{"urlencoded", "application/x-www-form-urlencoded", "user=bob&password=hunter2&csrf_token=tok-1&pin=1234"},
+		{"multipart", mw.FormDataContentType(), multi.String()},
+	}
+	for _, tt := range tests {
+		buf.Reset()
+		r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", tt.contentType)
+		r.Header.Set(CSRFHeader, "tok-2")
+		r.AddCookie(&http.Cookie{Name: "session", Value: "sess-3"})
+		if err := h(r.Context(), httptest.NewRecorder(), r); err != nil {
+			t.Fatal(err)
+		}
+		dump := buf.String()
+		for _, secret := range []string{"hunter2", "tok-1", "tok-2", "sess-3", "1234", "png-secret-bytes"} {
+			if strings.Contains(dump, secret) {
+				t.Errorf("%s: dump leaks %q: %s", tt.name, secret, dump)
+			}
+		}
+		if !strings.Contains(dump, "user=bob") {
+			t.Errorf("%s: want other fields dumped, got %s", tt.name, dump)
+		}
+	}
+}
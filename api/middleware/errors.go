@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/polldo/patweb/api/trace"
 	"github.com/polldo/patweb/api/web"
 	"github.com/polldo/patweb/api/weberr"
 	"github.com/sirupsen/logrus"
@@ -29,11 +30,20 @@ func Errors(log logrus.FieldLogger) web.Middleware {
 				"req_id":  ContextRequestID(ctx),
 				"message": err,
 			}
+			span := trace.SpanFromContext(ctx)
+			if span != nil {
+				fields["trace_id"] = span.TraceID()
+			}
+			if code, ok := weberr.Code(err); ok {
+				fields["code"] = code
+			}
 			if f, ok := weberr.Fields(err); ok {
 				for k, v := range f {
 					fields[k] = v
 				}
+				span.SetAttributes(f)
 			}
+			span.RecordError(err)
 
 			// Log the error with the appropriate level.
 			loglvl := log.WithFields(logrus.Fields(fields)).Error
@@ -42,6 +52,16 @@ func Errors(log logrus.FieldLogger) web.Middleware {
 			}
 			loglvl("ERROR")
 
+			// Set the headers carried by the error, like Retry-After.
+			if hs, ok := weberr.Headers(err); ok {
+				for k, vs := range hs {
+					w.Header().Del(k)
+					for _, v := range vs {
+						w.Header().Add(k, v)
+					}
+				}
+			}
+
 			// Try to retrieve a response from the error.
 			if body, code, ok := weberr.Response(err); ok {
 				return web.Respond(ctx, w, body, code)
@@ -59,3 +79,21 @@ func Errors(log logrus.FieldLogger) web.Middleware {
 	}
 	return m
 }
+
+// errorStatus returns the status code that the Errors middleware
+// responds with when handling the error.
+func errorStatus(err error) int {
+	if _, code, ok := weberr.Response(err); ok {
+		return code
+	}
+	return http.StatusInternalServerError
+}
+
+// newRequestError wraps err with a response having the given status and message,
+// together with the additional behaviors specified by opts.
+// The code of the error, if any, is included in the response.
+func newRequestError(err error, status int, msg string, opts ...weberr.Opt) error {
+	err = weberr.Wrap(err, opts...)
+	code, _ := weberr.Code(err)
+	return weberr.Wrap(err, weberr.WithResponse(&web.ErrorResponse{Error: msg, Status: http.StatusText(status), Code: code}, status))
+}
@@ -0,0 +1,247 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/polldo/patweb/api/idempotency"
+	"github.com/polldo/patweb/api/web"
+	"github.com/polldo/patweb/api/weberr"
+)
+
+const (
+	// IdempotencyKeyHeader is the default header carrying the idempotency key.
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// IdempotentReplayedHeader is set on the responses replayed from the store.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	// maxIdempotencyKeyLen is the maximum length of the keys accepted.
+	maxIdempotencyKeyLen = 255
+)
+
+// idempotentHeaders lists the headers stored with the responses.
+// They describe the representation, while the other headers, like cookies,
+// request IDs or rate limits, are about the request that got the response.
+var idempotentHeaders = []string{
+	"Cache-Control",
+	"Content-Disposition",
+	"Content-Encoding",
+	"Content-Language",
+	"Content-Location",
+	"Content-Type",
+	"ETag",
+	"Expires",
+	"Last-Modified",
+	"Link",
+	"Location",
+}
+
+// idempotencyConfig contains the settings of an Idempotency middleware.
+type idempotencyConfig struct {
+	header  string
+	ttl     time.Duration
+	lockTTL time.Duration
+}
+
+// IdempotencyOpt defines the type for Idempotency options.
+type IdempotencyOpt func(*idempotencyConfig)
+
+// WithIdempotencyHeader returns an option that sets the header carrying the key.
+func WithIdempotencyHeader(name string) IdempotencyOpt {
+	return func(c *idempotencyConfig) {
+		c.header = name
+	}
+}
+
+// WithIdempotencyTTL returns an option that sets for how long responses are stored.
+func WithIdempotencyTTL(ttl time.Duration) IdempotencyOpt {
+	return func(c *idempotencyConfig) {
+		c.ttl = ttl
+	}
+}
+
+// WithIdempotencyLockTTL returns an option that sets for how long a key is
+// reserved while its request is processed. It should be longer than the
+// timeout of requests, and bounds how long a crashed process holds a key.
+func WithIdempotencyLockTTL(ttl time.Duration) IdempotencyOpt {
+	return func(c *idempotencyConfig) {
+		c.lockTTL = ttl
+	}
+}
+
+// Idempotency makes the unsafe requests carrying an Idempotency-Key header
+// safe to retry. The response to the first request with a key is stored and
+// replayed, with the Idempotent-Replayed header, to the retries of the request.
+// Keys are scoped to the method, the route and the authenticated subject:
+// without authentication all clients share the same keys, and any client
+// can get the response stored for a key used by another one.
+// Only the headers describing the representation, like Content-Type,
+// Location and ETag, are stored and replayed.
+//
+// A retry arriving while the first request is processed fails with a quiet
+// 409 error, and a key reused with a different body fails with a quiet 422
+// error. Requests failing with an error or a 5xx status are not stored,
+// so that they can be retried.
+//
+// It should be placed after the Errors middleware, and after the authentication
+// middlewares so that keys of different clients never collide.
+func Idempotency(store idempotency.Store, opts ...IdempotencyOpt) web.Middleware {
+	cfg := idempotencyConfig{
+		header:  IdempotencyKeyHeader,
+		ttl:     24 * time.Hour,
+		lockTTL: time.Minute,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			key := r.Header.Get(cfg.header)
+			if key == "" || !unsafeMethod(r.Method) {
+				return handler(ctx, w, r)
+			}
+			if len(key) > maxIdempotencyKeyLen {
+				err := fmt.Errorf("idempotency key of %d bytes", len(key))
+				return newRequestError(err, http.StatusBadRequest,
+					fmt.Sprintf("%s must be at most %d characters", cfg.header, maxIdempotencyKeyLen),
+					weberr.WithQuiet(true),
+				)
+			}
+
+			// Hash the body and restore it for the handler.
+			var hash string
+			if r.Body != nil && r.Body != http.NoBody {
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					return fmt.Errorf("cannot read request body: %w", err)
+				}
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				sum := sha256.Sum256(body)
+				hash = hex.EncodeToString(sum[:])
+			}
+
+			route, _ := routeTemplate(r)
+			skey := r.Method + " " + route + " " + ContextSubject(ctx) + " " + key
+			fields := weberr.WithFields(map[string]interface{}{"idempotency_key": key})
+
+			rec, ok, err := store.Begin(ctx, skey, hash, cfg.lockTTL)
+			if err != nil {
+				return fmt.Errorf("cannot reserve idempotency key: %w", err)
+			}
+			if !ok {
+				switch {
+				case rec.BodyHash != hash:
+					return newRequestError(idempotency.ErrKeyReused, http.StatusUnprocessableEntity,
+						fmt.Sprintf("%s already used for a different request", cfg.header),
+						fields, weberr.WithQuiet(true),
+					)
+				case !rec.Done:
+					return newRequestError(idempotency.ErrInProgress, http.StatusConflict,
+						fmt.Sprintf("a request with the same %s is in progress", cfg.header),
+						weberr.WithHeaders(http.Header{"Retry-After": {"1"}}),
+						fields, weberr.WithQuiet(true),
+					)
+				}
+				return replay(w, rec)
+			}
+
+			// Release the key unless the response is stored, even on panics.
+			// The store is accessed without the cancellation of the request,
+			// since the key must be released even if the client went away.
+			sctx := web.Detach(ctx)
+			stored := false
+			defer func() {
+				if !stored {
+					_ = store.Release(sctx, skey)
+				}
+			}()
+
+			rw := &recordWriter{ResponseWriter: w}
+			if err := handler(ctx, rw, r); err != nil {
+				return err
+			}
+			if rw.status == 0 || rw.status >= http.StatusInternalServerError {
+				return nil
+			}
+
+			res := &idempotency.Record{BodyHash: hash, Status: rw.status, Header: rw.header, Body: rw.body.Bytes()}
+			if err := store.Complete(sctx, skey, res, cfg.ttl); err != nil {
+				return fmt.Errorf("cannot store idempotent response: %w", err)
+			}
+			stored = true
+			return nil
+		}
+		return h
+	}
+	return m
+}
+
+// unsafeMethod reports whether requests with method may change the state of the server.
+func unsafeMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// replay writes a stored response. Headers already set, like the
+// request ID of the retry, are kept.
+func replay(w http.ResponseWriter, rec *idempotency.Record) error {
+	h := w.Header()
+	for k, v := range rec.Header {
+		if _, ok := h[k]; !ok {
+			h[k] = v
+		}
+	}
+	h.Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(rec.Status)
+	if _, err := w.Write(rec.Body); err != nil {
+		return fmt.Errorf("cannot write replayed response: %w", err)
+	}
+	return nil
+}
+
+// recordWriter records the response while sending it.
+type recordWriter struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (rw *recordWriter) WriteHeader(code int) {
+	if rw.status == 0 {
+		rw.status = code
+		rw.header = http.Header{}
+		h := rw.Header()
+		for _, k := range idempotentHeaders {
+			if v, ok := h[k]; ok {
+				rw.header[k] = append([]string(nil), v...)
+			}
+		}
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recordWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *recordWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
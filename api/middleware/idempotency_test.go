@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/polldo/patweb/api/idempotency"
+)
+
+func TestIdempotency(t *testing.T) {
+	calls := 0
+	started, release := make(chan struct{}), make(chan struct{})
+	h := Idempotency(idempotency.NewMemoryStore())(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		calls++
+		if r.Header.Get("X-Block") != "" {
+			close(started)
+			<-release
+		}
+		b, _ := io.ReadAll(r.Body)
+		w.Header().Set("Location", "/orders/1")
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "first"})
+		w.WriteHeader(http.StatusCreated)
+		_, err := fmt.Fprintf(w, "created %s", b)
+		return err
+	})
+
+	do := func(key, body string) (*httptest.ResponseRecorder, error) {
+		r := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+		r.Header.Set(IdempotencyKeyHeader, key)
+		if key == "slow" {
+			r.Header.Set("X-Block", "1")
+		}
+		w := httptest.NewRecorder()
+		return w, h(r.Context(), w, r)
+	}
+
+	w, err := do("a", "order")
+	if err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("want first request processed, got %d %v", w.Code, err)
+	}
+
+	w, err = do("a", "order")
+	if err != nil || calls != 1 {
+		t.Fatalf("want retry replayed without calling the handler, got %d calls %v", calls, err)
+	}
+	if w.Code != http.StatusCreated || w.Body.String() != "created order" ||
+		w.Header().Get("Location") != "/orders/1" || w.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Errorf("unexpected replayed response %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+	if got := w.Header().Get("Set-Cookie"); got != "" {
+		t.Errorf("want cookies of the first response not replayed, got %q", got)
+	}
+
+	if _, err := do("a", "other order"); errorStatus(err) != http.StatusUnprocessableEntity || !errors.Is(err, idempotency.ErrKeyReused) {
+		t.Errorf("want 422 for a different body, got %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := do("slow", "order")
+		done <- err
+	}()
+	<-started
+	if _, err := do("slow", "order"); errorStatus(err) != http.StatusConflict {
+		t.Errorf("want 409 for a request in progress, got %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIdempotencyReleaseOnError(t *testing.T) {
+	fail := true
+	h := Idempotency(idempotency.NewMemoryStore())(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		if fail {
+			return errors.New("failure")
+		}
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set(IdempotencyKeyHeader, "k")
+	if err := h(r.Context(), httptest.NewRecorder(), r); err == nil {
+		t.Fatal("want handler error")
+	}
+	fail = false
+	w := httptest.NewRecorder()
+	if err := h(r.Context(), w, r); err != nil || w.Header().Get(IdempotentReplayedHeader) != "" {
+		t.Errorf("want failed request processed again, got %v", err)
+	}
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/polldo/patweb/api/ipfilter"
+	"github.com/polldo/patweb/api/web"
+	"github.com/polldo/patweb/api/weberr"
+)
+
+// IPFilter rejects the requests whose client address is not allowed by list,
+// with a quiet 403 error carrying the matched rule in its fields.
+// The client address is the one resolved by RealIP, if in use.
+// It can be used globally or passed to api.Handle for specific routes,
+// like admin endpoints reachable only from internal networks.
+func IPFilter(list *ipfilter.List) web.Middleware {
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			ip := ClientIP(ctx, r)
+			d := list.Check(net.ParseIP(ip))
+			if !d.Allowed {
+				err := fmt.Errorf("client ip %q rejected by rule %q", ip, d.Rule)
+				return newRequestError(err, http.StatusForbidden, "forbidden",
+					weberr.WithFields(map[string]interface{}{"client_ip": ip, "ip_rule": d.Rule}),
+					weberr.WithQuiet(true),
+				)
+			}
+			return handler(ctx, w, r)
+		}
+		return h
+	}
+	return m
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/polldo/patweb/api/cidr"
+	"github.com/polldo/patweb/api/ipfilter"
+	"github.com/polldo/patweb/api/web"
+	"github.com/polldo/patweb/api/weberr"
+)
+
+func TestIPFilter(t *testing.T) {
+	list := ipfilter.New(cidr.MustParse("198.51.100.0/24"), nil)
+	h := web.WrapMiddleware([]web.Middleware{RealIP(cidr.MustParse("10.0.0.0/8")), IPFilter(list)},
+		func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		})
+
+	serve := func(forwarded string) (*httptest.ResponseRecorder, error) {
+		r := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("X-Forwarded-For", forwarded)
+		w := httptest.NewRecorder()
+		return w, h(r.Context(), w, r)
+	}
+
+	if w, err := serve("198.51.100.7"); err != nil || w.Code != http.StatusNoContent {
+		t.Fatalf("want allowed client, got %d %v", w.Code, err)
+	}
+
+	_, err := serve("203.0.113.1")
+	if err == nil || errorStatus(err) != http.StatusForbidden {
+		t.Fatalf("want 403 error, got %v", err)
+	}
+	if !weberr.IsQuiet(err) {
+		t.Error("want quiet error")
+	}
+	if f, _ := weberr.Fields(err); f["ip_rule"] != "default deny" || f["client_ip"] != "203.0.113.1" {
+		t.Errorf("unexpected fields %v", f)
+	}
+}
@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/polldo/patweb/api/auth"
+	"github.com/polldo/patweb/api/jwt"
+	"github.com/polldo/patweb/api/web"
+	"github.com/polldo/patweb/api/weberr"
+)
+
+// claimsKeyCtx is the private type used to store the token claims in the context.
+type claimsKeyCtx int
+
+// claimsKey is the context key used to store the token claims.
+const claimsKey claimsKeyCtx = 1
+
+// JWT authenticates requests carrying a bearer token in the Authorization header.
+// The claims of valid tokens are stored in the context, retrieve them using ContextClaims.
+// The subject of the token is also stored as principal, with the scopes as roles.
+// Requests without a valid token fail with a quiet 401 error carrying
+// the WWW-Authenticate challenge for the given realm.
+func JWT(v *jwt.Verifier, realm string) web.Middleware {
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			token, ok := bearerToken(r)
+			if !ok {
+				err := errors.New("missing bearer token")
+				return unauthorized(err, bearerChallenge(realm, "", ""))
+			}
+
+			claims, err := v.Verify(ctx, token)
+			if err != nil {
+				err = fmt.Errorf("invalid bearer token: %w", err)
+				return unauthorized(err, bearerChallenge(realm, "invalid_token", "the access token is invalid"))
+			}
+
+			ctx = context.WithValue(ctx, claimsKey, claims)
+			ctx = contextWithPrincipal(ctx, &auth.Principal{ID: claims.Subject, Kind: auth.KindToken, Roles: claims.Scopes()})
+			return handler(ctx, w, r)
+		}
+		return h
+	}
+	return m
+}
+
+// RequireScopes fails with a quiet 403 error the requests whose token
+// has not been granted all the scopes. It must follow the JWT middleware,
+// usually as a route specific middleware passed to api.Handle: without it,
+// requests fail with an internal error, since it's a programming error.
+func RequireScopes(scopes ...string) web.Middleware {
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			claims, ok := ContextClaims(ctx)
+			if !ok {
+				return errors.New("missing token claims: is the JWT middleware in place?")
+			}
+
+			for _, s := range scopes {
+				if !claims.HasScope(s) {
+					err := fmt.Errorf("missing scope %q", s)
+					challenge := bearerChallenge("", "insufficient_scope", "the access token lacks the required scopes")
+					challenge += `, scope="` + strings.Join(scopes, " ") + `"`
+					return newRequestError(err, http.StatusForbidden, "insufficient scope",
+						weberr.WithHeaders(http.Header{"WWW-Authenticate": {challenge}}),
+						weberr.WithFields(map[string]interface{}{"sub": claims.Subject}),
+						weberr.WithQuiet(true),
+					)
+				}
+			}
+			return handler(ctx, w, r)
+		}
+		return h
+	}
+	return m
+}
+
+// ContextClaims extracts the claims of the bearer token from the context.
+func ContextClaims(ctx context.Context) (*jwt.Claims, bool) {
+	c, ok := ctx.Value(claimsKey).(*jwt.Claims)
+	return c, ok
+}
+
+// bearerToken extracts the token from the Authorization header.
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	const prefix = "bearer "
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(auth[len(prefix):]), true
+}
+
+// bearerChallenge builds the WWW-Authenticate value of the Bearer scheme (RFC 6750).
+func bearerChallenge(realm, code, desc string) string {
+	var params []string
+	if realm != "" {
+		params = append(params, `realm="`+quoteEscape(realm)+`"`)
+	}
+	if code != "" {
+		params = append(params, `error="`+code+`"`)
+	}
+	if desc != "" {
+		params = append(params, `error_description="`+quoteEscape(desc)+`"`)
+	}
+	if len(params) == 0 {
+		return "Bearer"
+	}
+	return "Bearer " + strings.Join(params, ", ")
+}
+
+// unauthorized builds a quiet 401 error carrying the authentication challenge, if any.
+func unauthorized(err error, challenge string) error {
+	opts := []weberr.Opt{weberr.WithQuiet(true)}
+	if challenge != "" {
+		opts = append(opts, weberr.WithHeaders(http.Header{"WWW-Authenticate": {challenge}}))
+	}
+	return newRequestError(err, http.StatusUnauthorized, "unauthorized", opts...)
+}
+
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+func quoteEscape(s string) string { return quoteEscaper.Replace(s) }
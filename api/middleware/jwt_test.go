@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/polldo/patweb/api/jwt"
+	"github.com/polldo/patweb/api/web"
+	"github.com/polldo/patweb/api/weberr"
+	"github.com/sirupsen/logrus"
+)
+
+func TestJWT(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	key := []byte("secret")
+	v := jwt.NewVerifier(jwt.StaticKeys{"": key})
+	mw := []web.Middleware{Errors(log), JWT(v, "api"), RequireScopes("admin")}
+	h := web.WrapMiddleware(mw, func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return web.Respond(ctx, w, struct{}{}, http.StatusOK)
+	})
+
+	do := func(scope string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if scope != "" {
+			exp := jwt.NewNumericDate(time.Now().Add(time.Minute))
+			token, err := jwt.Sign(jwt.Claims{Subject: "user", ExpiresAt: exp, Scope: scope}, jwt.HS256, "", key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		if err := h(r.Context(), w, r); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	if w := do(""); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != `Bearer realm="api"` {
+		t.Errorf("want 401 with challenge, got %d %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+	if w := do("read"); w.Code != http.StatusForbidden {
+		t.Errorf("want 403 for missing scope, got %d", w.Code)
+	}
+	if w := do("read admin"); w.Code != http.StatusOK {
+		t.Errorf("want 200, got %d", w.Code)
+	}
+
+	// Without the JWT middleware the wiring is broken.
+	err := RequireScopes("admin")(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})(context.Background(), httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if errorStatus(err) != http.StatusInternalServerError || weberr.IsQuiet(err) {
+		t.Errorf("want non quiet 500 without claims, got %v", err)
+	}
+}
@@ -2,46 +2,179 @@ package middleware
 
 import (
 	"context"
+	"io"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/polldo/patweb/api/trace"
 	"github.com/polldo/patweb/api/web"
 	"github.com/sirupsen/logrus"
 	"github.com/zenazn/goji/web/mutil"
 )
 
+// LogField is an optional field of the access log.
+type LogField string
+
+// Optional fields of the access log.
+const (
+	LogUserAgent    LogField = "useragent"
+	LogReferer      LogField = "referer"
+	LogRoute        LogField = "route"
+	LogRequestBytes LogField = "reqbytes"
+	LogPrincipal    LogField = "principal"
+)
+
+// loggerConfig contains the settings of a Logger middleware.
+type loggerConfig struct {
+	singleLine bool
+	format     AccessFormat
+	out        io.Writer
+	fields     []LogField
+	skipPaths  map[string]bool
+	skipStatus map[int]bool
+}
+
+// LoggerOpt defines the type for Logger options.
+type LoggerOpt func(*loggerConfig)
+
+// WithLogSingleLine returns an option that logs only the completion of
+// requests, in a single line with all the fields.
+func WithLogSingleLine() LoggerOpt {
+	return func(c *loggerConfig) {
+		c.singleLine = true
+	}
+}
+
+// WithLogFormat returns an option that writes a single line for each request
+// to out, formatted by format, instead of logging it.
+func WithLogFormat(format AccessFormat, out io.Writer) LoggerOpt {
+	return func(c *loggerConfig) {
+		c.singleLine = true
+		c.format = format
+		c.out = out
+	}
+}
+
+// WithLogFields returns an option that adds optional fields to the log.
+func WithLogFields(fields ...LogField) LoggerOpt {
+	return func(c *loggerConfig) {
+		c.fields = append(c.fields, fields...)
+	}
+}
+
+// WithLogSkipPaths returns an option that excludes the requests
+// with the given paths from the log, like health checks.
+func WithLogSkipPaths(paths ...string) LoggerOpt {
+	return func(c *loggerConfig) {
+		for _, p := range paths {
+			c.skipPaths[p] = true
+		}
+	}
+}
+
+// WithLogSkipStatus returns an option that excludes the completion of the
+// requests with the given status codes from the log.
+func WithLogSkipStatus(codes ...int) LoggerOpt {
+	return func(c *loggerConfig) {
+		for _, code := range codes {
+			c.skipStatus[code] = true
+		}
+	}
+}
+
 // Logger writes some information about the request to the logs.
+// By default the start and the completion of each request are logged,
+// options select a single line, a format and the fields of the log.
 // Influenced by https://github.com/zenazn/goji/blob/master/web/middleware/logger.go
 // and https://github.com/ardanlabs/service/blob/master/business/web/v1/mid/logger.go
-func Logger(log logrus.FieldLogger) web.Middleware {
+func Logger(log logrus.FieldLogger, opts ...LoggerOpt) web.Middleware {
+	cfg := loggerConfig{skipPaths: map[string]bool{}, skipStatus: map[int]bool{}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	var outMu sync.Mutex
+
 	m := func(handler web.Handler) web.Handler {
 		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			if cfg.skipPaths[r.URL.Path] {
+				return handler(ctx, w, r)
+			}
+
+			e := &AccessEntry{
+				RequestID:  ContextRequestID(ctx),
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				RemoteAddr: r.RemoteAddr,
+				r:          r,
+			}
+			if c, ok := ContextClientAddr(ctx); ok {
+				e.RemoteAddr = c.IP
+			}
+
+			// Logs the trace id to correlate logs and traces.
+			if span := trace.SpanFromContext(ctx); span != nil {
+				e.TraceID = span.TraceID()
+			}
+
+			log := log.WithFields(e.baseFields())
+			if !cfg.singleLine {
+				log.Info("started")
+			}
+			e.Time = time.Now().UTC()
 
-			// Logs the request id if it's found in context.
-			if rid := ContextRequestID(ctx); rid != "" {
-				log = log.WithField("req_id", rid)
+			// Count the bytes of the request body actually read.
+			var body *countingBody
+			if r.Body != nil && r.Body != http.NoBody {
+				body = &countingBody{ReadCloser: r.Body}
+				r.Body = body
 			}
-			log = log.WithFields(logrus.Fields{
-				"method":     r.Method,
-				"path":       r.URL.Path,
-				"remoteaddr": r.RemoteAddr,
-			})
-			log.Info("started")
-			startTime := time.Now().UTC()
+			ctx, slot := withPrincipalSlot(ctx)
 
 			// Wrap the ResponseWriter to fetch its status code later on.
 			lw := mutil.WrapWriter(w)
 			err := handler(ctx, lw, r)
 
-			log = log.WithFields(logrus.Fields{
-				"statuscode": lw.Status(),
-				"bytes":      lw.BytesWritten(),
-				"since":      time.Since(startTime).Nanoseconds(),
-			})
-			log.Info("completed")
+			e.Status = lw.Status()
+			e.ResponseBytes = int64(lw.BytesWritten())
+			e.Duration = time.Since(e.Time)
+			if body != nil {
+				e.RequestBytes = body.n
+			}
+			if r.ContentLength > e.RequestBytes {
+				e.RequestBytes = r.ContentLength
+			}
+			e.Route, _ = routeTemplate(r)
+			if p := slot.get(); p != nil {
+				e.Principal = p.ID
+			}
+			if cfg.skipStatus[e.Status] {
+				return err
+			}
+
+			if cfg.format != nil {
+				line := cfg.format(e, cfg.fields)
+				outMu.Lock()
+				_, _ = cfg.out.Write(append(line, '\n'))
+				outMu.Unlock()
+				return err
+			}
+			log.WithFields(e.completionFields(cfg.fields)).Info("completed")
 			return err
 		}
 		return h
 	}
 	return m
 }
+
+// countingBody counts the bytes read from a request body.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
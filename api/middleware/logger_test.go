@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/polldo/patweb/api/auth"
+	"github.com/polldo/patweb/api/web"
+	"github.com/sirupsen/logrus"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(&buf)
+	log.SetFormatter(&logrus.JSONFormatter{})
+
+	h := Logger(log)(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return web.Respond(ctx, w, nil, http.StatusNoContent)
+	})
+	r := httptest.NewRequest(http.MethodGet, "/items", nil)
+	if err := h(r.Context(), httptest.NewRecorder(), r); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"msg":"started"`) || !strings.Contains(lines[1], `"msg":"completed"`) {
+		t.Fatalf("want started and completed lines, got %q", lines)
+	}
+	if !strings.Contains(lines[1], `"statuscode":204`) {
+		t.Errorf("want status in completion, got %s", lines[1])
+	}
+}
+
+func TestLoggerSingleLineFields(t *testing.T) {
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(&buf)
+	log.SetFormatter(&logrus.JSONFormatter{})
+
+	h := Logger(log, WithLogSingleLine(), WithLogFields(LogUserAgent, LogRequestBytes, LogPrincipal))(
+		func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			// Authenticated after the logger, as a route middleware.
+			_ = contextWithPrincipal(ctx, &auth.Principal{ID: "alice"})
+			if _, err := io.ReadAll(r.Body); err != nil {
+				return err
+			}
+			return web.Respond(ctx, w, map[string]string{"ok": "yes"}, http.StatusOK)
+		})
+	r := httptest.NewRequest(http.MethodPost, "/items", strings.NewReader("hello"))
+	r.Header.Set("User-Agent", "tester/1.0")
+	if err := h(r.Context(), httptest.NewRecorder(), r); err != nil {
+		t.Fatal(err)
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("want a single JSON line, got %q: %v", buf.String(), err)
+	}
+	want := map[string]interface{}{
+		"msg":       "completed",
+		"method":    "POST",
+		"useragent": "tester/1.0",
+		"reqbytes":  float64(5),
+		"principal": "alice",
+	}
+	for k, v := range want {
+		if entry[k] != v {
+			t.Errorf("field %s: want %v, got %v", k, v, entry[k])
+		}
+	}
+}
+
+func TestLoggerSkip(t *testing.T) {
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(&buf)
+
+	h := Logger(log, WithLogSkipPaths("/health"), WithLogSkipStatus(http.StatusNotFound))(
+		func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			if r.URL.Path == "/missing" {
+				w.WriteHeader(http.StatusNotFound)
+				return nil
+			}
+			return web.Respond(ctx, w, nil, http.StatusOK)
+		})
+	for _, path := range []string{"/health", "/missing"} {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if err := h(r.Context(), httptest.NewRecorder(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if strings.Contains(buf.String(), "/health") || strings.Contains(buf.String(), "completed") {
+		t.Errorf("want skipped requests, got %q", buf.String())
+	}
+}
+
+func TestLoggerFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		format AccessFormat
+		want   []string
+	}{
+		{"json", FormatAccessJSON, []string{`"statuscode":201`, `"route"`, `"time":"`}},
+		{"logfmt", FormatAccessLogfmt, []string{"time=", " method=PUT", " path=/items/1", ` referer=""`, " statuscode=201"}},
+		{"combined", FormatAccessCombined, []string{`192.0.2.1 - - [`, `] "PUT /items/1?x=1 HTTP/1.1" 201 2 "-" "tester/1.0"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			log := logrus.New()
+			log.SetOutput(io.Discard)
+
+			h := Logger(log, WithLogFormat(tt.format, &out), WithLogFields(LogRoute, LogReferer))(
+				func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+					w.WriteHeader(http.StatusCreated)
+					_, err := w.Write([]byte("ok"))
+					return err
+				})
+			r := httptest.NewRequest(http.MethodPut, "/items/1?x=1", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			r.Header.Set("User-Agent", "tester/1.0")
+			if err := h(r.Context(), httptest.NewRecorder(), r); err != nil {
+				t.Fatal(err)
+			}
+
+			line := out.String()
+			if strings.Count(line, "\n") != 1 {
+				t.Fatalf("want a single line, got %q", line)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(line, w) {
+					t.Errorf("want %q in %q", w, line)
+				}
+			}
+		})
+	}
+}
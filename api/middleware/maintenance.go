@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/polldo/patweb/api/audit"
+	"github.com/polldo/patweb/api/cidr"
+	"github.com/polldo/patweb/api/maintenance"
+	"github.com/polldo/patweb/api/web"
+	"github.com/polldo/patweb/api/weberr"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultMaintenanceMessage is returned to the rejected clients
+// when the maintenance has no message.
+const DefaultMaintenanceMessage = "service under maintenance, retry later"
+
+// maintenanceConfig contains the settings of a Maintenance middleware.
+type maintenanceConfig struct {
+	group     string
+	allow     cidr.Set
+	skipPaths map[string]bool
+	body      func(st maintenance.State) interface{}
+}
+
+// MaintenanceOpt defines the type for Maintenance options.
+type MaintenanceOpt func(*maintenanceConfig)
+
+// WithMaintenanceGroup returns an option that assigns the routes
+// to a group, that can be put under maintenance by itself.
+func WithMaintenanceGroup(group string) MaintenanceOpt {
+	return func(c *maintenanceConfig) {
+		c.group = group
+	}
+}
+
+// WithMaintenanceAllow returns an option that lets the clients of the
+// given networks through, as resolved by RealIP if in use.
+func WithMaintenanceAllow(nets cidr.Set) MaintenanceOpt {
+	return func(c *maintenanceConfig) {
+		c.allow = append(c.allow, nets...)
+	}
+}
+
+// WithMaintenanceSkipPaths returns an option that lets the requests
+// with the given paths through, like health checks.
+func WithMaintenanceSkipPaths(paths ...string) MaintenanceOpt {
+	return func(c *maintenanceConfig) {
+		for _, p := range paths {
+			c.skipPaths[p] = true
+		}
+	}
+}
+
+// WithMaintenanceBody returns an option that sets the body of the
+// responses to the rejected clients.
+func WithMaintenanceBody(body func(st maintenance.State) interface{}) MaintenanceOpt {
+	return func(c *maintenanceConfig) {
+		c.body = body
+	}
+}
+
+// Maintenance rejects the requests while sw puts their route group under
+// maintenance, with a quiet 503 error carrying the Retry-After header.
+// Allowed clients and skipped paths are always let through.
+// It can be used globally or passed to api.Handle for route groups.
+func Maintenance(sw *maintenance.Switch, opts ...MaintenanceOpt) web.Middleware {
+	cfg := maintenanceConfig{skipPaths: map[string]bool{}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			st := sw.State()
+			if !st.Covers(cfg.group) || cfg.skipPaths[r.URL.Path] ||
+				cfg.allow.Contains(net.ParseIP(ClientIP(ctx, r))) {
+				return handler(ctx, w, r)
+			}
+
+			err := errors.New("service under maintenance")
+			opts := []weberr.Opt{
+				weberr.WithFields(map[string]interface{}{"maintenance_group": cfg.group}),
+				weberr.WithQuiet(true),
+			}
+			if st.RetryAfter > 0 {
+				opts = append(opts, weberr.WithHeaders(http.Header{"Retry-After": {ceilSeconds(st.RetryAfter)}}))
+			}
+			if cfg.body != nil {
+				opts = append(opts, weberr.WithResponse(cfg.body(st), http.StatusServiceUnavailable))
+				return weberr.Wrap(err, opts...)
+			}
+			msg := st.Message
+			if msg == "" {
+				msg = DefaultMaintenanceMessage
+			}
+			return newRequestError(err, http.StatusServiceUnavailable, msg, opts...)
+		}
+		return h
+	}
+	return m
+}
+
+// maintenanceState is the representation of the maintenance state
+// used by MaintenanceAdmin.
+type maintenanceState struct {
+	Enabled    bool      `json:"enabled"`
+	Groups     []string  `json:"groups,omitempty"`
+	Message    string    `json:"message,omitempty"`
+	RetryAfter int       `json:"retry_after,omitempty"`
+	Since      time.Time `json:"since,omitempty"`
+}
+
+// MaintenanceAdmin handles the administration of the maintenance.
+// GET returns the current state, PUT sets it from a JSON body like
+// {"enabled": true, "groups": ["orders"], "message": "...", "retry_after": 600},
+// with retry_after in seconds, and DELETE ends the maintenance.
+// Changes are logged with the request ID and the authenticated subject, and
+// recorded as maintenance.enable or maintenance.disable events for Audit.
+// It should be protected by route middlewares, like IPFilter or RequireRoles,
+// and it must not be under maintenance itself.
+func MaintenanceAdmin(sw *maintenance.Switch, log logrus.FieldLogger) web.Handler {
+	h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		var in maintenanceState
+		switch r.Method {
+		case http.MethodPut:
+			if err := web.Decode(r, &in); err != nil {
+				err = fmt.Errorf("cannot decode maintenance state: %w", err)
+				return newRequestError(err, http.StatusBadRequest, "invalid maintenance state", weberr.WithQuiet(true))
+			}
+		case http.MethodDelete:
+		default:
+			return web.Respond(ctx, w, newMaintenanceState(sw.State()), http.StatusOK)
+		}
+
+		before := newMaintenanceState(sw.State())
+		action := "maintenance.disable"
+		if in.Enabled {
+			action = "maintenance.enable"
+			sw.Enable(in.Message, time.Duration(in.RetryAfter)*time.Second, in.Groups...)
+		} else {
+			sw.Disable()
+		}
+		after := newMaintenanceState(sw.State())
+
+		log.WithFields(logrus.Fields{
+			"req_id":      ContextRequestID(ctx),
+			"actor":       ContextSubject(ctx),
+			"client_ip":   ClientIP(ctx, r),
+			"enabled":     after.Enabled,
+			"groups":      strings.Join(after.Groups, ","),
+			"retry_after": after.RetryAfter,
+		}).Info("maintenance changed by admin")
+		web.Audit(ctx, audit.Event{Action: action, Resource: "maintenance", Before: before, After: after})
+
+		return web.Respond(ctx, w, after, http.StatusOK)
+	}
+	return h
+}
+
+// newMaintenanceState converts st to its representation.
+func newMaintenanceState(st maintenance.State) maintenanceState {
+	return maintenanceState{
+		Enabled:    st.Enabled,
+		Groups:     st.Groups,
+		Message:    st.Message,
+		RetryAfter: int(st.RetryAfter / time.Second),
+		Since:      st.Since,
+	}
+}
@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/polldo/patweb/api/audit"
+	"github.com/polldo/patweb/api/auth"
+	"github.com/polldo/patweb/api/cidr"
+	"github.com/polldo/patweb/api/maintenance"
+	"github.com/polldo/patweb/api/web"
+	"github.com/polldo/patweb/api/weberr"
+	"github.com/sirupsen/logrus"
+)
+
+func TestMaintenance(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	sw := maintenance.NewSwitch(log)
+
+	ok := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	orders := Maintenance(sw, WithMaintenanceGroup("orders"),
+		WithMaintenanceAllow(cidr.MustParse("10.0.0.0/8")),
+		WithMaintenanceSkipPaths("/health"),
+	)(ok)
+	users := Maintenance(sw, WithMaintenanceGroup("users"))(ok)
+
+	serve := func(h func(context.Context, http.ResponseWriter, *http.Request) error, path, remote string) error {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = remote
+		return h(r.Context(), httptest.NewRecorder(), r)
+	}
+
+	sw.Enable("", 90*time.Second, "orders")
+
+	err := serve(orders, "/orders", "192.0.2.1:1234")
+	if errorStatus(err) != http.StatusServiceUnavailable || !weberr.IsQuiet(err) {
+		t.Fatalf("want quiet 503, got %v", err)
+	}
+	if h, _ := weberr.Headers(err); h.Get("Retry-After") != "90" {
+		t.Errorf("want Retry-After, got %v", h)
+	}
+	body, _, _ := weberr.Response(err)
+	if resp, ok := body.(*web.ErrorResponse); !ok || resp.Error != DefaultMaintenanceMessage {
+		t.Errorf("want default message, got %+v", body)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		h      func(context.Context, http.ResponseWriter, *http.Request) error
+		path   string
+		remote string
+	}{
+		{"allowed client", orders, "/orders", "10.1.1.1:1234"},
+		{"health check", orders, "/health", "192.0.2.1:1234"},
+		{"other group", users, "/users", "192.0.2.1:1234"},
+	} {
+		if err := serve(tt.h, tt.path, tt.remote); err != nil {
+			t.Errorf("%s: want request through, got %v", tt.name, err)
+		}
+	}
+
+	sw.Toggle()
+	if err := serve(orders, "/orders", "192.0.2.1:1234"); err != nil {
+		t.Fatalf("want maintenance toggled off, got %v", err)
+	}
+	sw.Toggle()
+	if st := sw.State(); !st.Enabled || st.RetryAfter != 90*time.Second || !st.Covers("orders") || st.Covers("users") {
+		t.Fatalf("want previous settings after toggle, got %+v", st)
+	}
+}
+
+func TestMaintenanceAdmin(t *testing.T) {
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(&buf)
+	swLog := logrus.New()
+	swLog.SetOutput(io.Discard)
+	sw := maintenance.NewSwitch(swLog)
+	sink := audit.NewMemorySink()
+	store := auth.NewMemoryStore()
+	if err := store.AddAPIKey("alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	h := web.WrapMiddleware([]web.Middleware{RequestID(), Audit(sink, log), APIKey(store, "")}, MaintenanceAdmin(sw, log))
+
+	serve := func(method, body string) error {
+		r := httptest.NewRequest(method, "/admin/maintenance", strings.NewReader(body))
+		r.Header.Set(APIKeyHeader, "secret")
+		return h(r.Context(), httptest.NewRecorder(), r)
+	}
+
+	if err := serve(http.MethodPut, `{"enabled":true,"groups":["orders"]}`); err != nil || !sw.State().Enabled {
+		t.Fatalf("want maintenance enabled, got %v", err)
+	}
+	if err := serve(http.MethodDelete, ""); err != nil || sw.State().Enabled {
+		t.Fatalf("want maintenance disabled, got %v", err)
+	}
+	if err := serve(http.MethodPut, `{"enabled":`); errorStatus(err) != http.StatusBadRequest || !weberr.IsQuiet(err) {
+		t.Errorf("want quiet 400 for a malformed state, got %v", err)
+	}
+
+	events := sink.Events()
+	if len(events) != 3 {
+		t.Fatalf("want 3 events, got %d", len(events))
+	}
+	for i, action := range []string{"maintenance.enable", "maintenance.disable"} {
+		if e := events[i]; e.Action != action || e.Actor != "alice" || e.RequestID == "" {
+			t.Errorf("event %d: want %s by alice with request id, got %+v", i, action, e)
+		}
+	}
+	if n := strings.Count(buf.String(), "maintenance changed by admin"); n != 2 || !strings.Contains(buf.String(), "actor=alice") {
+		t.Errorf("want changes logged with the actor, got %s", buf.String())
+	}
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/polldo/patweb/api/metrics"
+	"github.com/polldo/patweb/api/web"
+	"github.com/polldo/patweb/api/weberr"
+	"github.com/zenazn/goji/web/mutil"
+)
+
+// unmatchedRoute is the route label of requests not served through a route,
+// to keep the cardinality of the metrics bounded.
+const unmatchedRoute = "unmatched"
+
+// noErrorCode is the code label of errors without the 'Code' behavior of weberr.
+const noErrorCode = "none"
+
+// Metrics records, for each route template, the following metrics in the registry:
+//   - http_requests_total: counter of the requests, by method, route and status.
+//   - http_request_duration_seconds: histogram of the latencies, by method, route and status.
+//   - http_requests_in_flight: gauge of the requests being served, by method and route.
+//   - http_errors_total: counter of the errors returned by handlers, by route,
+//     response status, weberr code ('none' for errors without one) and quietness.
+//
+// It should be placed right after the Errors middleware, so that it can
+// observe the errors before they are handled. The collectors are registered
+// when the middleware is constructed, which panics if they are already registered.
+func Metrics(reg metrics.Registry) web.Middleware {
+	requests := metrics.NewCounterVec("http_requests_total",
+		"Total number of HTTP requests.", "method", "route", "status")
+	duration := metrics.NewHistogramVec("http_request_duration_seconds",
+		"Latency of HTTP requests.", nil, "method", "route", "status")
+	inflight := metrics.NewGaugeVec("http_requests_in_flight",
+		"Number of HTTP requests being served.", "method", "route")
+	errs := metrics.NewCounterVec("http_errors_total",
+		"Total number of errors returned by handlers.", "route", "status", "code", "quiet")
+	metrics.MustRegister(reg, requests, duration, inflight, errs)
+
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			route, ok := routeTemplate(r)
+			if !ok {
+				route = unmatchedRoute
+			}
+
+			inflight.Inc(r.Method, route)
+			defer inflight.Dec(r.Method, route)
+			start := time.Now()
+
+			lw := mutil.WrapWriter(w)
+			err := handler(ctx, lw, r)
+
+			// The response of errors is written later on by the Errors middleware.
+			status := lw.Status()
+			if err != nil {
+				status = errorStatus(err)
+				code, ok := weberr.Code(err)
+				if !ok {
+					code = noErrorCode
+				}
+				quiet := strconv.FormatBool(weberr.IsQuiet(err))
+				errs.Inc(route, strconv.Itoa(status), code, quiet)
+			}
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			code := strconv.Itoa(status)
+			requests.Inc(r.Method, route, code)
+			duration.Observe(time.Since(start).Seconds(), r.Method, route, code)
+			return err
+		}
+		return h
+	}
+	return m
+}
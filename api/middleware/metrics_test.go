@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/polldo/patweb/api/metrics"
+	"github.com/polldo/patweb/api/web"
+	"github.com/polldo/patweb/api/weberr"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := metrics.NewRegistry()
+	h := Metrics(reg)(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		if mux.Vars(r)["id"] == "0" {
+			return weberr.Wrap(errors.New("not found"),
+				weberr.WithResponse(nil, http.StatusNotFound),
+				weberr.WithCode("user_not_found"),
+				weberr.WithQuiet(true),
+			)
+		}
+		if mux.Vars(r)["id"] == "2" {
+			return errors.New("database down")
+		}
+		return web.Respond(ctx, w, struct{}{}, http.StatusOK)
+	})
+
+	router := mux.NewRouter()
+	router.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_ = h(r.Context(), w, r)
+	})
+	for _, path := range []string{"/users/0", "/users/1", "/users/2"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	w := httptest.NewRecorder()
+	metrics.Handler(reg).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != metrics.ContentType {
+		t.Errorf("want content type %q, got %q", metrics.ContentType, ct)
+	}
+
+	body := w.Body.String()
+	for _, want := range []string{
+		"# TYPE http_requests_total counter\n",
+		`http_requests_total{method="GET",route="/users/{id}",status="200"} 1` + "\n",
+		`http_requests_total{method="GET",route="/users/{id}",status="404"} 1` + "\n",
+		`http_errors_total{route="/users/{id}",status="404",code="user_not_found",quiet="true"} 1` + "\n",
+		`http_errors_total{route="/users/{id}",status="500",code="none",quiet="false"} 1` + "\n",
+		`http_requests_in_flight{method="GET",route="/users/{id}"} 0` + "\n",
+		`http_request_duration_seconds_count{method="GET",route="/users/{id}",status="200"} 1` + "\n",
+		`http_request_duration_seconds_bucket{method="GET",route="/users/{id}",status="200",le="+Inf"} 1` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+}
@@ -2,27 +2,106 @@ package middleware
 
 import (
 	"context"
-	"fmt"
 	"net/http"
-	"runtime/debug"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/polldo/patweb/api/web"
 )
 
-// Panics recovers from panics and converts the panic to an error so it is
-// handled in Errors.
-func Panics() web.Middleware {
+// PanicError is the error a panic is converted to by the Panics middleware.
+// It's the same error logged by web.Background for panics of goroutines.
+type PanicError = web.PanicError
+
+// StackFrame is a frame of the stack of a panicking goroutine.
+type StackFrame = web.StackFrame
+
+// panicsConfig contains the settings of a Panics middleware.
+type panicsConfig struct {
+	report      func(ctx context.Context, err *PanicError)
+	crashMax    int
+	crashWindow time.Duration
+	crash       func()
+}
+
+// PanicOpt defines the type for Panics options.
+type PanicOpt func(*panicsConfig)
+
+// WithPanicReporter returns an option that sets a function called with each
+// recovered panic, for instance to notify a crash reporting service.
+func WithPanicReporter(report func(ctx context.Context, err *PanicError)) PanicOpt {
+	return func(c *panicsConfig) {
+		c.report = report
+	}
+}
+
+// WithPanicCrash returns an option that calls crash when max panics are
+// recovered within window, since repeated panics may signal a corrupted state
+// that a restart would fix. A nil crash exits the process with status 2.
+func WithPanicCrash(max int, window time.Duration, crash func()) PanicOpt {
+	return func(c *panicsConfig) {
+		c.crashMax = max
+		c.crashWindow = window
+		c.crash = crash
+	}
+}
+
+// Panics recovers from panics and converts the panic to a *PanicError so it is
+// handled in Errors. Panics with http.ErrAbortHandler are propagated, since
+// they are the way to abort a response on purpose.
+func Panics(opts ...PanicOpt) web.Middleware {
+	var cfg panicsConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.crash == nil {
+		cfg.crash = func() { os.Exit(2) }
+	}
+
+	// Times of the recent panics, used to decide whether to crash.
+	var mu sync.Mutex
+	var recent []time.Time
+	tooMany := func() bool {
+		if cfg.crashMax <= 0 {
+			return false
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		now := time.Now()
+		i := 0
+		for i < len(recent) && now.Sub(recent[i]) > cfg.crashWindow {
+			i++
+		}
+		recent = append(recent[i:], now)
+		return len(recent) >= cfg.crashMax
+	}
+
 	m := func(handler web.Handler) web.Handler {
 		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
 
 			// Defer a function to recover from a panic and set the err return
 			// variable after the fact.
 			defer func() {
-				if rec := recover(); rec != nil {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
 
-					// Stack trace will be provided.
-					trace := debug.Stack()
-					err = fmt.Errorf("PANIC [%v] TRACE[%s]", rec, string(trace))
+				// Panics propagated by Timeout already carry their stack.
+				perr, ok := rec.(*PanicError)
+				if !ok {
+					perr = web.NewPanicError(rec)
+				}
+				err = perr
+				if cfg.report != nil {
+					cfg.report(ctx, perr)
+				}
+				if tooMany() {
+					cfg.crash()
 				}
 			}()
 
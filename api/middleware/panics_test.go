@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/polldo/patweb/api/web"
+	"github.com/polldo/patweb/api/weberr"
+)
+
+func TestPanics(t *testing.T) {
+	var reported *PanicError
+	crashes := 0
+	mw := Panics(
+		WithPanicReporter(func(ctx context.Context, err *PanicError) { reported = err }),
+		WithPanicCrash(2, time.Minute, func() { crashes++ }),
+	)
+	h := mw(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		panic("secret state")
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	err := h(r.Context(), httptest.NewRecorder(), r)
+
+	var perr *PanicError
+	if !errors.As(err, &perr) || perr != reported {
+		t.Fatalf("want reported *PanicError, got %v", err)
+	}
+	if perr.Goroutine == 0 || len(perr.Stack) == 0 {
+		t.Fatalf("want goroutine and stack, got %d and %d frames", perr.Goroutine, len(perr.Stack))
+	}
+	if !strings.HasSuffix(perr.Stack[0].File, "panics_test.go") {
+		t.Errorf("stack should start at the panicking function, got %s", perr.Stack[0])
+	}
+
+	fields, ok := weberr.Fields(err)
+	if !ok || fields["panic"] != "secret state" {
+		t.Errorf("want panic value in fields, got %v", fields)
+	}
+	resp, status := perr.Response()
+	if status != http.StatusInternalServerError || resp.(*web.ErrorResponse).Error == "secret state" {
+		t.Errorf("response must be a generic 500, got %d %+v", status, resp)
+	}
+
+	if crashes != 0 {
+		t.Fatalf("crashed after a single panic")
+	}
+	_ = h(r.Context(), httptest.NewRecorder(), r)
+	if crashes != 1 {
+		t.Errorf("want crash after repeated panics")
+	}
+}
+
+func TestPanicsAbortHandler(t *testing.T) {
+	h := Panics()(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		panic(http.ErrAbortHandler)
+	})
+	defer func() {
+		if rec := recover(); rec != http.ErrAbortHandler {
+			t.Errorf("want http.ErrAbortHandler re-panicked, got %v", rec)
+		}
+	}()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	_ = h(r.Context(), httptest.NewRecorder(), r)
+	t.Error("panic was swallowed")
+}
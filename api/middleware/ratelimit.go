@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/polldo/patweb/api/ratelimit"
+	"github.com/polldo/patweb/api/web"
+	"github.com/polldo/patweb/api/weberr"
+)
+
+// RateLimitKey extracts from the request the key identifying a client.
+// It returns false if the client cannot be identified, in which case
+// the request is not limited.
+type RateLimitKey func(ctx context.Context, r *http.Request) (key string, ok bool)
+
+// KeyByIP identifies clients by their IP address,
+// as resolved by RealIP if in use.
+func KeyByIP() RateLimitKey {
+	return func(ctx context.Context, r *http.Request) (string, bool) {
+		ip := ClientIP(ctx, r)
+		return "ip:" + ip, ip != ""
+	}
+}
+
+// KeyByHeader identifies clients by the value of a header, like an API key.
+// The value is hashed, so that secrets are neither stored nor logged.
+func KeyByHeader(name string) RateLimitKey {
+	return func(ctx context.Context, r *http.Request) (string, bool) {
+		v := r.Header.Get(name)
+		if v == "" {
+			return "", false
+		}
+		sum := sha256.Sum256([]byte(v))
+		return "header:" + hex.EncodeToString(sum[:16]), true
+	}
+}
+
+// KeyBySubject identifies clients by the authenticated subject
+// returned by the given function, usually provided by the auth middleware.
+func KeyBySubject(subject func(ctx context.Context) string) RateLimitKey {
+	return func(ctx context.Context, r *http.Request) (string, bool) {
+		s := subject(ctx)
+		return "sub:" + s, s != ""
+	}
+}
+
+// RateLimit limits the requests of each client identified by key.
+// The state of the limit is reported through the X-RateLimit-Limit,
+// X-RateLimit-Remaining and X-RateLimit-Reset headers.
+// Requests over the limit fail with a quiet 429 error carrying the
+// Retry-After header, to be handled by the Errors middleware.
+func RateLimit(limiter *ratelimit.Limiter, key RateLimitKey) web.Middleware {
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			k, ok := key(ctx, r)
+			if !ok {
+				return handler(ctx, w, r)
+			}
+
+			res, err := limiter.Allow(ctx, k)
+			if err != nil {
+				return fmt.Errorf("cannot check rate limit: %w", err)
+			}
+
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("X-RateLimit-Reset", ceilSeconds(res.ResetAfter))
+
+			if !res.Allowed {
+				err := errors.New("rate limit exceeded")
+				return newRequestError(err, http.StatusTooManyRequests, "too many requests, retry later",
+					weberr.WithHeaders(http.Header{"Retry-After": {ceilSeconds(res.RetryAfter)}}),
+					weberr.WithFields(map[string]interface{}{"ratelimit_key": k}),
+					weberr.WithQuiet(true),
+				)
+			}
+
+			return handler(ctx, w, r)
+		}
+		return h
+	}
+	return m
+}
+
+// ceilSeconds formats a duration as a number of seconds, rounded up.
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/polldo/patweb/api/ratelimit"
+	"github.com/polldo/patweb/api/web"
+	"github.com/sirupsen/logrus"
+)
+
+func TestRateLimit(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	limiter := ratelimit.NewTokenBucket(ratelimit.NewMemoryStore(1), ratelimit.Limit{Requests: 1, Period: time.Minute})
+	mw := []web.Middleware{Errors(log), RateLimit(limiter, KeyByHeader("X-Api-Key"))}
+	h := web.WrapMiddleware(mw, func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return web.Respond(ctx, w, struct{}{}, http.StatusOK)
+	})
+
+	do := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Api-Key", "key")
+		w := httptest.NewRecorder()
+		if err := h(r.Context(), w, r); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return w
+	}
+
+	if w := do(); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("want first request allowed, got %d %v", w.Code, w.Header())
+	}
+	w := do()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("want status %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if ra := w.Header().Get("Retry-After"); ra != "60" {
+		t.Errorf("want Retry-After 60, got %q", ra)
+	}
+}
+
+func TestKeyByHeaderHashed(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Api-Key", "s3cret-key")
+	k, ok := KeyByHeader("X-Api-Key")(r.Context(), r)
+	if !ok || strings.Contains(k, "s3cret") || len(k) != len("header:")+32 {
+		t.Errorf("want hashed key, got %q", k)
+	}
+	r.Header.Del("X-Api-Key")
+	if _, ok := KeyByHeader("X-Api-Key")(r.Context(), r); ok {
+		t.Error("want no key without the header")
+	}
+}
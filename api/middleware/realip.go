@@ -0,0 +1,257 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/polldo/patweb/api/cidr"
+	"github.com/polldo/patweb/api/web"
+)
+
+// Headers carrying the addresses of clients behind proxies.
+const (
+	ForwardedHeader       = "Forwarded"
+	XForwardedForHeader   = "X-Forwarded-For"
+	XRealIPHeader         = "X-Real-Ip"
+	xForwardedProtoHeader = "X-Forwarded-Proto"
+	xForwardedHostHeader  = "X-Forwarded-Host"
+)
+
+// ClientAddr describes the client of a request, as seen by the proxy
+// in front of the trusted ones.
+type ClientAddr struct {
+	// IP is the address of the client.
+	IP string
+
+	// Scheme and Host are the ones originally requested by the client.
+	Scheme string
+	Host   string
+}
+
+// clientAddrKeyCtx is the private type used to store the client address in the context.
+type clientAddrKeyCtx int
+
+// clientAddrKey is the context key used to store the client address.
+const clientAddrKey clientAddrKeyCtx = 1
+
+// ContextClientAddr extracts the client address resolved by RealIP from the context.
+func ContextClientAddr(ctx context.Context) (ClientAddr, bool) {
+	c, ok := ctx.Value(clientAddrKey).(ClientAddr)
+	return c, ok
+}
+
+// ClientIP returns the client address resolved by RealIP, or the
+// host of the remote address of r if RealIP is not in use.
+func ClientIP(ctx context.Context, r *http.Request) string {
+	if c, ok := ContextClientAddr(ctx); ok {
+		return c.IP
+	}
+	return remoteHost(r.RemoteAddr)
+}
+
+// remoteHost strips the port from a remote address.
+func remoteHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// realIPConfig contains the settings of a RealIP middleware.
+type realIPConfig struct {
+	header string
+}
+
+// RealIPOpt defines the type for RealIP options.
+type RealIPOpt func(*realIPConfig)
+
+// WithRealIPHeader returns an option that sets the header read by RealIP,
+// among ForwardedHeader, XForwardedForHeader and XRealIPHeader, instead of
+// XForwardedForHeader. It must be the header the trusted proxies set or
+// append to, since clients can send the others through them.
+// It panics with other headers, since it's a programming error.
+func WithRealIPHeader(header string) RealIPOpt {
+	header = http.CanonicalHeaderKey(header)
+	switch header {
+	case ForwardedHeader, XForwardedForHeader, XRealIPHeader:
+	default:
+		panic("realip: unsupported header " + header)
+	}
+	return func(c *realIPConfig) {
+		c.header = header
+	}
+}
+
+// RealIP resolves the address of the client of requests received through
+// the trusted proxies, retrieve it using ContextClientAddr or ClientIP.
+//
+// Only the X-Forwarded-For header is read, or the one set with
+// WithRealIPHeader, and only if the request comes from a trusted proxy:
+// there's no fallback to other headers. The list of forwarding addresses
+// is walked from the nearest hop, skipping the trusted proxies: the first
+// untrusted address is the client, since the addresses before it could
+// have been forged. The original scheme and host are taken from the
+// Forwarded element of the client or, with the other headers, from
+// X-Forwarded-Proto and X-Forwarded-Host as set by the nearest proxy.
+// Without valid forwarding information, the remote address of the request
+// is used.
+func RealIP(trusted cidr.Set, opts ...RealIPOpt) web.Middleware {
+	cfg := realIPConfig{header: XForwardedForHeader}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			ctx = context.WithValue(ctx, clientAddrKey, cfg.resolve(trusted, r))
+			return handler(ctx, w, r)
+		}
+		return h
+	}
+	return m
+}
+
+// resolve finds the client address of r.
+func (c *realIPConfig) resolve(trusted cidr.Set, r *http.Request) ClientAddr {
+	addr := ClientAddr{IP: remoteHost(r.RemoteAddr), Scheme: "http", Host: r.Host}
+	if r.TLS != nil {
+		addr.Scheme = "https"
+	}
+	if ip := net.ParseIP(addr.IP); ip != nil {
+		addr.IP = ip.String()
+	}
+	if !trusted.Contains(net.ParseIP(addr.IP)) {
+		return addr
+	}
+
+	values := r.Header.Values(c.header)
+	if len(values) == 0 {
+		return addr
+	}
+
+	var hops []forwardedHop
+	switch c.header {
+	case ForwardedHeader:
+		hops = parseForwarded(values)
+	case XForwardedForHeader:
+		for _, v := range values {
+			for _, f := range strings.Split(v, ",") {
+				hops = append(hops, forwardedHop{ip: parseNode(f)})
+			}
+		}
+	case XRealIPHeader:
+		hops = []forwardedHop{{ip: parseNode(values[len(values)-1])}}
+	}
+
+	hop, ok := clientHop(trusted, hops)
+	if !ok {
+		return addr
+	}
+	addr.IP = hop.ip.String()
+	if c.header == ForwardedHeader {
+		if hop.proto != "" {
+			addr.Scheme = strings.ToLower(hop.proto)
+		}
+		if hop.host != "" {
+			addr.Host = hop.host
+		}
+		return addr
+	}
+	if v := lastValue(r.Header.Values(xForwardedProtoHeader)); v != "" {
+		addr.Scheme = strings.ToLower(v)
+	}
+	if v := lastValue(r.Header.Values(xForwardedHostHeader)); v != "" {
+		addr.Host = v
+	}
+	return addr
+}
+
+// forwardedHop is an address in a list of forwarding proxies.
+type forwardedHop struct {
+	ip    net.IP
+	proto string
+	host  string
+}
+
+// clientHop walks hops from the nearest one and returns the first untrusted
+// address, or the farthest one if all are trusted. It fails if an address
+// that must be read is invalid, like obfuscated identifiers.
+func clientHop(trusted cidr.Set, hops []forwardedHop) (forwardedHop, bool) {
+	for i := len(hops) - 1; i >= 0; i-- {
+		if hops[i].ip == nil {
+			return forwardedHop{}, false
+		}
+		if i == 0 || !trusted.Contains(hops[i].ip) {
+			return hops[i], true
+		}
+	}
+	return forwardedHop{}, false
+}
+
+// parseForwarded parses the elements of Forwarded headers, as defined by RFC 7239.
+func parseForwarded(values []string) []forwardedHop {
+	var hops []forwardedHop
+	for _, v := range values {
+		for _, elem := range splitQuoted(v, ',') {
+			var hop forwardedHop
+			for _, pair := range splitQuoted(elem, ';') {
+				k, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				val = strings.Trim(val, `"`)
+				switch strings.ToLower(k) {
+				case "for":
+					hop.ip = parseNode(val)
+				case "proto":
+					hop.proto = val
+				case "host":
+					hop.host = val
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// splitQuoted splits s around sep, ignoring the separators in quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseNode parses an address with an optional port, like '192.0.2.1:80'
+// or '[2001:db8::1]:80'. It returns nil for invalid addresses.
+func parseNode(s string) net.IP {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	return net.ParseIP(strings.Trim(s, "[]"))
+}
+
+// lastValue returns the last element of comma separated header values.
+func lastValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	v := values[len(values)-1]
+	if i := strings.LastIndexByte(v, ','); i >= 0 {
+		v = v[i+1:]
+	}
+	return strings.TrimSpace(v)
+}
@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/polldo/patweb/api/cidr"
+)
+
+func TestRealIP(t *testing.T) {
+	trusted := cidr.MustParse("10.0.0.0/8", "fd00::/8")
+
+	tests := []struct {
+		name   string
+		remote string
+		read   string
+		header http.Header
+		tls    bool
+		want   ClientAddr
+	}{
+		{
+			name:   "untrusted remote",
+			remote: "203.0.113.9:1234",
+			header: http.Header{"X-Forwarded-For": {"198.51.100.1"}},
+			want:   ClientAddr{IP: "203.0.113.9", Scheme: "http", Host: "example.com"},
+		},
+		{
+			name:   "forwarded for",
+			remote: "10.0.0.1:1234",
+			header: http.Header{
+				"X-Forwarded-For":   {"1.1.1.1, 198.51.100.1", "10.0.0.2"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"api.example.com"},
+			},
+			want: ClientAddr{IP: "198.51.100.1", Scheme: "https", Host: "api.example.com"},
+		},
+		{
+			name:   "all trusted",
+			remote: "10.0.0.1:1234",
+			header: http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}},
+			want:   ClientAddr{IP: "10.0.0.3", Scheme: "http", Host: "example.com"},
+		},
+		{
+			name:   "forwarded",
+			remote: "[fd00::1]:1234",
+			read:   ForwardedHeader,
+			header: http.Header{
+				"Forwarded":       {`for="[2001:db8::7]:4711";proto=https;host="shop.example.com", for=10.0.0.2`},
+				"X-Forwarded-For": {"198.51.100.1"},
+			},
+			want: ClientAddr{IP: "2001:db8::7", Scheme: "https", Host: "shop.example.com"},
+		},
+		{
+			name:   "obfuscated",
+			remote: "10.0.0.1:1234",
+			read:   ForwardedHeader,
+			header: http.Header{"Forwarded": {"for=_hidden"}},
+			tls:    true,
+			want:   ClientAddr{IP: "10.0.0.1", Scheme: "https", Host: "example.com"},
+		},
+		{
+			name:   "real ip",
+			remote: "10.0.0.1:1234",
+			read:   XRealIPHeader,
+			header: http.Header{"X-Real-Ip": {"198.51.100.2"}},
+			want:   ClientAddr{IP: "198.51.100.2", Scheme: "http", Host: "example.com"},
+		},
+		{
+			name:   "forwarded spoofed through forwarded for proxy",
+			remote: "10.0.0.1:1234",
+			header: http.Header{
+				"Forwarded":       {"for=192.0.2.66;proto=https;host=admin.example.com"},
+				"X-Forwarded-For": {"198.51.100.1"},
+			},
+			want: ClientAddr{IP: "198.51.100.1", Scheme: "http", Host: "example.com"},
+		},
+		{
+			name:   "no fallback",
+			remote: "10.0.0.1:1234",
+			read:   ForwardedHeader,
+			header: http.Header{"X-Forwarded-For": {"198.51.100.1"}, "X-Real-Ip": {"198.51.100.2"}},
+			want:   ClientAddr{IP: "10.0.0.1", Scheme: "http", Host: "example.com"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []RealIPOpt
+			if tt.read != "" {
+				opts = append(opts, WithRealIPHeader(tt.read))
+			}
+			var got ClientAddr
+			h := RealIP(trusted, opts...)(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				got, _ = ContextClientAddr(ctx)
+				if ip := ClientIP(ctx, r); ip != got.IP {
+					t.Errorf("want client ip %q, got %q", got.IP, ip)
+				}
+				return nil
+			})
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			r.RemoteAddr = tt.remote
+			r.Header = tt.header
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			if err := h(r.Context(), httptest.NewRecorder(), r); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("want %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestKeyByIPRealIP(t *testing.T) {
+	var key string
+	h := RealIP(cidr.MustParse("10.0.0.0/8"))(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		key, _ = KeyByIP()(ctx, r)
+		return nil
+	})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if err := h(r.Context(), httptest.NewRecorder(), r); err != nil {
+		t.Fatal(err)
+	}
+	if key != "ip:198.51.100.1" {
+		t.Errorf("want key of the client, got %q", key)
+	}
+}
@@ -1,16 +1,10 @@
 package middleware
 
 import (
-	"crypto/rand"
-	"encoding/base64"
-	"fmt"
+	"context"
 	"net/http"
-	"strings"
-	"sync/atomic"
 
 	"github.com/polldo/patweb/api/web"
-
-	"context"
 )
 
 const (
@@ -28,41 +22,113 @@ type reqIDKeyCtx int
 // reqIDKey is the context key used to store the request ID value.
 const reqIDKey reqIDKeyCtx = 1
 
-// Counter used to create new request ids.
-var reqID int64
-
-// Common prefix to all newly created request ids for this process.
-var reqPrefix string
-
-// Initialize common prefix on process startup.
-// Algorithm taken from https://github.com/zenazn/goji/blob/master/web/middleware/request_id.go#L44-L50 .
-func init() {
-	var buf [12]byte
-	var b64 string
-	for len(b64) < 10 {
-		_, _ = rand.Read(buf[:])
-		b64 = base64.StdEncoding.EncodeToString(buf[:])
-		b64 = strings.NewReplacer("+", "", "/", "").Replace(b64)
+// RequestIDValidator reports whether a request ID received from the client
+// can be used as it is. Invalid IDs are replaced by a newly generated one.
+type RequestIDValidator func(id string) bool
+
+// ValidRequestID returns a validator accepting IDs not longer than maxLen
+// and made only of runes accepted by allowed.
+// A negative maxLen disables the length check, a nil allowed accepts any rune.
+func ValidRequestID(maxLen int, allowed func(r rune) bool) RequestIDValidator {
+	return func(id string) bool {
+		if maxLen >= 0 && len(id) > maxLen {
+			return false
+		}
+		if allowed == nil {
+			return true
+		}
+		for _, r := range id {
+			if !allowed(r) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// IsVisibleASCII reports whether the rune is a printable ASCII character
+// other than space. It is the default charset for incoming request IDs
+// and keeps them safe to be logged and echoed back in headers.
+func IsVisibleASCII(r rune) bool {
+	return r > ' ' && r <= '~'
+}
+
+// requestIDConfig contains the settings of a RequestID middleware.
+type requestIDConfig struct {
+	header   string
+	gen      IDGenerator
+	validate RequestIDValidator
+	echo     bool
+}
+
+// RequestIDOpt defines the type for RequestID options.
+type RequestIDOpt func(*requestIDConfig)
+
+// WithRequestIDHeader returns an option that sets the header
+// used to read and echo the request ID.
+func WithRequestIDHeader(header string) RequestIDOpt {
+	return func(c *requestIDConfig) {
+		c.header = header
+	}
+}
+
+// WithRequestIDGenerator returns an option that sets the generator
+// used to create new request IDs.
+func WithRequestIDGenerator(gen IDGenerator) RequestIDOpt {
+	return func(c *requestIDConfig) {
+		c.gen = gen
+	}
+}
+
+// WithRequestIDValidator returns an option that sets the policy
+// used to accept request IDs coming from clients.
+// A nil validator makes the middleware ignore incoming IDs.
+func WithRequestIDValidator(v RequestIDValidator) RequestIDOpt {
+	return func(c *requestIDConfig) {
+		c.validate = v
+	}
+}
+
+// WithRequestIDEcho returns an option that makes the middleware
+// send the request ID back in the response header.
+func WithRequestIDEcho(echo bool) RequestIDOpt {
+	return func(c *requestIDConfig) {
+		c.echo = echo
 	}
-	reqPrefix = string(b64[0:10])
 }
 
 // RequestID is a middleware that injects a request ID into the context of each request.
-// Retrieve it using ctx.Value(ReqIDKey). If the incoming request has a RequestIDHeader header then
-// that value is used else a random value is generated.
-func RequestID() web.Middleware {
-	lengthLimit := DefaultRequestIDLengthLimit
+// Retrieve it using ContextRequestID. If the incoming request has a valid request ID
+// header then that value is used else a new value is generated.
+//
+// By default the X-Request-Id header is used, IDs are generated by a CounterGenerator
+// owned by the middleware instance and incoming IDs are accepted if they are made
+// of visible ASCII characters and are not longer than DefaultRequestIDLengthLimit.
+func RequestID(opts ...RequestIDOpt) web.Middleware {
+	cfg := requestIDConfig{
+		header:   RequestIDHeader,
+		validate: ValidRequestID(DefaultRequestIDLengthLimit, IsVisibleASCII),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.gen == nil {
+		cfg.gen = CounterGenerator(nil)
+	}
+
 	m := func(handler web.Handler) web.Handler {
 		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 
-			id := r.Header.Get(RequestIDHeader)
-			if id == "" {
-				id = fmt.Sprintf("%s-%d", reqPrefix, atomic.AddInt64(&reqID, 1))
-			} else if lengthLimit >= 0 && len(id) > lengthLimit {
-				id = id[:lengthLimit]
+			id := r.Header.Get(cfg.header)
+			if id == "" || cfg.validate == nil || !cfg.validate(id) {
+				id = cfg.gen()
 			}
 			ctx = context.WithValue(ctx, reqIDKey, id)
 
+			if cfg.echo {
+				w.Header().Set(cfg.header, id)
+			}
+
 			return handler(ctx, w, r)
 		}
 		return h
@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// IDGenerator creates new request IDs.
+// Generators must be safe for concurrent use.
+type IDGenerator func() string
+
+// entropy serializes the reads from a source of randomness, so that
+// non thread safe readers can be used to get reproducible IDs in tests.
+// A nil reader is replaced by crypto/rand.
+type entropy struct {
+	mu sync.Mutex
+	r  io.Reader
+}
+
+func newEntropy(r io.Reader) *entropy {
+	if r == nil {
+		r = rand.Reader
+	}
+	return &entropy{r: r}
+}
+
+func (e *entropy) read(b []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, _ = io.ReadFull(e.r, b)
+}
+
+// CounterGenerator returns a generator creating IDs in the form 'prefix-counter'.
+// The random prefix is read from the given source once, when the generator is created,
+// while the counter is owned by the generator.
+// Algorithm taken from https://github.com/zenazn/goji/blob/master/web/middleware/request_id.go#L44-L50 .
+func CounterGenerator(src io.Reader) IDGenerator {
+	e := newEntropy(src)
+	var buf [12]byte
+	var b64 string
+	for len(b64) < 10 {
+		e.read(buf[:])
+		b64 = base64.StdEncoding.EncodeToString(buf[:])
+		b64 = strings.NewReplacer("+", "", "/", "").Replace(b64)
+	}
+	prefix := b64[0:10]
+
+	var counter int64
+	return func() string {
+		return fmt.Sprintf("%s-%d", prefix, atomic.AddInt64(&counter, 1))
+	}
+}
+
+// UUIDv4Generator returns a generator creating random UUIDs (RFC 9562, version 4).
+// Randomness is read from src, crypto/rand is used if src is nil.
+func UUIDv4Generator(src io.Reader) IDGenerator {
+	e := newEntropy(src)
+	return func() string {
+		var u [16]byte
+		e.read(u[:])
+		u[6] = (u[6] & 0x0f) | 0x40
+		u[8] = (u[8] & 0x3f) | 0x80
+		return formatUUID(u)
+	}
+}
+
+// UUIDv7Generator returns a generator creating time ordered UUIDs (RFC 9562, version 7).
+// Randomness is read from src, crypto/rand is used if src is nil.
+// The timestamp is taken from now, time.Now is used if now is nil.
+func UUIDv7Generator(src io.Reader, now func() time.Time) IDGenerator {
+	e := newEntropy(src)
+	if now == nil {
+		now = time.Now
+	}
+	return func() string {
+		var u [16]byte
+		ms := uint64(now().UnixMilli())
+		u[0] = byte(ms >> 40)
+		u[1] = byte(ms >> 32)
+		binary.BigEndian.PutUint32(u[2:6], uint32(ms))
+		e.read(u[6:])
+		u[6] = (u[6] & 0x0f) | 0x70
+		u[8] = (u[8] & 0x3f) | 0x80
+		return formatUUID(u)
+	}
+}
+
+func formatUUID(u [16]byte) string {
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:])
+}
+
+// crockford is the base32 alphabet used by ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULIDGenerator returns a generator creating ULIDs (https://github.com/ulid/spec).
+// Randomness is read from src, crypto/rand is used if src is nil.
+// The timestamp is taken from now, time.Now is used if now is nil.
+func ULIDGenerator(src io.Reader, now func() time.Time) IDGenerator {
+	e := newEntropy(src)
+	if now == nil {
+		now = time.Now
+	}
+	return func() string {
+		var u [16]byte
+		ms := uint64(now().UnixMilli())
+		u[0] = byte(ms >> 40)
+		u[1] = byte(ms >> 32)
+		binary.BigEndian.PutUint32(u[2:6], uint32(ms))
+		e.read(u[6:])
+
+		// Encode the 128 bits in 26 characters of 5 bits each,
+		// the first character only carries the 3 most significant bits.
+		hi := binary.BigEndian.Uint64(u[0:8])
+		lo := binary.BigEndian.Uint64(u[8:16])
+		var buf [26]byte
+		for i := 25; i >= 0; i-- {
+			buf[i] = crockford[lo&0x1f]
+			lo = lo>>5 | hi<<59
+			hi >>= 5
+		}
+		return string(buf[:])
+	}
+}
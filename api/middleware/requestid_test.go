@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestID(t *testing.T) {
+	seed := bytes.Repeat([]byte{0xab}, 64)
+	newMiddleware := func() func(header string) (ctxID, echoed string) {
+		mw := RequestID(
+			WithRequestIDHeader("X-Trace"),
+			WithRequestIDGenerator(CounterGenerator(bytes.NewReader(seed))),
+			WithRequestIDEcho(true),
+		)
+		return func(header string) (string, string) {
+			var got string
+			h := mw(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				got = ContextRequestID(ctx)
+				return nil
+			})
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if header != "" {
+				r.Header.Set("X-Trace", header)
+			}
+			w := httptest.NewRecorder()
+			if err := h(r.Context(), w, r); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			return got, w.Header().Get("X-Trace")
+		}
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{name: "generated", header: "", want: "q6urq6urq6-1"},
+		{name: "accepted", header: "client-id", want: "client-id"},
+		{name: "bad charset", header: "bad id", want: "q6urq6urq6-1"},
+		{name: "too long", header: strings.Repeat("a", DefaultRequestIDLengthLimit+1), want: "q6urq6urq6-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Each instance owns its generator, so results don't depend on other tests.
+			id, echoed := newMiddleware()(tt.header)
+			if id != tt.want {
+				t.Errorf("want id %q, got %q", tt.want, id)
+			}
+			if echoed != id {
+				t.Errorf("want echoed id %q, got %q", id, echoed)
+			}
+		})
+	}
+}
+
+func TestIDGenerators(t *testing.T) {
+	now := func() time.Time { return time.UnixMilli(1700000000000) }
+	src := func() *bytes.Reader { return bytes.NewReader(bytes.Repeat([]byte{0xff}, 16)) }
+
+	tests := []struct {
+		name string
+		gen  IDGenerator
+		re   string
+	}{
+		{"uuidv4", UUIDv4Generator(src()), `^ffffffff-ffff-4fff-bfff-ffffffffffff$`},
+		{"uuidv7", UUIDv7Generator(src(), now), `^018bcfe5-6800-7fff-bfff-ffffffffffff$`},
+		{"ulid", ULIDGenerator(src(), now), `^01HF7YAT00ZZZZZZZZZZZZZZZZ$`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if id := tt.gen(); !regexp.MustCompile(tt.re).MatchString(id) {
+				t.Errorf("id %q does not match %s", id, tt.re)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// routeTemplate returns the path template of the route matched by the request,
+// like '/users/{id}'. If no route is available, it returns the raw path and
+// 'ok' set to false.
+// Templates should be preferred to raw paths in logs, traces and metrics,
+// since their cardinality is bounded by the number of routes.
+func routeTemplate(r *http.Request) (tpl string, ok bool) {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl, true
+		}
+	}
+	return r.URL.Path, false
+}
@@ -0,0 +1,300 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/polldo/patweb/api/ratelimit"
+	"github.com/polldo/patweb/api/web"
+	"github.com/sirupsen/logrus"
+)
+
+// SecurityConfig contains the security headers of the responses.
+// Empty fields omit the corresponding header.
+type SecurityConfig struct {
+	// HSTSMaxAge is how long browsers must only use HTTPS to reach the host.
+	HSTSMaxAge time.Duration
+
+	// HSTSIncludeSubdomains extends HSTS to the subdomains of the host.
+	HSTSIncludeSubdomains bool
+
+	// HSTSPreload allows the inclusion of the host in the HSTS preload lists.
+	HSTSPreload bool
+
+	// NoSniff sets X-Content-Type-Options to nosniff.
+	NoSniff bool
+
+	// FrameOptions is the value of X-Frame-Options, like DENY.
+	FrameOptions string
+
+	// ReferrerPolicy is the value of Referrer-Policy, like no-referrer.
+	ReferrerPolicy string
+
+	// CSP is the Content-Security-Policy.
+	CSP *CSP
+
+	// CSPReportOnly sends the policy with Content-Security-Policy-Report-Only,
+	// so that violations are reported without being blocked.
+	CSPReportOnly bool
+
+	// CSPReportPath, if set, is the path where browsers report violations of
+	// the policy. It is added to the policy as report-uri, and api serves
+	// there a CSPReport handler.
+	CSPReportPath string
+}
+
+// DefaultSecurityConfig returns a configuration suitable for JSON APIs,
+// whose responses are never rendered as documents nor framed.
+func DefaultSecurityConfig() SecurityConfig {
+	return SecurityConfig{
+		HSTSMaxAge:            2 * 365 * 24 * time.Hour,
+		HSTSIncludeSubdomains: true,
+		NoSniff:               true,
+		FrameOptions:          "DENY",
+		ReferrerPolicy:        "no-referrer",
+		CSP:                   NewCSP().DefaultSrc(CSPNone).FrameAncestors(CSPNone),
+	}
+}
+
+// cspNonceKeyCtx is the private type used to store the CSP nonce in the context.
+type cspNonceKeyCtx int
+
+// cspNonceKey is the context key used to store the CSP nonce.
+const cspNonceKey cspNonceKeyCtx = 1
+
+// ContextCSPNonce returns the nonce of the request, to be used in the
+// nonce attribute of inline scripts and styles. It's empty if the policy
+// doesn't contain CSPNonce.
+func ContextCSPNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(cspNonceKey).(string)
+	return nonce
+}
+
+// Security sets the security headers of cfg on the responses.
+// A Security middleware passed to api.Handle overrides the global one,
+// so that routes serving documents can relax the policy. The nonce of
+// the request is preserved across the overrides.
+func Security(cfg SecurityConfig) web.Middleware {
+	var hsts string
+	if cfg.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.FormatInt(int64(cfg.HSTSMaxAge/time.Second), 10)
+		if cfg.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if cfg.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+	var nosniff string
+	if cfg.NoSniff {
+		nosniff = "nosniff"
+	}
+
+	var policy string
+	var nonced bool
+	if cfg.CSP != nil {
+		csp := cfg.CSP
+		if cfg.CSPReportPath != "" {
+			csp = csp.Clone().ReportURI(cfg.CSPReportPath)
+		}
+		policy, nonced = csp.String(), csp.usesNonce()
+	}
+	cspHeader, otherCSPHeader := "Content-Security-Policy", "Content-Security-Policy-Report-Only"
+	if cfg.CSPReportOnly {
+		cspHeader, otherCSPHeader = otherCSPHeader, cspHeader
+	}
+
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			hdr := w.Header()
+			setOrDel(hdr, "Strict-Transport-Security", hsts)
+			setOrDel(hdr, "X-Content-Type-Options", nosniff)
+			setOrDel(hdr, "X-Frame-Options", cfg.FrameOptions)
+			setOrDel(hdr, "Referrer-Policy", cfg.ReferrerPolicy)
+
+			p := policy
+			if nonced {
+				nonce := ContextCSPNonce(ctx)
+				if nonce == "" {
+					var err error
+					if nonce, err = newCSPNonce(); err != nil {
+						return err
+					}
+					ctx = context.WithValue(ctx, cspNonceKey, nonce)
+				}
+				p = strings.ReplaceAll(p, CSPNonce, "'nonce-"+nonce+"'")
+			}
+			hdr.Del(otherCSPHeader)
+			setOrDel(hdr, cspHeader, p)
+
+			return handler(ctx, w, r)
+		}
+		return h
+	}
+	return m
+}
+
+// setOrDel sets the header, or deletes it if value is empty.
+func setOrDel(h http.Header, key, value string) {
+	if value == "" {
+		h.Del(key)
+		return
+	}
+	h.Set(key, value)
+}
+
+func newCSPNonce() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("cannot generate CSP nonce: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(b[:]), nil
+}
+
+// maxCSPReportBytes is the maximum size of the violation reports read.
+const maxCSPReportBytes = 64 << 10
+
+// maxCSPFieldLen is the maximum length of the logged values of a report.
+const maxCSPFieldLen = 512
+
+// cspReportFields lists the fields of the violation reports that are logged,
+// in the report-uri and in the Reporting API formats.
+var cspReportFields = map[string]bool{
+	"blocked-uri":         true,
+	"column-number":       true,
+	"disposition":         true,
+	"document-uri":        true,
+	"effective-directive": true,
+	"line-number":         true,
+	"original-policy":     true,
+	"referrer":            true,
+	"script-sample":       true,
+	"source-file":         true,
+	"status-code":         true,
+	"violated-directive":  true,
+	"blockedURL":          true,
+	"columnNumber":        true,
+	"documentURL":         true,
+	"effectiveDirective":  true,
+	"lineNumber":          true,
+	"originalPolicy":      true,
+	"sample":              true,
+	"sourceFile":          true,
+	"statusCode":          true,
+}
+
+// cspReportConfig contains the CSPReport configuration.
+type cspReportConfig struct {
+	limiter *ratelimit.Limiter
+}
+
+// CSPReportOpt defines the type for CSPReport options.
+type CSPReportOpt func(*cspReportConfig)
+
+// WithCSPReportLimiter returns an option that replaces the default limiter
+// of the reports logged for each client, of 10 reports per minute.
+func WithCSPReportLimiter(limiter *ratelimit.Limiter) CSPReportOpt {
+	return func(c *cspReportConfig) {
+		c.limiter = limiter
+	}
+}
+
+// CSPReport handles the violation reports sent by browsers, logging them
+// at info level under the csp_report field. Both the report-uri format and
+// the Reporting API format are accepted. Since reports come from clients,
+// anyone can send them: only the known fields are logged, with their values
+// truncated, and the reports of each client IP over the limit are discarded.
+func CSPReport(log logrus.FieldLogger, opts ...CSPReportOpt) web.Handler {
+	cfg := cspReportConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.limiter == nil {
+		cfg.limiter = ratelimit.NewTokenBucket(ratelimit.NewMemoryStore(16),
+			ratelimit.Limit{Requests: 10, Period: time.Minute})
+	}
+
+	h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		res, err := cfg.limiter.Allow(ctx, "csp:"+ClientIP(ctx, r))
+		if err != nil {
+			return fmt.Errorf("cannot check CSP report limit: %w", err)
+		}
+		// Browsers don't retry reports, so discarded ones are acknowledged
+		// as well, without reading them.
+		if !res.Allowed {
+			log.WithField("req_id", ContextRequestID(ctx)).Debug("CSP report discarded over the limit")
+			return web.Respond(ctx, w, nil, http.StatusNoContent)
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxCSPReportBytes))
+		if err != nil {
+			return fmt.Errorf("cannot read CSP report: %w", err)
+		}
+
+		var reports []map[string]interface{}
+		var legacy struct {
+			Report map[string]interface{} `json:"csp-report"`
+		}
+		var batch []struct {
+			Type string                 `json:"type"`
+			Body map[string]interface{} `json:"body"`
+		}
+		switch {
+		case json.Unmarshal(body, &legacy) == nil && legacy.Report != nil:
+			reports = append(reports, legacy.Report)
+		case json.Unmarshal(body, &batch) == nil:
+			for _, b := range batch {
+				if b.Type == "csp-violation" && b.Body != nil {
+					reports = append(reports, b.Body)
+				}
+			}
+		}
+
+		log := log.WithFields(logrus.Fields{
+			"req_id":    ContextRequestID(ctx),
+			"useragent": r.UserAgent(),
+		})
+		if len(reports) == 0 {
+			log.WithField("report", truncate(string(body), maxCSPFieldLen)).Info("invalid CSP report")
+		}
+		for _, rep := range reports {
+			log.WithField("csp_report", cspReport(rep)).Info("CSP violation")
+		}
+
+		return web.Respond(ctx, w, nil, http.StatusNoContent)
+	}
+	return h
+}
+
+// cspReport keeps the known fields of a report, as strings or numbers.
+func cspReport(rep map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range rep {
+		if !cspReportFields[k] {
+			continue
+		}
+		switch v := v.(type) {
+		case string:
+			out[k] = truncate(v, maxCSPFieldLen)
+		case float64:
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// truncate cuts s to at most n bytes.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/polldo/patweb/api/ratelimit"
+	"github.com/polldo/patweb/api/web"
+	"github.com/sirupsen/logrus"
+)
+
+func TestSecurity(t *testing.T) {
+	var nonce string
+	final := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		nonce = ContextCSPNonce(ctx)
+		return nil
+	}
+	serve := func(mw ...web.Middleware) http.Header {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+		if err := web.WrapMiddleware(mw, final)(r.Context(), w, r); err != nil {
+			t.Fatal(err)
+		}
+		return w.Header()
+	}
+
+	h := serve(Security(DefaultSecurityConfig()))
+	for k, want := range map[string]string{
+		"Strict-Transport-Security": "max-age=63072000; includeSubDomains",
+		"X-Content-Type-Options":    "nosniff",
+		"X-Frame-Options":           "DENY",
+		"Referrer-Policy":           "no-referrer",
+		"Content-Security-Policy":   "default-src 'none'; frame-ancestors 'none'",
+	} {
+		if got := h.Get(k); got != want {
+			t.Errorf("want %s %q, got %q", k, want, got)
+		}
+	}
+
+	// A route serving documents overrides the global policy, in report-only mode.
+	route := DefaultSecurityConfig()
+	route.FrameOptions = ""
+	route.CSP = NewCSP().DefaultSrc(CSPSelf).ScriptSrc(CSPSelf, CSPNonce)
+	route.CSPReportOnly = true
+	route.CSPReportPath = "/csp-reports"
+
+	h = serve(Security(DefaultSecurityConfig()), Security(route))
+	if nonce == "" {
+		t.Fatal("want nonce in context")
+	}
+	want := "default-src 'self'; script-src 'self' 'nonce-" + nonce + "'; report-uri /csp-reports"
+	if got := h.Get("Content-Security-Policy-Report-Only"); got != want {
+		t.Errorf("want report-only policy %q, got %q", want, got)
+	}
+	if h.Get("Content-Security-Policy") != "" || h.Get("X-Frame-Options") != "" {
+		t.Errorf("want global headers overridden, got %v", h)
+	}
+
+	first := nonce
+	serve(Security(route))
+	if nonce == first || strings.ContainsAny(nonce, " ;'") {
+		t.Errorf("want a fresh nonce for each request, got %q", nonce)
+	}
+}
+
+func TestCSPReport(t *testing.T) {
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(&buf)
+	log.SetFormatter(&logrus.JSONFormatter{})
+
+	body := `{"csp-report": {"document-uri": "https://example.com/", "line-number": 3,` +
+		`"blocked-uri": "` + strings.Repeat("a", 2*maxCSPFieldLen) + `", "level": "panic", "msg": "forged"}}`
+	r := httptest.NewRequest(http.MethodPost, "/csp-reports", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	if err := CSPReport(log)(r.Context(), w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	var entry struct {
+		Level  string                 `json:"level"`
+		Msg    string                 `json:"msg"`
+		Report map[string]interface{} `json:"csp_report"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Level != "info" || entry.Msg != "CSP violation" {
+		t.Errorf("want log fields not overridden by the report, got %q %q", entry.Level, entry.Msg)
+	}
+	if entry.Report["document-uri"] != "https://example.com/" || entry.Report["line-number"] != float64(3) {
+		t.Errorf("want known fields logged, got %v", entry.Report)
+	}
+	if _, ok := entry.Report["level"]; ok {
+		t.Errorf("want unknown fields dropped, got %v", entry.Report)
+	}
+	if s, _ := entry.Report["blocked-uri"].(string); len(s) != maxCSPFieldLen {
+		t.Errorf("want values truncated to %d bytes, got %d", maxCSPFieldLen, len(s))
+	}
+}
+
+func TestCSPReportLimit(t *testing.T) {
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(&buf)
+	limiter := ratelimit.NewTokenBucket(ratelimit.NewMemoryStore(1), ratelimit.Limit{Requests: 2, Period: time.Hour})
+	h := CSPReport(log, WithCSPReportLimiter(limiter))
+
+	send := func(addr string) {
+		body := `{"csp-report": {"violated-directive": "script-src"}}`
+		r := httptest.NewRequest(http.MethodPost, "/csp-reports", strings.NewReader(body))
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		if err := h(r.Context(), w, r); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusNoContent {
+			t.Errorf("want status %d, got %d", http.StatusNoContent, w.Code)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		send("192.0.2.1:1234")
+	}
+	send("192.0.2.2:1234")
+
+	if n := strings.Count(buf.String(), "CSP violation"); n != 3 {
+		t.Errorf("want reports over the limit of each client discarded, got %d logged", n)
+	}
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/polldo/patweb/api/session"
+	"github.com/polldo/patweb/api/web"
+)
+
+// sessionKeyCtx is the private type used to store the session in the context.
+type sessionKeyCtx int
+
+// sessionKey is the context key used to store the session of the request.
+const sessionKey sessionKeyCtx = 1
+
+// ContextSession extracts the session of the request from the context.
+func ContextSession(ctx context.Context) (*session.Session, bool) {
+	s, ok := ctx.Value(sessionKey).(*session.Session)
+	return s, ok
+}
+
+// Sessions loads the session of each request, retrieve it using ContextSession.
+// The session is saved by m right before the response header is written,
+// so that its cookie can be set, or when the handler returns if nothing
+// has been written. A failed save replaces the response with an error,
+// as long as the response header has not been sent, so it must be
+// placed after the Errors middleware, usually passed to api.Handle
+// for the browser-facing routes.
+func Sessions(m *session.Manager) web.Middleware {
+	mw := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			s, err := m.Load(ctx, r)
+			if err != nil {
+				return err
+			}
+			ctx = context.WithValue(ctx, sessionKey, s)
+
+			sw := &sessionWriter{ResponseWriter: w, save: func() error { return m.Save(ctx, w, s) }}
+			err = handler(ctx, sw, r)
+			if serr := sw.saveOnce(); serr != nil {
+				return fmt.Errorf("cannot save session: %w", serr)
+			}
+			return err
+		}
+		return h
+	}
+	return mw
+}
+
+// sessionWriter saves the session before the response header is written.
+// If the save fails, the response is discarded.
+type sessionWriter struct {
+	http.ResponseWriter
+	save  func() error
+	saved bool
+	err   error
+}
+
+func (sw *sessionWriter) saveOnce() error {
+	if !sw.saved {
+		sw.saved = true
+		sw.err = sw.save()
+	}
+	return sw.err
+}
+
+func (sw *sessionWriter) WriteHeader(code int) {
+	if sw.saveOnce() != nil {
+		return
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *sessionWriter) Write(b []byte) (int, error) {
+	if err := sw.saveOnce(); err != nil {
+		return 0, err
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *sessionWriter) Flush() {
+	if sw.saveOnce() != nil {
+		return
+	}
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/polldo/patweb/api/session"
+	"github.com/polldo/patweb/api/web"
+)
+
+type failingStore struct{ *session.MemoryStore }
+
+func (failingStore) Save(ctx context.Context, id string, rec *session.Record) error {
+	return errors.New("disk full")
+}
+
+func TestSessions(t *testing.T) {
+	m := session.NewStoreManager(session.NewMemoryStore())
+	h := Sessions(m)(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		s, ok := ContextSession(ctx)
+		if !ok {
+			t.Fatal("want session in context")
+		}
+		n, _ := s.Get("visits")
+		s.Set("visits", n+"x")
+		return web.Respond(ctx, w, map[string]string{"visits": n + "x"}, http.StatusOK)
+	})
+
+	var cookie *http.Cookie
+	for _, want := range []string{`{"visits":"x"}`, `{"visits":"xx"}`} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		if err := h(r.Context(), w, r); err != nil {
+			t.Fatal(err)
+		}
+		if w.Body.String() != want {
+			t.Fatalf("want body %s, got %s", want, w.Body)
+		}
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 {
+			t.Fatalf("want session cookie set before the body, got %v", w.Header())
+		}
+		cookie = cookies[0]
+	}
+}
+
+func TestSessionsSaveError(t *testing.T) {
+	m := session.NewStoreManager(failingStore{session.NewMemoryStore()})
+	h := Sessions(m)(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		s, _ := ContextSession(ctx)
+		s.Set("user", "alice")
+		return web.Respond(ctx, w, map[string]string{"ok": "yes"}, http.StatusOK)
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	if err := h(r.Context(), w, r); err == nil {
+		t.Fatal("want save error")
+	}
+	if w.Body.Len() != 0 || w.Header().Get("Set-Cookie") != "" {
+		t.Errorf("want response discarded, got %d %s", w.Code, w.Body)
+	}
+}
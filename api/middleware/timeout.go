@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/polldo/patweb/api/web"
+	"github.com/polldo/patweb/api/weberr"
+)
+
+// Timeout sets a deadline of d on the context of the request and runs the
+// handler in its own goroutine. If the handler doesn't complete in time, it
+// fails with a 503 error, while the handler is left running to observe the
+// context cancellation. Errors returned by the handler because a dependency
+// exceeded its deadline are turned into 504 errors, also when the handler
+// completes right at the deadline.
+//
+// The response of the handler is buffered and sent only if the handler
+// completes in time, so that a single response is ever written: writes
+// following the deadline fail with http.ErrHandlerTimeout.
+// As a consequence, streaming responses are not supported.
+//
+// Requests canceled by the client fail with a quiet error responding with
+// StatusClientClosedRequest.
+//
+// Timeouts can be nested, like a global one and a per route one passed to
+// api.Handle: the earliest deadline wins.
+// Panics of the handler are propagated to the calling goroutine as a
+// *PanicError, carrying the stack of the handler, so Timeout should be
+// placed after the Panics middleware.
+func Timeout(d time.Duration) web.Middleware {
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+
+			tw := &timeoutWriter{h: w.Header().Clone()}
+			done := make(chan error, 1)
+			panicked := make(chan interface{}, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						// Capture the stack here, the one of the caller is useless.
+						if p != http.ErrAbortHandler {
+							p = web.NewPanicError(p)
+						}
+						panicked <- p
+					}
+				}()
+				done <- handler(ctx, tw, r.WithContext(ctx))
+			}()
+
+			complete := func(err error) error {
+				tw.flush(w)
+				if err != nil && errors.Is(err, context.DeadlineExceeded) {
+					if _, _, ok := weberr.Response(err); !ok {
+						return newRequestError(err, http.StatusGatewayTimeout, "a dependency timed out",
+							weberr.WithFields(map[string]interface{}{"timeout": d.String()}),
+						)
+					}
+				}
+				return err
+			}
+
+			select {
+			case p := <-panicked:
+				panic(p)
+
+			case err := <-done:
+				return complete(err)
+
+			case <-ctx.Done():
+				// The handler may have completed right at the deadline.
+				select {
+				case p := <-panicked:
+					panic(p)
+				case err := <-done:
+					return complete(err)
+				default:
+				}
+
+				tw.timeout()
+				if ctx.Err() != context.DeadlineExceeded {
+					// The client went away, nobody is waiting for the response.
+					return clientClosed(ctx.Err())
+				}
+				route, _ := routeTemplate(r)
+				return newRequestError(fmt.Errorf("handler timed out after %s", d), http.StatusServiceUnavailable, "request timed out",
+					weberr.WithFields(map[string]interface{}{"timeout": d.String(), "route": route}),
+				)
+			}
+		}
+		return h
+	}
+	return m
+}
+
+// StatusClientClosedRequest is the non-standard status, introduced by nginx,
+// of the requests canceled by clients before receiving the response.
+const StatusClientClosedRequest = 499
+
+// clientClosed builds the quiet error of a request canceled by the client.
+func clientClosed(err error) error {
+	return weberr.Wrap(fmt.Errorf("request canceled: %w", err),
+		weberr.WithResponse(&web.ErrorResponse{Error: "request canceled", Status: "Client Closed Request"}, StatusClientClosedRequest),
+		weberr.WithQuiet(true),
+	)
+}
+
+// timeoutWriter buffers the response of a handler until it completes.
+// Once timed out, it discards any write.
+type timeoutWriter struct {
+	mu          sync.Mutex
+	h           http.Header
+	buf         bytes.Buffer
+	code        int
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.h }
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	tw.code = code
+	tw.wroteHeader = true
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.code = http.StatusOK
+		tw.wroteHeader = true
+	}
+	return tw.buf.Write(b)
+}
+
+// timeout makes the following writes fail.
+func (tw *timeoutWriter) timeout() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.timedOut = true
+}
+
+// flush sends the buffered response to w.
+func (tw *timeoutWriter) flush(w http.ResponseWriter) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	dst := w.Header()
+	for k := range dst {
+		if _, ok := tw.h[k]; !ok {
+			dst.Del(k)
+		}
+	}
+	for k, vv := range tw.h {
+		dst[k] = vv
+	}
+	if !tw.wroteHeader {
+		return
+	}
+	w.WriteHeader(tw.code)
+	_, _ = w.Write(tw.buf.Bytes())
+}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/polldo/patweb/api/web"
+	"github.com/polldo/patweb/api/weberr"
+	"github.com/sirupsen/logrus"
+)
+
+func TestTimeout(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	tests := []struct {
+		name    string
+		handler web.Handler
+		status  int
+		body    string
+	}{
+		{
+			name: "completed",
+			handler: func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				return web.Respond(ctx, w, "ok", http.StatusCreated)
+			},
+			status: http.StatusCreated,
+			body:   `"ok"`,
+		},
+		{
+			name: "late writes are discarded",
+			handler: func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				<-ctx.Done()
+				time.Sleep(10 * time.Millisecond)
+				return web.Respond(ctx, w, "late", http.StatusOK)
+			},
+			status: http.StatusServiceUnavailable,
+			body:   `{"error":"request timed out","status":"Service Unavailable"}`,
+		},
+		{
+			name: "dependency timed out",
+			handler: func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				dctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+				defer cancel()
+				<-dctx.Done()
+				return fmt.Errorf("calling dependency: %w", dctx.Err())
+			},
+			status: http.StatusGatewayTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := web.WrapMiddleware([]web.Middleware{Errors(log), Timeout(20 * time.Millisecond)}, tt.handler)
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			w := httptest.NewRecorder()
+			if err := h(r.Context(), w, r); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			time.Sleep(20 * time.Millisecond)
+
+			if w.Code != tt.status {
+				t.Errorf("want status %d, got %d", tt.status, w.Code)
+			}
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Errorf("want body %s, got %s", tt.body, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestTimeoutPanicStack(t *testing.T) {
+	var perr *PanicError
+	report := WithPanicReporter(func(ctx context.Context, e *PanicError) { perr = e })
+	h := web.WrapMiddleware([]web.Middleware{Panics(report), Timeout(time.Second)},
+		func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			panic("boom")
+		})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := h(r.Context(), httptest.NewRecorder(), r); err == nil {
+		t.Fatal("want panic error")
+	}
+	if perr == nil || len(perr.Stack) == 0 || !strings.HasSuffix(perr.Stack[0].File, "timeout_test.go") {
+		t.Fatalf("want stack of the handler, got %+v", perr.Stack)
+	}
+}
+
+func TestTimeoutClientCanceled(t *testing.T) {
+	h := Timeout(time.Minute)(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	cancel()
+	err := h(ctx, httptest.NewRecorder(), r)
+	if errorStatus(err) != StatusClientClosedRequest || !weberr.IsQuiet(err) {
+		t.Errorf("want quiet %d error, got %v", StatusClientClosedRequest, err)
+	}
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/polldo/patweb/api/trace"
+	"github.com/polldo/patweb/api/web"
+	"github.com/zenazn/goji/web/mutil"
+)
+
+// Trace starts a server span for each request and stores it in the context,
+// where handlers can retrieve it with trace.SpanFromContext or create child
+// spans with trace.Start.
+// If the request carries a valid traceparent header the span continues that
+// trace, otherwise a new trace is started. The span context is sent back
+// in the traceparent and tracestate response headers.
+//
+// It should be placed before the Errors middleware, which records the
+// handled errors in the span.
+func Trace(tracer *trace.Tracer) web.Middleware {
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+			// Continue the trace of the caller, if any.
+			tp := r.Header.Get(trace.TraceparentHeader)
+			if sc, err := trace.ParseTraceparent(tp, r.Header.Get(trace.TracestateHeader)); err == nil {
+				ctx = trace.ContextWithRemoteParent(ctx, sc)
+			}
+
+			route, _ := routeTemplate(r)
+			ctx, span := tracer.Start(ctx, r.Method+" "+route,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(map[string]interface{}{
+					"http.method": r.Method,
+					"http.route":  route,
+					"http.target": r.URL.RequestURI(),
+				}),
+			)
+			defer span.End()
+
+			// Correlate the span with the logs.
+			if rid := ContextRequestID(ctx); rid != "" {
+				span.SetAttribute("req_id", rid)
+			}
+
+			trace.Inject(ctx, w.Header())
+
+			lw := mutil.WrapWriter(w)
+			err := handler(ctx, lw, r)
+
+			status := lw.Status()
+			span.SetAttribute("http.status_code", status)
+			switch {
+			case err != nil:
+				span.RecordError(err)
+			case status >= http.StatusInternalServerError:
+				span.SetStatus(trace.StatusError, http.StatusText(status))
+			default:
+				span.SetStatus(trace.StatusOK, "")
+			}
+			return err
+		}
+		return h
+	}
+	return m
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/polldo/patweb/api/trace"
+	"github.com/polldo/patweb/api/web"
+	"github.com/polldo/patweb/api/weberr"
+	"github.com/sirupsen/logrus"
+)
+
+func TestTrace(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	exp := trace.NewMemoryExporter()
+	mw := []web.Middleware{RequestID(), Trace(trace.NewTracer(exp)), Errors(log)}
+	h := web.WrapMiddleware(mw, func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		err := errors.New("not found")
+		return weberr.Wrap(err,
+			weberr.WithFields(map[string]interface{}{"user": "someone"}),
+			weberr.WithResponse(nil, http.StatusNotFound),
+		)
+	})
+
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	r := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	r.Header.Set(trace.TraceparentHeader, parent)
+	w := httptest.NewRecorder()
+	if err := h(r.Context(), w, r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	spans := exp.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("want 1 span, got %d", len(spans))
+	}
+	s := spans[0]
+	if s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || s.ParentID != "00f067aa0ba902b7" {
+		t.Errorf("span should continue the incoming trace, got %+v", s)
+	}
+	if s.Status != trace.StatusError {
+		t.Errorf("want error status, got %q", s.Status)
+	}
+	if s.Attributes["user"] != "someone" || s.Attributes["http.status_code"] != http.StatusNotFound {
+		t.Errorf("span should carry error fields and status, got %v", s.Attributes)
+	}
+	if s.Attributes["req_id"] == "" {
+		t.Errorf("span should carry the request id")
+	}
+	if got := w.Header().Get(trace.TraceparentHeader); got == "" || got == parent {
+		t.Errorf("want traceparent of the server span in response, got %q", got)
+	}
+}
@@ -0,0 +1,193 @@
+// Package ratelimit implements token bucket and sliding window rate limiters
+// whose state is kept in a pluggable Store.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Limit describes how many requests are allowed in a period of time.
+type Limit struct {
+	// Requests is the number of requests allowed in Period.
+	Requests int
+
+	// Period is the time window of the limit.
+	Period time.Duration
+
+	// Burst is the maximum number of requests allowed at once by
+	// token buckets. It defaults to Requests. Sliding windows ignore it.
+	Burst int
+}
+
+// Result is the outcome of a rate limit check.
+type Result struct {
+	// Allowed reports whether the request can be served.
+	Allowed bool
+
+	// Limit is the maximum number of requests allowed.
+	Limit int
+
+	// Remaining is the number of requests that can still be served.
+	Remaining int
+
+	// ResetAfter is the time after which the limit is fully restored.
+	ResetAfter time.Duration
+
+	// RetryAfter is the time after which a denied request can be retried.
+	RetryAfter time.Duration
+}
+
+// State is the per key state of the limiters, persisted in a Store.
+type State struct {
+	// Tokens are the tokens left in a token bucket.
+	Tokens float64 `json:"tokens"`
+
+	// Stamp is the last refill of a token bucket,
+	// or the start of the current window of a sliding window.
+	Stamp time.Time `json:"stamp"`
+
+	// Prev and Curr are the requests counted by a sliding window
+	// in the previous and current windows.
+	Prev int `json:"prev"`
+	Curr int `json:"curr"`
+}
+
+// algorithm updates the state of a key when a request arrives.
+type algorithm func(s *State, l Limit, now time.Time) Result
+
+// Limiter checks whether requests identified by a key exceed a limit.
+type Limiter struct {
+	store  Store
+	limit  Limit
+	alg    algorithm
+	now    func() time.Time
+	prefix string
+}
+
+// LimiterOpt defines the type for Limiter options.
+type LimiterOpt func(*Limiter)
+
+// WithClock returns an option that sets the function used to get the current time.
+func WithClock(now func() time.Time) LimiterOpt {
+	return func(l *Limiter) {
+		l.now = now
+	}
+}
+
+// WithPrefix returns an option that prefixes the keys of the limiter, so that
+// limiters with different limits can share the same store.
+func WithPrefix(prefix string) LimiterOpt {
+	return func(l *Limiter) {
+		l.prefix = prefix
+	}
+}
+
+// newLimiter panics if the limit is not valid, since it's a programming error.
+func newLimiter(store Store, limit Limit, alg algorithm, opts []LimiterOpt) *Limiter {
+	if limit.Requests <= 0 || limit.Period <= 0 {
+		panic(fmt.Sprintf("ratelimit: invalid limit of %d requests per %v", limit.Requests, limit.Period))
+	}
+	if limit.Burst <= 0 {
+		limit.Burst = limit.Requests
+	}
+	l := &Limiter{store: store, limit: limit, alg: alg, now: time.Now}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// NewTokenBucket constructs a limiter refilling a bucket of limit.Burst tokens
+// at a rate of limit.Requests per limit.Period. Each request consumes a token.
+// It panics if limit.Requests or limit.Period are not positive.
+func NewTokenBucket(store Store, limit Limit, opts ...LimiterOpt) *Limiter {
+	return newLimiter(store, limit, tokenBucket, opts)
+}
+
+// NewSlidingWindow constructs a limiter allowing limit.Requests per limit.Period.
+// The requests of the sliding window are estimated weighting the count of the
+// previous fixed window by its overlap with the sliding one.
+// It panics if limit.Requests or limit.Period are not positive.
+func NewSlidingWindow(store Store, limit Limit, opts ...LimiterOpt) *Limiter {
+	return newLimiter(store, limit, slidingWindow, opts)
+}
+
+// Limit returns the limit enforced by the limiter.
+func (l *Limiter) Limit() Limit { return l.limit }
+
+// Allow consumes a request for the key and reports whether it's within the limit.
+func (l *Limiter) Allow(ctx context.Context, key string) (Result, error) {
+	var res Result
+	now := l.now()
+	err := l.store.Update(ctx, l.prefix+key, 2*l.limit.Period, func(s *State) {
+		res = l.alg(s, l.limit, now)
+	})
+	return res, err
+}
+
+func tokenBucket(s *State, l Limit, now time.Time) Result {
+	rate := float64(l.Requests) / l.Period.Seconds()
+	capacity := float64(l.Burst)
+
+	// Refill the bucket, new keys start full.
+	if s.Stamp.IsZero() {
+		s.Tokens = capacity
+	} else if elapsed := now.Sub(s.Stamp).Seconds(); elapsed > 0 {
+		s.Tokens = math.Min(capacity, s.Tokens+elapsed*rate)
+	}
+	s.Stamp = now
+
+	res := Result{Limit: l.Burst}
+	if s.Tokens >= 1 {
+		s.Tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - s.Tokens) / rate)
+	}
+	res.Remaining = int(s.Tokens)
+	res.ResetAfter = seconds((capacity - s.Tokens) / rate)
+	return res
+}
+
+func slidingWindow(s *State, l Limit, now time.Time) Result {
+	window := now.Truncate(l.Period)
+	switch {
+	case s.Stamp.Equal(window):
+	case s.Stamp.Add(l.Period).Equal(window):
+		s.Prev, s.Curr = s.Curr, 0
+	default:
+		s.Prev, s.Curr = 0, 0
+	}
+	s.Stamp = window
+
+	elapsed := now.Sub(window)
+	weight := 1 - float64(elapsed)/float64(l.Period)
+	count := func() float64 { return float64(s.Prev)*weight + float64(s.Curr) }
+
+	res := Result{Limit: l.Requests, ResetAfter: l.Period - elapsed}
+	if count() < float64(l.Requests) {
+		s.Curr++
+		res.Allowed = true
+	} else if s.Prev > 0 && float64(s.Curr) < float64(l.Requests) {
+		// Wait for the previous window to slide enough to make room for one request.
+		need := (count() - float64(l.Requests) + 1) / float64(s.Prev)
+		res.RetryAfter = time.Duration(need * float64(l.Period))
+		if res.RetryAfter > l.Period-elapsed {
+			res.RetryAfter = l.Period - elapsed
+		}
+	} else {
+		res.RetryAfter = l.Period - elapsed
+	}
+	res.Remaining = l.Requests - int(math.Ceil(count()))
+	if res.Remaining < 0 {
+		res.Remaining = 0
+	}
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiters(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	clock := WithClock(func() time.Time { return now })
+	limit := Limit{Requests: 2, Period: time.Second}
+
+	tests := []struct {
+		name    string
+		limiter *Limiter
+	}{
+		{"token bucket in memory", NewTokenBucket(NewMemoryStore(4), limit, clock)},
+		{"token bucket in kv", NewTokenBucket(NewKVStore(NewLocalKV()), limit, clock)},
+		{"sliding window in memory", NewSlidingWindow(NewMemoryStore(4), limit, clock)},
+		{"sliding window in kv", NewSlidingWindow(NewKVStore(NewLocalKV()), limit, clock)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = start
+			allow := func() Result {
+				res, err := tt.limiter.Allow(context.Background(), "client")
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return res
+			}
+
+			for i := 0; i < 2; i++ {
+				if res := allow(); !res.Allowed {
+					t.Fatalf("request %d should be allowed", i)
+				}
+			}
+			res := allow()
+			if res.Allowed || res.Remaining != 0 || res.RetryAfter <= 0 {
+				t.Fatalf("request over the limit should be denied, got %+v", res)
+			}
+
+			now = now.Add(2 * time.Second)
+			if res := allow(); !res.Allowed {
+				t.Fatalf("request should be allowed once the limit is restored, got %+v", res)
+			}
+		})
+	}
+}
+
+func TestInvalidLimit(t *testing.T) {
+	for _, limit := range []Limit{{Requests: 1}, {Requests: 1, Period: -time.Second}, {Period: time.Second}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("want panic for limit %+v", limit)
+				}
+			}()
+			NewTokenBucket(NewMemoryStore(1), limit)
+		}()
+	}
+}
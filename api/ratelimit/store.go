@@ -0,0 +1,191 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// Store persists the state of the limiters.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Update atomically passes the state of key to fn, which modifies it,
+	// and stores the result for at least ttl. Missing keys have a zero State.
+	Update(ctx context.Context, key string, ttl time.Duration, fn func(s *State)) error
+}
+
+// sweepEvery is the number of updates of a shard after which its expired keys are removed.
+const sweepEvery = 1024
+
+// MemoryStore is an in-memory Store. Keys are spread over shards,
+// each one with its own lock, to reduce contention.
+type MemoryStore struct {
+	shards []*memShard
+}
+
+type memShard struct {
+	mu      sync.Mutex
+	entries map[string]*memEntry
+	ops     int
+}
+
+type memEntry struct {
+	state   State
+	expires time.Time
+}
+
+// NewMemoryStore constructs a MemoryStore with the given number of shards.
+// A single shard is used if shards is not positive.
+func NewMemoryStore(shards int) *MemoryStore {
+	if shards <= 0 {
+		shards = 1
+	}
+	m := &MemoryStore{shards: make([]*memShard, shards)}
+	for i := range m.shards {
+		m.shards[i] = &memShard{entries: map[string]*memEntry{}}
+	}
+	return m
+}
+
+// Update implements the Store interface.
+func (m *MemoryStore) Update(ctx context.Context, key string, ttl time.Duration, fn func(s *State)) error {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	sh := m.shards[h.Sum32()%uint32(len(m.shards))]
+
+	now := time.Now()
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	sh.ops++
+	if sh.ops%sweepEvery == 0 {
+		for k, e := range sh.entries {
+			if now.After(e.expires) {
+				delete(sh.entries, k)
+			}
+		}
+	}
+
+	e, ok := sh.entries[key]
+	if !ok || now.After(e.expires) {
+		e = &memEntry{}
+		sh.entries[key] = e
+	}
+	fn(&e.state)
+	e.expires = now.Add(ttl)
+	return nil
+}
+
+// KV is a minimal key value store with compare and swap semantics,
+// as offered by most shared stores (Redis, memcached, etcd...).
+type KV interface {
+	// Get returns the value of key. Missing keys return a nil value.
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// CompareAndSwap sets the value of key to new, expiring after ttl, only if
+	// its current value is old. A nil old value means that key must be missing.
+	CompareAndSwap(ctx context.Context, key string, old, new []byte, ttl time.Duration) (bool, error)
+}
+
+// ErrContention is returned when a KVStore cannot update a key
+// because of too many concurrent updates.
+var ErrContention = errors.New("rate limit state contended")
+
+// maxCASRetries is the maximum number of attempts of a KVStore update.
+const maxCASRetries = 16
+
+// KVStore is a Store persisting the state in a KV, so that the limits
+// can be shared by multiple instances of the service.
+type KVStore struct {
+	kv KV
+}
+
+// NewKVStore constructs a Store on top of kv.
+func NewKVStore(kv KV) *KVStore {
+	return &KVStore{kv: kv}
+}
+
+// Update implements the Store interface.
+func (s *KVStore) Update(ctx context.Context, key string, ttl time.Duration, fn func(s *State)) error {
+	for i := 0; i < maxCASRetries; i++ {
+		old, err := s.kv.Get(ctx, key)
+		if err != nil {
+			return fmt.Errorf("cannot get rate limit state: %w", err)
+		}
+
+		var st State
+		if old != nil {
+			if err := json.Unmarshal(old, &st); err != nil {
+				return fmt.Errorf("cannot decode rate limit state: %w", err)
+			}
+		}
+		fn(&st)
+		b, err := json.Marshal(st)
+		if err != nil {
+			return fmt.Errorf("cannot encode rate limit state: %w", err)
+		}
+
+		ok, err := s.kv.CompareAndSwap(ctx, key, old, b, ttl)
+		if err != nil {
+			return fmt.Errorf("cannot store rate limit state: %w", err)
+		}
+		if ok {
+			return nil
+		}
+	}
+	return ErrContention
+}
+
+// LocalKV is an in-process KV. It stands in for a shared store
+// in tests and single instance deployments.
+type LocalKV struct {
+	mu      sync.Mutex
+	entries map[string]kvEntry
+}
+
+type kvEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// NewLocalKV constructs an empty LocalKV.
+func NewLocalKV() *LocalKV {
+	return &LocalKV{entries: map[string]kvEntry{}}
+}
+
+// Get implements the KV interface.
+func (l *LocalKV) Get(ctx context.Context, key string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, nil
+	}
+	return e.value, nil
+}
+
+// CompareAndSwap implements the KV interface.
+func (l *LocalKV) CompareAndSwap(ctx context.Context, key string, old, new []byte, ttl time.Duration) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var cur []byte
+	if e, ok := l.entries[key]; ok && !time.Now().After(e.expires) {
+		cur = e.value
+	}
+	if (cur == nil) != (old == nil) || !bytes.Equal(cur, old) {
+		return false, nil
+	}
+	l.entries[key] = kvEntry{value: append([]byte(nil), new...), expires: time.Now().Add(ttl)}
+	return true, nil
+}
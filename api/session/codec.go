@@ -0,0 +1,91 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// MinKeyLen is the minimum length of the keys of a Codec.
+const MinKeyLen = 32
+
+// ErrInvalidCookie is returned when a cookie cannot be authenticated or decoded.
+var ErrInvalidCookie = errors.New("invalid cookie")
+
+// Codec signs and encrypts cookie values with AES-GCM, so that clients
+// can neither read nor alter them.
+type Codec struct {
+	aeads []cipher.AEAD
+}
+
+// NewCodec constructs a Codec from secret keys of at least MinKeyLen bytes.
+// Values are encoded with the first key and decoded with any of them,
+// so that keys can be rotated by prepending a new one and removing the
+// oldest once the cookies it encoded have expired.
+func NewCodec(keys ...[]byte) (*Codec, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("missing codec key")
+	}
+	c := &Codec{}
+	for i, k := range keys {
+		if len(k) < MinKeyLen {
+			return nil, fmt.Errorf("codec key %d shorter than %d bytes", i, MinKeyLen)
+		}
+		sum := sha256.Sum256(k)
+		block, err := aes.NewCipher(sum[:])
+		if err != nil {
+			return nil, fmt.Errorf("cannot construct cipher: %w", err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("cannot construct cipher: %w", err)
+		}
+		c.aeads = append(c.aeads, aead)
+	}
+	return c, nil
+}
+
+// Encode encodes v as JSON and encrypts it for the cookie with the given
+// name. The name is authenticated too, so that values can't be moved
+// between cookies.
+func (c *Codec) Encode(name string, v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("cannot encode cookie: %w", err)
+	}
+	aead := c.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(b)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("cannot generate nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, b, []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Decode decrypts the value of the cookie with the given name into v.
+func (c *Codec) Decode(name, value string, v interface{}) error {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return ErrInvalidCookie
+	}
+	for _, aead := range c.aeads {
+		if len(sealed) < aead.NonceSize() {
+			continue
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		b, err := aead.Open(nil, nonce, ciphertext, []byte(name))
+		if err != nil {
+			continue
+		}
+		if err := json.Unmarshal(b, v); err != nil {
+			return ErrInvalidCookie
+		}
+		return nil
+	}
+	return ErrInvalidCookie
+}
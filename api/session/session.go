@@ -0,0 +1,364 @@
+// Package session implements HTTP sessions kept either in encrypted
+// cookies or in a server-side Store, expiring by idle and absolute timeouts.
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Defaults of the Manager settings.
+const (
+	DefaultCookieName      = "session"
+	DefaultIdleTimeout     = 30 * time.Minute
+	DefaultAbsoluteTimeout = 24 * time.Hour
+)
+
+// maxCookieLen is the maximum length of a cookie value accepted by browsers.
+const maxCookieLen = 4096
+
+// ErrTooLarge is returned when a session doesn't fit in a cookie.
+var ErrTooLarge = errors.New("session too large for a cookie")
+
+// Record is the stored state of a session.
+type Record struct {
+	Values    map[string]string `json:"values,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	LastSeen  time.Time         `json:"last_seen"`
+
+	// ExpiresAt is the time after which the session is not valid anymore.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (r *Record) expired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && now.After(r.ExpiresAt)
+}
+
+func (r *Record) clone() Record {
+	cp := *r
+	cp.Values = make(map[string]string, len(r.Values))
+	for k, v := range r.Values {
+		cp.Values[k] = v
+	}
+	return cp
+}
+
+// Session is the session of a request. It's safe for concurrent use.
+type Session struct {
+	mu        sync.Mutex
+	id        string
+	oldID     string
+	rec       Record
+	isNew     bool
+	dirty     bool
+	renew     bool
+	destroyed bool
+}
+
+// Get returns the value of key.
+func (s *Session) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.rec.Values[key]
+	return v, ok
+}
+
+// Set sets the value of key.
+func (s *Session) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rec.Values == nil {
+		s.rec.Values = map[string]string{}
+	}
+	s.rec.Values[key] = value
+	s.dirty = true
+}
+
+// Delete removes key.
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rec.Values[key]; ok {
+		delete(s.rec.Values, key)
+		s.dirty = true
+	}
+}
+
+// IsNew reports whether the session has been created by the request.
+func (s *Session) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isNew
+}
+
+// CreatedAt returns the time the session has been created.
+func (s *Session) CreatedAt() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rec.CreatedAt
+}
+
+// Renew gives a new ID to server-side sessions, keeping their values.
+// It should be called when the privileges change, like on login,
+// to prevent session fixation.
+func (s *Session) Renew() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.renew = true
+	s.dirty = true
+}
+
+// Destroy removes the session and its cookie, like on logout.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
+}
+
+// Manager loads and saves the sessions of requests.
+type Manager struct {
+	codec *Codec
+	store Store
+
+	name     string
+	path     string
+	domain   string
+	secure   bool
+	sameSite http.SameSite
+	idle     time.Duration
+	absolute time.Duration
+	now      func() time.Time
+}
+
+// ManagerOpt defines the type for Manager options.
+type ManagerOpt func(*Manager)
+
+// WithCookie returns an option that sets the name, path and domain
+// of the session cookie, by default 'session', '/' and the host.
+func WithCookie(name, path, domain string) ManagerOpt {
+	return func(m *Manager) {
+		m.name, m.path, m.domain = name, path, domain
+	}
+}
+
+// WithInsecureCookie returns an option that sends the session cookie on
+// plain HTTP too. It's meant for development.
+func WithInsecureCookie() ManagerOpt {
+	return func(m *Manager) {
+		m.secure = false
+	}
+}
+
+// WithSameSite returns an option that sets the SameSite attribute
+// of the session cookie, by default Lax.
+func WithSameSite(mode http.SameSite) ManagerOpt {
+	return func(m *Manager) {
+		m.sameSite = mode
+	}
+}
+
+// WithIdleTimeout returns an option that sets the time after which
+// unused sessions expire. Zero disables the idle timeout.
+func WithIdleTimeout(d time.Duration) ManagerOpt {
+	return func(m *Manager) {
+		m.idle = d
+	}
+}
+
+// WithAbsoluteTimeout returns an option that sets the time after which
+// sessions expire since their creation. Zero disables the absolute timeout.
+func WithAbsoluteTimeout(d time.Duration) ManagerOpt {
+	return func(m *Manager) {
+		m.absolute = d
+	}
+}
+
+// WithClock returns an option that sets the function returning the current time.
+func WithClock(now func() time.Time) ManagerOpt {
+	return func(m *Manager) {
+		m.now = now
+	}
+}
+
+func newManager(opts []ManagerOpt) *Manager {
+	m := &Manager{
+		name:     DefaultCookieName,
+		path:     "/",
+		secure:   true,
+		sameSite: http.SameSiteLaxMode,
+		idle:     DefaultIdleTimeout,
+		absolute: DefaultAbsoluteTimeout,
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// NewCookieManager constructs a Manager keeping the sessions in cookies
+// encrypted by codec. Cookies are limited to about 3KB of values.
+func NewCookieManager(codec *Codec, opts ...ManagerOpt) *Manager {
+	m := newManager(opts)
+	m.codec = codec
+	return m
+}
+
+// NewStoreManager constructs a Manager keeping the sessions in store,
+// whose cookies carry only random IDs.
+func NewStoreManager(store Store, opts ...ManagerOpt) *Manager {
+	m := newManager(opts)
+	m.store = store
+	return m
+}
+
+// Load returns the session of r, or a new one if r has no valid session.
+func (m *Manager) Load(ctx context.Context, r *http.Request) (*Session, error) {
+	now := m.now()
+	fresh := &Session{isNew: true, rec: Record{CreatedAt: now, LastSeen: now}}
+
+	c, err := r.Cookie(m.name)
+	if err != nil || c.Value == "" {
+		return fresh, nil
+	}
+
+	var rec Record
+	if m.store != nil {
+		stored, err := m.store.Load(ctx, c.Value)
+		if errors.Is(err, ErrNotFound) {
+			return fresh, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot load session: %w", err)
+		}
+		rec = *stored
+	} else if err := m.codec.Decode(m.name, c.Value, &rec); err != nil {
+		return fresh, nil
+	}
+
+	if exp := m.expiresAt(&rec); !exp.IsZero() && exp.Before(now) {
+		fresh.oldID = c.Value
+		return fresh, nil
+	}
+	return &Session{id: c.Value, rec: rec}, nil
+}
+
+// Save stores the session, if changed, and sets its cookie.
+// It must be called before the response header is written.
+// Unchanged sessions are saved at most every tenth of the idle timeout,
+// to extend their life without updating them on every request,
+// and new sessions only once values are set.
+func (m *Manager) Save(ctx context.Context, w http.ResponseWriter, s *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.destroyed {
+		if !s.isNew || s.oldID != "" {
+			http.SetCookie(w, m.cookie("", time.Unix(1, 0), -1))
+		}
+		if m.store != nil {
+			for _, id := range []string{s.id, s.oldID} {
+				if id == "" {
+					continue
+				}
+				if err := m.store.Delete(ctx, id); err != nil {
+					return fmt.Errorf("cannot delete session: %w", err)
+				}
+			}
+		}
+		return nil
+	}
+
+	now := m.now()
+	touch := !s.isNew && m.idle > 0 && now.Sub(s.rec.LastSeen) >= m.idle/10
+	if !s.dirty && !touch {
+		return nil
+	}
+	s.rec.LastSeen = now
+	s.rec.ExpiresAt = m.expiresAt(&s.rec)
+
+	var value string
+	if m.store != nil {
+		if s.id == "" || s.renew {
+			if s.id != "" {
+				s.oldID = s.id
+			}
+			id, err := newID()
+			if err != nil {
+				return err
+			}
+			s.id = id
+		}
+		if err := m.store.Save(ctx, s.id, &s.rec); err != nil {
+			return err
+		}
+		if s.oldID != "" {
+			if err := m.store.Delete(ctx, s.oldID); err != nil {
+				return fmt.Errorf("cannot delete session: %w", err)
+			}
+			s.oldID = ""
+		}
+		value = s.id
+	} else {
+		v, err := m.codec.Encode(m.name, &s.rec)
+		if err != nil {
+			return err
+		}
+		if len(v) > maxCookieLen {
+			return ErrTooLarge
+		}
+		value = v
+	}
+
+	maxAge := 0
+	if !s.rec.ExpiresAt.IsZero() {
+		maxAge = int(s.rec.ExpiresAt.Sub(now) / time.Second)
+	}
+	http.SetCookie(w, m.cookie(value, s.rec.ExpiresAt, maxAge))
+	s.dirty, s.renew, s.isNew = false, false, false
+	return nil
+}
+
+// expiresAt returns the time rec expires, by the first timeout reached,
+// or the zero time if timeouts are disabled.
+func (m *Manager) expiresAt(rec *Record) time.Time {
+	var t time.Time
+	if m.idle > 0 {
+		t = rec.LastSeen.Add(m.idle)
+	}
+	if m.absolute > 0 {
+		if abs := rec.CreatedAt.Add(m.absolute); t.IsZero() || abs.Before(t) {
+			t = abs
+		}
+	}
+	return t
+}
+
+func (m *Manager) cookie(value string, expires time.Time, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     m.name,
+		Value:    value,
+		Path:     m.path,
+		Domain:   m.domain,
+		Expires:  expires,
+		MaxAge:   maxAge,
+		Secure:   m.secure,
+		HttpOnly: true,
+		SameSite: m.sameSite,
+	}
+}
+
+// newID generates a random session ID.
+func newID() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("cannot generate session id: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b[:]), nil
+}
@@ -0,0 +1,163 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestCodec(t *testing.T, key string) *Codec {
+	c, err := NewCodec([]byte(strings.Repeat(key, MinKeyLen)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCodecRotation(t *testing.T) {
+	oldKey := []byte(strings.Repeat("o", MinKeyLen))
+	newKey := []byte(strings.Repeat("n", MinKeyLen))
+
+	old, err := NewCodec(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := old.Encode("session", map[string]string{"user": "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(v, "alice") {
+		t.Fatal("want encrypted value")
+	}
+
+	rotated, err := NewCodec(newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]string
+	if err := rotated.Decode("session", v, &got); err != nil || got["user"] != "alice" {
+		t.Fatalf("want value decoded with old key, got %v %v", got, err)
+	}
+	if err := rotated.Decode("other", v, &got); !errors.Is(err, ErrInvalidCookie) {
+		t.Errorf("want value bound to the cookie name, got %v", err)
+	}
+
+	fresh, err := NewCodec(newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fresh.Decode("session", v, &got); !errors.Is(err, ErrInvalidCookie) {
+		t.Errorf("want value rejected after key removal, got %v", err)
+	}
+	if _, err := NewCodec([]byte("short")); err == nil {
+		t.Error("want error for short key")
+	}
+}
+
+// roundTrip loads the session of a request carrying cookie,
+// lets fn use it and returns the cookie set by the response.
+func roundTrip(t *testing.T, m *Manager, cookie *http.Cookie, fn func(s *Session)) *http.Cookie {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	s, err := m.Load(context.Background(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn(s)
+	w := httptest.NewRecorder()
+	if err := m.Save(context.Background(), w, s); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) == 0 {
+		return nil
+	}
+	return cookies[0]
+}
+
+func TestManagers(t *testing.T) {
+	dir := t.TempDir()
+	fileStore, err := OpenFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	managers := map[string]func(opts ...ManagerOpt) *Manager{
+		"cookie": func(opts ...ManagerOpt) *Manager { return NewCookieManager(newTestCodec(t, "k"), opts...) },
+		"memory": func(opts ...ManagerOpt) *Manager { return NewStoreManager(NewMemoryStore(), opts...) },
+		"file":   func(opts ...ManagerOpt) *Manager { return NewStoreManager(fileStore, opts...) },
+	}
+	for name, newManager := range managers {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+			m := newManager(WithClock(func() time.Time { return now }),
+				WithIdleTimeout(time.Hour), WithAbsoluteTimeout(3*time.Hour))
+
+			if c := roundTrip(t, m, nil, func(s *Session) {}); c != nil {
+				t.Fatalf("want no cookie for unused sessions, got %v", c)
+			}
+
+			c := roundTrip(t, m, nil, func(s *Session) { s.Set("user", "alice") })
+			if c == nil || !c.HttpOnly || !c.Secure || c.MaxAge != 3600 {
+				t.Fatalf("want session cookie, got %v", c)
+			}
+
+			// Sessions are kept alive by use, until the absolute timeout.
+			for i := 0; i < 4; i++ {
+				now = now.Add(50 * time.Minute)
+				var user string
+				next := roundTrip(t, m, c, func(s *Session) { user, _ = s.Get("user") })
+				if i < 3 && user != "alice" {
+					t.Fatalf("want session alive after %d uses", i+1)
+				}
+				if i == 3 && user != "" {
+					t.Fatal("want session expired by absolute timeout")
+				}
+				if next != nil {
+					c = next
+				}
+			}
+
+			// Idle sessions expire.
+			c = roundTrip(t, m, nil, func(s *Session) { s.Set("user", "bob") })
+			now = now.Add(61 * time.Minute)
+			roundTrip(t, m, c, func(s *Session) {
+				if !s.IsNew() {
+					t.Error("want session expired by idle timeout")
+				}
+			})
+
+			// Destroyed sessions lose their cookie.
+			c = roundTrip(t, m, nil, func(s *Session) { s.Set("user", "carol") })
+			if gone := roundTrip(t, m, c, func(s *Session) { s.Destroy() }); gone == nil || gone.MaxAge >= 0 {
+				t.Fatalf("want expired cookie, got %v", gone)
+			}
+		})
+	}
+}
+
+func TestRenew(t *testing.T) {
+	store := NewMemoryStore()
+	m := NewStoreManager(store)
+
+	c := roundTrip(t, m, nil, func(s *Session) { s.Set("user", "alice") })
+	renewed := roundTrip(t, m, c, func(s *Session) { s.Renew() })
+	if renewed == nil || renewed.Value == c.Value {
+		t.Fatalf("want new session id, got %v", renewed)
+	}
+	if _, err := store.Load(context.Background(), c.Value); !errors.Is(err, ErrNotFound) {
+		t.Errorf("want old session removed, got %v", err)
+	}
+	roundTrip(t, m, renewed, func(s *Session) {
+		if v, _ := s.Get("user"); v != "alice" {
+			t.Errorf("want values kept, got %q", v)
+		}
+	})
+}
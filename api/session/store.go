@@ -0,0 +1,191 @@
+package session
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by stores for missing or expired sessions.
+var ErrNotFound = errors.New("session not found")
+
+// Store keeps server-side sessions by ID.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Load returns the record of the session, or ErrNotFound.
+	Load(ctx context.Context, id string) (*Record, error)
+
+	// Save stores the record of the session until its ExpiresAt.
+	Save(ctx context.Context, id string, rec *Record) error
+
+	// Delete removes the session, if it exists.
+	Delete(ctx context.Context, id string) error
+}
+
+// sweepEvery is the number of saves after which expired sessions are removed.
+const sweepEvery = 1024
+
+// MemoryStore is an in-memory Store.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]*Record
+	ops     int
+	now     func() time.Time
+}
+
+// NewMemoryStore constructs an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: map[string]*Record{}, now: time.Now}
+}
+
+// Load implements the Store interface.
+func (m *MemoryStore) Load(ctx context.Context, id string) (*Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.records[id]
+	if !ok || r.expired(m.now()) {
+		return nil, ErrNotFound
+	}
+	cp := r.clone()
+	return &cp, nil
+}
+
+// Save implements the Store interface.
+func (m *MemoryStore) Save(ctx context.Context, id string, rec *Record) error {
+	now := m.now()
+	cp := rec.clone()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ops++
+	if m.ops%sweepEvery == 0 {
+		for k, r := range m.records {
+			if r.expired(now) {
+				delete(m.records, k)
+			}
+		}
+	}
+	m.records[id] = &cp
+	return nil
+}
+
+// Delete implements the Store interface.
+func (m *MemoryStore) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, id)
+	return nil
+}
+
+// FileStore is a Store keeping a file for each session in a directory,
+// so that sessions survive restarts.
+type FileStore struct {
+	dir string
+	now func() time.Time
+}
+
+// OpenFileStore constructs a FileStore in dir, creating it if needed.
+func OpenFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("cannot create session store: %w", err)
+	}
+	return &FileStore{dir: dir, now: time.Now}, nil
+}
+
+// path returns the file of a session. IDs are hashed since they are sent by clients.
+func (f *FileStore) path(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(f.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Load implements the Store interface.
+func (f *FileStore) Load(ctx context.Context, id string) (*Record, error) {
+	rec, err := f.read(f.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rec.expired(f.now()) {
+		return nil, ErrNotFound
+	}
+	return rec, nil
+}
+
+// Save implements the Store interface.
+func (f *FileStore) Save(ctx context.Context, id string, rec *Record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("cannot encode session: %w", err)
+	}
+
+	// Write to a temporary file and rename it, so that the session is never half written.
+	tmp, err := os.CreateTemp(f.dir, "session.tmp*")
+	if err != nil {
+		return fmt.Errorf("cannot write session: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write session: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot write session: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), f.path(id)); err != nil {
+		return fmt.Errorf("cannot write session: %w", err)
+	}
+	return nil
+}
+
+// Delete implements the Store interface.
+func (f *FileStore) Delete(ctx context.Context, id string) error {
+	if err := os.Remove(f.path(id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("cannot remove session: %w", err)
+	}
+	return nil
+}
+
+// Sweep removes the expired sessions, and should be called periodically.
+func (f *FileStore) Sweep() error {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return fmt.Errorf("cannot read session store: %w", err)
+	}
+	now := f.now()
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(f.dir, e.Name())
+		rec, err := f.read(path)
+		if err == nil && !rec.expired(now) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("cannot remove session: %w", err)
+		}
+	}
+	return nil
+}
+
+func (f *FileStore) read(path string) (*Record, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read session: %w", err)
+	}
+	var rec Record
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return nil, fmt.Errorf("cannot decode session: %w", err)
+	}
+	return &rec, nil
+}
@@ -0,0 +1,152 @@
+// Package trace implements a minimal in-process tracer compatible with the
+// W3C Trace Context specification (https://www.w3.org/TR/trace-context/).
+//
+// Spans are propagated through the context and, once ended, are handed to a
+// pluggable Exporter. This keeps the package free of external dependencies and
+// makes traces easy to inspect in tests or to ship with an external collector.
+package trace
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const (
+	// TraceparentHeader is the header carrying the trace and parent span IDs.
+	TraceparentHeader = "Traceparent"
+
+	// TracestateHeader is the header carrying vendor specific trace data.
+	TracestateHeader = "Tracestate"
+
+	// maxTracestateLen is the maximum length of a propagated tracestate value.
+	maxTracestateLen = 512
+)
+
+// FlagSampled is the trace flag signaling that the trace is recorded.
+const FlagSampled byte = 0x01
+
+// TraceID identifies a whole trace.
+type TraceID [16]byte
+
+// IsValid reports whether the trace ID is not all zeros.
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// String returns the lowercase hex representation of the trace ID.
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+// IsValid reports whether the span ID is not all zeros.
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// String returns the lowercase hex representation of the span ID.
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// SpanContext is the part of a span that is propagated across services.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Flags   byte
+	State   string
+}
+
+// IsValid reports whether both the trace and span IDs are valid.
+func (sc SpanContext) IsValid() bool { return sc.TraceID.IsValid() && sc.SpanID.IsValid() }
+
+// IsSampled reports whether the sampled flag is set.
+func (sc SpanContext) IsSampled() bool { return sc.Flags&FlagSampled != 0 }
+
+// Traceparent formats the span context as a version 00 traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + hex.EncodeToString([]byte{sc.Flags})
+}
+
+// ErrInvalidTraceparent is returned when a traceparent header cannot be parsed.
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+// ParseTraceparent parses a traceparent header value.
+// The tracestate value is validated and, if well formed, is stored in the span context.
+func ParseTraceparent(traceparent string, tracestate string) (SpanContext, error) {
+	var sc SpanContext
+
+	// version-traceid-spanid-flags: 2+1+32+1+16+1+2 characters.
+	// Future versions may append fields, that must be ignored.
+	if len(traceparent) < 55 {
+		return sc, ErrInvalidTraceparent
+	}
+	parts := strings.Split(traceparent, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, ErrInvalidTraceparent
+	}
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, ErrInvalidTraceparent
+	}
+	for _, p := range parts[:4] {
+		if strings.ToLower(p) != p {
+			return sc, ErrInvalidTraceparent
+		}
+	}
+
+	var version, flags [1]byte
+	if _, err := hex.Decode(version[:], []byte(parts[0])); err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	if !sc.IsValid() {
+		return sc, ErrInvalidTraceparent
+	}
+	sc.Flags = flags[0]
+
+	if validTracestate(tracestate) {
+		sc.State = tracestate
+	}
+	return sc, nil
+}
+
+// validTracestate performs a lightweight validation of the tracestate list.
+// Invalid values are dropped rather than propagated.
+func validTracestate(ts string) bool {
+	if ts == "" || len(ts) > maxTracestateLen {
+		return false
+	}
+	members := strings.Split(ts, ",")
+	if len(members) > 32 {
+		return false
+	}
+	for _, m := range members {
+		m = strings.TrimSpace(m)
+		if m == "" {
+			continue
+		}
+		i := strings.IndexByte(m, '=')
+		if i <= 0 || i == len(m)-1 {
+			return false
+		}
+	}
+	return true
+}
+
+// Inject writes the traceparent and tracestate headers of the span found
+// in ctx, so that the trace can be continued by the called service.
+func Inject(ctx context.Context, h http.Header) {
+	sc := SpanFromContext(ctx).SpanContext()
+	if !sc.IsValid() {
+		return
+	}
+	h.Set(TraceparentHeader, sc.Traceparent())
+	if sc.State != "" {
+		h.Set(TracestateHeader, sc.State)
+	}
+}
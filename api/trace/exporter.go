@@ -0,0 +1,87 @@
+package trace
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// Exporter receives ended spans.
+// Implementations must be safe for concurrent use.
+type Exporter interface {
+	Export(span SpanData) error
+}
+
+// MemoryExporter keeps ended spans in memory.
+// It is mainly intended for tests.
+type MemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// NewMemoryExporter constructs an empty MemoryExporter.
+func NewMemoryExporter() *MemoryExporter {
+	return &MemoryExporter{}
+}
+
+// Export stores the span.
+func (e *MemoryExporter) Export(span SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+	return nil
+}
+
+// Spans returns a copy of the exported spans, in the order they ended.
+func (e *MemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+// Reset drops all the stored spans.
+func (e *MemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+// NDJSONExporter writes each ended span as a JSON document on its own line.
+type NDJSONExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	c   io.Closer
+}
+
+// NewNDJSONExporter constructs an exporter writing to w.
+func NewNDJSONExporter(w io.Writer) *NDJSONExporter {
+	return &NDJSONExporter{enc: json.NewEncoder(w)}
+}
+
+// OpenNDJSONFile constructs an exporter appending to the file at path,
+// which is created if missing. The exporter must be closed when done.
+func OpenNDJSONFile(path string) (*NDJSONExporter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	e := NewNDJSONExporter(f)
+	e.c = f
+	return e, nil
+}
+
+// Export writes the span.
+func (e *NDJSONExporter) Export(span SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.enc.Encode(span)
+}
+
+// Close closes the underlying file, if any.
+func (e *NDJSONExporter) Close() error {
+	if e.c == nil {
+		return nil
+	}
+	return e.c.Close()
+}
@@ -0,0 +1,229 @@
+package trace
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SpanKind describes the relationship between a span and its parent.
+type SpanKind string
+
+const (
+	SpanKindInternal SpanKind = "internal"
+	SpanKindServer   SpanKind = "server"
+	SpanKindClient   SpanKind = "client"
+)
+
+// Status is the outcome of the operation represented by a span.
+type Status string
+
+const (
+	StatusUnset Status = "unset"
+	StatusOK    Status = "ok"
+	StatusError Status = "error"
+)
+
+// Event is a time stamped annotation of a span.
+type Event struct {
+	Name       string                 `json:"name"`
+	Time       time.Time              `json:"time"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// SpanData is the snapshot of an ended span that is handed to exporters.
+type SpanData struct {
+	Name          string                 `json:"name"`
+	Kind          SpanKind               `json:"kind"`
+	Service       string                 `json:"service,omitempty"`
+	TraceID       string                 `json:"trace_id"`
+	SpanID        string                 `json:"span_id"`
+	ParentID      string                 `json:"parent_id,omitempty"`
+	Start         time.Time              `json:"start"`
+	End           time.Time              `json:"end"`
+	Status        Status                 `json:"status"`
+	StatusMessage string                 `json:"status_message,omitempty"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"`
+	Events        []Event                `json:"events,omitempty"`
+}
+
+// Span represents a single operation within a trace.
+// All the methods are safe for concurrent use and can be called on a nil span,
+// so that code can be instrumented without checking whether tracing is enabled.
+type Span struct {
+	mu     sync.Mutex
+	tracer *Tracer
+	sc     SpanContext
+	parent SpanID
+	name   string
+	kind   SpanKind
+	start  time.Time
+	end    time.Time
+	attrs  map[string]interface{}
+	events []Event
+	status Status
+	msg    string
+	ended  bool
+}
+
+// SpanContext returns the propagated part of the span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// TraceID returns the trace ID of the span, or an empty string for a nil span.
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return s.sc.TraceID.String()
+}
+
+// SetName changes the name of the span.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+// SetAttribute sets a key value pair on the span.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs[key] = value
+}
+
+// SetAttributes sets all the given key value pairs on the span.
+func (s *Span) SetAttributes(attrs map[string]interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, v := range attrs {
+		s.attrs[k] = v
+	}
+}
+
+// AddEvent annotates the span with a named event.
+func (s *Span) AddEvent(name string, attrs map[string]interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, Event{Name: name, Time: s.tracer.now(), Attributes: attrs})
+}
+
+// SetStatus sets the status of the span.
+// An error status is never overwritten by an ok status.
+func (s *Span) SetStatus(status Status, msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status == StatusError && status != StatusError {
+		return
+	}
+	s.status = status
+	s.msg = msg
+}
+
+// RecordError marks the span as failed and records the error as an event.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.AddEvent("exception", map[string]interface{}{"message": err.Error()})
+	s.SetStatus(StatusError, err.Error())
+}
+
+// End completes the span and exports it, if sampled.
+// Calls following the first one are ignored.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = s.tracer.now()
+	data := s.data()
+	s.mu.Unlock()
+
+	if s.sc.IsSampled() {
+		s.tracer.export(data)
+	}
+}
+
+// data builds the snapshot of the span. It must be called with the lock held.
+func (s *Span) data() SpanData {
+	d := SpanData{
+		Name:          s.name,
+		Kind:          s.kind,
+		Service:       s.tracer.service,
+		TraceID:       s.sc.TraceID.String(),
+		SpanID:        s.sc.SpanID.String(),
+		Start:         s.start,
+		End:           s.end,
+		Status:        s.status,
+		StatusMessage: s.msg,
+		Attributes:    make(map[string]interface{}, len(s.attrs)),
+		Events:        append([]Event(nil), s.events...),
+	}
+	if s.parent.IsValid() {
+		d.ParentID = s.parent.String()
+	}
+	for k, v := range s.attrs {
+		d.Attributes[k] = v
+	}
+	return d
+}
+
+// spanKeyCtx is the private type used to store spans in the context.
+type spanKeyCtx int
+
+const (
+	spanKey spanKeyCtx = iota
+	remoteKey
+)
+
+// ContextWithSpan returns a copy of ctx carrying the span.
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, spanKey, s)
+}
+
+// SpanFromContext returns the current span, or nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey).(*Span)
+	return s
+}
+
+// ContextWithRemoteParent returns a copy of ctx carrying a span context
+// received from another service, to be used as parent by the next span.
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey, sc)
+}
+
+// Start starts a child of the span found in ctx, using the same tracer.
+// If ctx carries no span, it returns ctx unchanged and a nil span.
+func Start(ctx context.Context, name string, opts ...SpanOpt) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, opts...)
+}
@@ -0,0 +1,72 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name  string
+		tp    string
+		valid bool
+	}{
+		{"valid", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"future version with extra field", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-xyz", true},
+		{"invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"zero span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"uppercase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"extra field in version 00", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-xyz", false},
+		{"short", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.tp, "")
+			if (err == nil) != tt.valid {
+				t.Fatalf("want valid %v, got error %v", tt.valid, err)
+			}
+			if tt.valid && sc.Traceparent() != tt.tp[:55] && tt.tp[:2] == "00" {
+				t.Errorf("want round trip %q, got %q", tt.tp, sc.Traceparent())
+			}
+		})
+	}
+}
+
+func TestTracerStart(t *testing.T) {
+	exp := NewMemoryExporter()
+	tracer := NewTracer(exp, WithIDSource(bytes.NewReader(bytes.Repeat([]byte{0x11}, 64))))
+
+	remote, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "vendor=value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := ContextWithRemoteParent(context.Background(), remote)
+
+	ctx, server := tracer.Start(ctx, "server", WithSpanKind(SpanKindServer))
+	_, child := Start(ctx, "child")
+	child.RecordError(errors.New("boom"))
+	child.End()
+	server.End()
+	server.End()
+
+	spans := exp.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("want 2 exported spans, got %d", len(spans))
+	}
+	c, s := spans[0], spans[1]
+	if s.TraceID != remote.TraceID.String() || s.ParentID != remote.SpanID.String() {
+		t.Errorf("server span should continue the remote trace, got %+v", s)
+	}
+	if c.TraceID != s.TraceID || c.ParentID != s.SpanID {
+		t.Errorf("child span should be a child of the server span, got %+v", c)
+	}
+	if c.Status != StatusError || c.StatusMessage != "boom" {
+		t.Errorf("want child with error status, got %q %q", c.Status, c.StatusMessage)
+	}
+	if server.SpanContext().State != "vendor=value" {
+		t.Errorf("tracestate should be propagated, got %q", server.SpanContext().State)
+	}
+}
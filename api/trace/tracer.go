@@ -0,0 +1,151 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"sync"
+	"time"
+)
+
+// Tracer creates spans and hands them to an exporter once ended.
+type Tracer struct {
+	exp     Exporter
+	service string
+	now     func() time.Time
+	onErr   func(error)
+
+	mu      sync.Mutex
+	entropy io.Reader
+}
+
+// TracerOpt defines the type for Tracer options.
+type TracerOpt func(*Tracer)
+
+// WithServiceName returns an option that sets the service name reported by spans.
+func WithServiceName(name string) TracerOpt {
+	return func(t *Tracer) {
+		t.service = name
+	}
+}
+
+// WithIDSource returns an option that sets the source of randomness
+// used to generate trace and span IDs. Useful to get deterministic IDs in tests.
+func WithIDSource(r io.Reader) TracerOpt {
+	return func(t *Tracer) {
+		t.entropy = r
+	}
+}
+
+// WithClock returns an option that sets the function used to timestamp spans.
+func WithClock(now func() time.Time) TracerOpt {
+	return func(t *Tracer) {
+		t.now = now
+	}
+}
+
+// WithErrorHandler returns an option that sets the function called
+// when the exporter fails. Errors are ignored by default.
+func WithErrorHandler(fn func(error)) TracerOpt {
+	return func(t *Tracer) {
+		t.onErr = fn
+	}
+}
+
+// NewTracer constructs a tracer exporting spans to exp.
+func NewTracer(exp Exporter, opts ...TracerOpt) *Tracer {
+	t := &Tracer{
+		exp:     exp,
+		now:     time.Now,
+		onErr:   func(error) {},
+		entropy: rand.Reader,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// spanConfig contains the settings of a span being started.
+type spanConfig struct {
+	kind  SpanKind
+	attrs map[string]interface{}
+}
+
+// SpanOpt defines the type for span options.
+type SpanOpt func(*spanConfig)
+
+// WithSpanKind returns an option that sets the kind of the span.
+func WithSpanKind(kind SpanKind) SpanOpt {
+	return func(c *spanConfig) {
+		c.kind = kind
+	}
+}
+
+// WithAttributes returns an option that sets the initial attributes of the span.
+func WithAttributes(attrs map[string]interface{}) SpanOpt {
+	return func(c *spanConfig) {
+		for k, v := range attrs {
+			c.attrs[k] = v
+		}
+	}
+}
+
+// Start creates a new span and returns a copy of ctx carrying it.
+// The span is the child of the span in ctx or, if missing, of the remote
+// parent in ctx. Without any parent a new sampled trace is started.
+func (t *Tracer) Start(ctx context.Context, name string, opts ...SpanOpt) (context.Context, *Span) {
+	cfg := spanConfig{kind: SpanKindInternal, attrs: map[string]interface{}{}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	s := &Span{
+		tracer: t,
+		name:   name,
+		kind:   cfg.kind,
+		start:  t.now(),
+		attrs:  cfg.attrs,
+		status: StatusUnset,
+	}
+
+	var parent SpanContext
+	if p := SpanFromContext(ctx); p != nil {
+		parent = p.SpanContext()
+	} else if p, ok := ctx.Value(remoteKey).(SpanContext); ok {
+		parent = p
+	}
+
+	if parent.IsValid() {
+		s.sc = SpanContext{TraceID: parent.TraceID, Flags: parent.Flags, State: parent.State}
+		s.parent = parent.SpanID
+	} else {
+		t.read(s.sc.TraceID[:])
+		s.sc.Flags = FlagSampled
+	}
+	t.read(s.sc.SpanID[:])
+
+	return ContextWithSpan(ctx, s), s
+}
+
+// read fills b with random bytes, never returning an all zeros ID.
+func (t *Tracer) read(b []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, _ = io.ReadFull(t.entropy, b)
+	for _, c := range b {
+		if c != 0 {
+			return
+		}
+	}
+	b[len(b)-1] = 1
+}
+
+func (t *Tracer) export(d SpanData) {
+	if t.exp == nil {
+		return
+	}
+	if err := t.exp.Export(d); err != nil {
+		t.onErr(err)
+	}
+}
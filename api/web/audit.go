@@ -0,0 +1,35 @@
+package web
+
+import (
+	"context"
+
+	"github.com/polldo/patweb/api/audit"
+)
+
+// AuditRecorder collects the audit events recorded during a request.
+type AuditRecorder interface {
+	RecordAudit(e audit.Event)
+}
+
+// auditKeyCtx is the private type used to store the audit recorder in the context.
+type auditKeyCtx int
+
+// auditKey is the context key used to store the audit recorder.
+const auditKey auditKeyCtx = 1
+
+// ContextWithAuditRecorder returns a copy of ctx carrying rec, used by Audit.
+func ContextWithAuditRecorder(ctx context.Context, rec AuditRecorder) context.Context {
+	return context.WithValue(ctx, auditKey, rec)
+}
+
+// Audit records an audit event for the request of ctx. The request ID, the
+// outcome and, if empty, the actor are filled in when the request completes.
+// It returns false if the request is not audited.
+func Audit(ctx context.Context, e audit.Event) bool {
+	rec, ok := ctx.Value(auditKey).(AuditRecorder)
+	if !ok {
+		return false
+	}
+	rec.RecordAudit(e)
+	return true
+}
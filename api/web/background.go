@@ -0,0 +1,137 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrShutdown is returned when a goroutine is started after the shutdown
+// of its Background.
+var ErrShutdown = errors.New("background is shutting down")
+
+// backgroundKeyCtx is the private type used to store the Background in the context.
+type backgroundKeyCtx int
+
+// backgroundKey is the context key used to store the Background.
+const backgroundKey backgroundKeyCtx = 1
+
+// Background runs the goroutines spawned by handlers, recovering their panics
+// and tracking them so that they can be awaited on shutdown.
+type Background struct {
+	log    logrus.FieldLogger
+	fields func(ctx context.Context) logrus.Fields
+
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	closed bool
+}
+
+// BackgroundOpt defines the type for Background options.
+type BackgroundOpt func(*Background)
+
+// WithLogFields returns an option that sets a function extracting, from the
+// context of the spawning request, the fields used to log panics.
+func WithLogFields(fields func(ctx context.Context) logrus.Fields) BackgroundOpt {
+	return func(b *Background) {
+		b.fields = fields
+	}
+}
+
+// NewBackground constructs a Background logging panics to log.
+func NewBackground(log logrus.FieldLogger, opts ...BackgroundOpt) *Background {
+	b := &Background{log: log}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Go runs fn in a new goroutine. fn receives a context with the values of ctx
+// but without its deadline and cancellation, since the goroutine usually
+// outlives the request. A panic in fn is logged instead of crashing the process.
+func (b *Background) Go(ctx context.Context, fn func(ctx context.Context)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrShutdown
+	}
+	b.wg.Add(1)
+
+	ctx = Detach(ctx)
+	go func() {
+		defer b.wg.Done()
+		defer b.recover(ctx)
+		fn(ctx)
+	}()
+	return nil
+}
+
+// recover logs the panic of a background goroutine, if any.
+func (b *Background) recover(ctx context.Context) {
+	rec := recover()
+	if rec == nil {
+		return
+	}
+	log := b.log
+	if b.fields != nil {
+		log = log.WithFields(b.fields(ctx))
+	}
+	log.WithFields(NewPanicError(rec).Fields()).Error("PANIC in background goroutine")
+}
+
+// Shutdown stops accepting new goroutines and waits for the running ones
+// to complete, or for ctx to be done.
+func (b *Background) Shutdown(ctx context.Context) error {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ContextWithBackground returns a copy of ctx carrying b, used by Go.
+func ContextWithBackground(ctx context.Context, b *Background) context.Context {
+	return context.WithValue(ctx, backgroundKey, b)
+}
+
+// Go runs fn in a new goroutine through the Background found in ctx.
+// Without a Background, panics are logged to the standard logger and the
+// goroutine is not awaited on shutdown.
+func Go(ctx context.Context, fn func(ctx context.Context)) error {
+	b, ok := ctx.Value(backgroundKey).(*Background)
+	if !ok {
+		b = NewBackground(logrus.StandardLogger())
+	}
+	return b.Go(ctx, fn)
+}
+
+// Detach returns a context with the values of ctx, that is never canceled
+// and has no deadline.
+func Detach(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
+
+// detachedContext hides the cancellation of its parent, exposing its values.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
@@ -0,0 +1,67 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type ctxKey int
+
+func TestBackground(t *testing.T) {
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(&buf)
+	b := NewBackground(log, WithLogFields(func(ctx context.Context) logrus.Fields {
+		return logrus.Fields{"req_id": ctx.Value(ctxKey(1))}
+	}))
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey(1), "req-42"))
+	ctx = ContextWithBackground(ctx, b)
+	cancel()
+
+	release := make(chan struct{})
+	var got string
+	err := Go(ctx, func(ctx context.Context) {
+		<-release
+		if ctx.Err() != nil {
+			got = "canceled"
+			return
+		}
+		got, _ = ctx.Value(ctxKey(1)).(string)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Go(ctx, func(ctx context.Context) { panic("boom") }); err != nil {
+		t.Fatal(err)
+	}
+
+	// Shutdown must wait for the running goroutines.
+	sctx, scancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer scancel()
+	if err := b.Shutdown(sctx); err != context.DeadlineExceeded {
+		t.Fatalf("want shutdown to wait for goroutines, got %v", err)
+	}
+	close(release)
+	if err := b.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got != "req-42" {
+		t.Errorf("want request values without cancellation, got %q", got)
+	}
+	if out := buf.String(); !strings.Contains(out, "boom") || !strings.Contains(out, "req-42") {
+		t.Errorf("want panic logged with request id, got %q", out)
+	}
+	if out := buf.String(); !strings.Contains(out, "background_test.go") || strings.Contains(out, "runtime/debug") {
+		t.Errorf("want stack frames from the panic on, got %q", out)
+	}
+	if err := Go(ctx, func(ctx context.Context) {}); err != ErrShutdown {
+		t.Errorf("want ErrShutdown after shutdown, got %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package web
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/polldo/patweb/api/weberr"
+)
+
+// ErrPreconditionFailed is returned by CheckPreconditions when the
+// preconditions of a request don't hold.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// requestKeyCtx is the private type used to store the request in the context.
+type requestKeyCtx int
+
+// requestKey is the context key used to store the request.
+const requestKey requestKeyCtx = 1
+
+// ContextWithRequest returns a copy of ctx carrying r, whose conditional
+// headers are evaluated by Respond and CheckPreconditions.
+func ContextWithRequest(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, requestKey, r)
+}
+
+func requestFromContext(ctx context.Context) (*http.Request, bool) {
+	r, ok := ctx.Value(requestKey).(*http.Request)
+	return r, ok
+}
+
+// respondConfig contains the validators of a response.
+type respondConfig struct {
+	etag         string
+	hash         bool
+	lastModified time.Time
+}
+
+// RespondOpt defines the type for Respond options.
+type RespondOpt func(*respondConfig)
+
+// WithETag returns an option that sets the ETag of the response to a version
+// supplied by the handler. The version is quoted if it isn't already.
+func WithETag(version string) RespondOpt {
+	return func(c *respondConfig) {
+		c.etag = formatETag(version)
+	}
+}
+
+// WithHashETag returns an option that sets the ETag of the response
+// to a strong hash of the marshalled body.
+func WithHashETag() RespondOpt {
+	return func(c *respondConfig) {
+		c.hash = true
+	}
+}
+
+// WithLastModified returns an option that sets the Last-Modified header.
+func WithLastModified(t time.Time) RespondOpt {
+	return func(c *respondConfig) {
+		c.lastModified = t
+	}
+}
+
+// formatETag quotes the version, unless it's already a valid entity tag.
+func formatETag(version string) string {
+	if strings.HasPrefix(version, `"`) || strings.HasPrefix(version, `W/"`) {
+		return version
+	}
+	return `"` + version + `"`
+}
+
+// etagCodings are the content codings that can suffix an entity tag.
+var etagCodings = []string{"gzip", "deflate"}
+
+// ETagWithCoding returns the strong entity tag of the representation of a
+// response encoded with coding, like '"v1-gzip"' for '"v1"', since a strong
+// tag can't be shared by different encodings. Weak tags are returned as
+// they are. The preconditions evaluated by this package ignore the suffix.
+func ETagWithCoding(etag, coding string) string {
+	if !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) || len(etag) < 2 {
+		return etag
+	}
+	return etag[:len(etag)-1] + "-" + coding + `"`
+}
+
+// trimCoding removes from etag the suffix added by ETagWithCoding, if any.
+func trimCoding(etag string) string {
+	for _, c := range etagCodings {
+		if s := "-" + c + `"`; strings.HasSuffix(etag, s) {
+			return etag[:len(etag)-len(s)] + `"`
+		}
+	}
+	return etag
+}
+
+// hashETag returns a strong entity tag for body.
+func hashETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:]) + `"`
+}
+
+// NotModified evaluates If-None-Match and If-Modified-Since for a GET or HEAD
+// request of a resource with the given validators, as in RFC 9110.
+func NotModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etag != "" && matchETag(inm, etag, false)
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		return err == nil && !lastModified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// matchETag reports whether etag matches one of the tags in the list of an
+// If-Match or If-None-Match header. Weak tags never match strongly.
+// Tags of encoded representations match the tag of the resource.
+func matchETag(list, etag string, strong bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return etag != ""
+	}
+	if strong && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimSpace(tag)
+		if strong && strings.HasPrefix(tag, "W/") {
+			continue
+		}
+		if trimCoding(strings.TrimPrefix(tag, "W/")) == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckPreconditions evaluates the If-Match and If-Unmodified-Since headers
+// of the request in ctx against the current validators of the resource,
+// that are empty if the resource doesn't exist. It returns a quiet 412 error
+// matching ErrPreconditionFailed if the preconditions don't hold, so that
+// handlers can reject writes based on a stale version of the resource.
+func CheckPreconditions(ctx context.Context, etag string, lastModified time.Time) error {
+	r, ok := requestFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	failed := false
+	if im := r.Header.Get("If-Match"); im != "" {
+		failed = !matchETag(im, etag, true)
+	} else if ius := r.Header.Get("If-Unmodified-Since"); ius != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ius)
+		failed = err == nil && lastModified.Truncate(time.Second).After(t)
+	}
+	if !failed {
+		return nil
+	}
+
+	status := http.StatusPreconditionFailed
+	return weberr.Wrap(ErrPreconditionFailed,
+		weberr.WithResponse(&ErrorResponse{Error: "the resource has been modified", Status: http.StatusText(status)}, status),
+		weberr.WithFields(map[string]interface{}{"etag": etag}),
+		weberr.WithQuiet(true),
+	)
+}
@@ -0,0 +1,94 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/polldo/patweb/api/weberr"
+)
+
+func TestRespondConditional(t *testing.T) {
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	data := map[string]string{"name": "order"}
+
+	respond := func(header, value string, opts ...RespondOpt) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		if err := Respond(ContextWithRequest(r.Context(), r), w, data, http.StatusOK, opts...); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	etag := respond("", "", WithHashETag()).Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("want ETag header")
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		opts   []RespondOpt
+		status int
+	}{
+		{"matching hash", "If-None-Match", etag, []RespondOpt{WithHashETag()}, http.StatusNotModified},
+		{"weak match", "If-None-Match", `"x", W/` + etag, []RespondOpt{WithHashETag()}, http.StatusNotModified},
+		{"stale hash", "If-None-Match", `"old"`, []RespondOpt{WithHashETag()}, http.StatusOK},
+		{"version", "If-None-Match", `"v3"`, []RespondOpt{WithETag("v3")}, http.StatusNotModified},
+		{"not modified since", "If-Modified-Since", modified.Format(http.TimeFormat), []RespondOpt{WithLastModified(modified)}, http.StatusNotModified},
+		{"modified since", "If-Modified-Since", modified.Add(-time.Hour).Format(http.TimeFormat), []RespondOpt{WithLastModified(modified)}, http.StatusOK},
+		{"no validators", "If-None-Match", "*", nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := respond(tt.header, tt.value, tt.opts...)
+			if w.Code != tt.status {
+				t.Fatalf("want status %d, got %d", tt.status, w.Code)
+			}
+			if tt.status == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Errorf("want no body in 304 response, got %q", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	tests := []struct {
+		ifMatch string
+		etag    string
+		ok      bool
+	}{
+		{"", `"v1"`, true},
+		{`"v1"`, `"v1"`, true},
+		{`"v0", "v1"`, `"v1"`, true},
+		{`"v0"`, `"v1"`, false},
+		{`W/"v1"`, `"v1"`, false},
+		{`"v1-gzip"`, `"v1"`, true},
+		{`W/"v1-gzip"`, `"v1"`, false},
+		{"*", `"v1"`, true},
+		{"*", "", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPut, "/", nil)
+		if tt.ifMatch != "" {
+			r.Header.Set("If-Match", tt.ifMatch)
+		}
+		err := CheckPreconditions(ContextWithRequest(r.Context(), r), tt.etag, time.Time{})
+		if tt.ok {
+			if err != nil {
+				t.Errorf("If-Match %s on %s: unexpected error %v", tt.ifMatch, tt.etag, err)
+			}
+			continue
+		}
+		if _, status, _ := weberr.Response(err); !errors.Is(err, ErrPreconditionFailed) || status != http.StatusPreconditionFailed {
+			t.Errorf("If-Match %s on %s: want 412 error, got %v", tt.ifMatch, tt.etag, err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strconv"
+)
+
+// StackFrame is a frame of the stack of a panicking goroutine.
+type StackFrame struct {
+	Func string `json:"func"`
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// String formats the frame as 'func file:line'.
+func (f StackFrame) String() string {
+	return f.Func + " " + f.File + ":" + strconv.Itoa(f.Line)
+}
+
+// PanicError is the error a panic is converted to, by the Panics middleware
+// and by Background. It carries the panic value, the stack and the goroutine
+// ID as fields to be logged, while its response only reveals a generic 500 error.
+type PanicError struct {
+	Value     interface{}
+	Stack     []StackFrame
+	Goroutine int64
+}
+
+// Error implements the error interface.
+func (e *PanicError) Error() string { return fmt.Sprintf("PANIC [%v]", e.Value) }
+
+// Unwrap returns the panic value, if it's an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Fields implements the 'Fields' behavior of weberr.
+func (e *PanicError) Fields() map[string]interface{} {
+	stack := make([]string, len(e.Stack))
+	for i, f := range e.Stack {
+		stack[i] = f.String()
+	}
+	return map[string]interface{}{
+		"panic":     fmt.Sprint(e.Value),
+		"stack":     stack,
+		"goroutine": e.Goroutine,
+	}
+}
+
+// Response implements the 'Response' behavior of weberr.
+// Details of the panic are never sent to clients.
+func (e *PanicError) Response() (interface{}, int) {
+	status := http.StatusInternalServerError
+	return &ErrorResponse{Error: http.StatusText(status), Status: http.StatusText(status)}, status
+}
+
+// NewPanicError captures the stack of the current goroutine,
+// that must be recovering from a panic.
+func NewPanicError(value interface{}) *PanicError {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	// Skip the frames of the recovery, up to the panic call.
+	var all, stack []StackFrame
+	panicked := false
+	for {
+		f, more := frames.Next()
+		sf := StackFrame{Func: f.Function, File: f.File, Line: f.Line}
+		all = append(all, sf)
+		if panicked {
+			stack = append(stack, sf)
+		}
+		if f.Function == "runtime.gopanic" {
+			panicked = true
+		}
+		if !more {
+			break
+		}
+	}
+	if !panicked {
+		stack = all
+	}
+	return &PanicError{Value: value, Stack: stack, Goroutine: goroutineID()}
+}
+
+// goroutineID parses the ID of the current goroutine from its stack header,
+// like 'goroutine 18 [running]:'.
+func goroutineID() int64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseInt(string(b), 10, 64)
+	return id
+}
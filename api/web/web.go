@@ -37,8 +37,26 @@ func WrapMiddleware(mw []Middleware, handler Handler) Handler {
 	return handler
 }
 
+// ErrorResponse is the body of the errors generated by this package and by
+// the middlewares. It has the same shape of the responses built by handlers:
+// { "status": "Precondition Failed", "error": "some error message" } ,
+// plus the code of the errors having one.
+type ErrorResponse struct {
+	Error  string `json:"error"`
+	Status string `json:"status"`
+	Code   string `json:"code,omitempty"`
+}
+
 // Respond converts a Go value to JSON and sends it to the client.
-func Respond(ctx context.Context, w http.ResponseWriter, data interface{}, statusCode int) error {
+//
+// Options can set the validators of the response, the ETag and Last-Modified
+// headers. Then, if the request in ctx has an If-None-Match or If-Modified-Since
+// header matching them, a 304 response without body is sent instead.
+func Respond(ctx context.Context, w http.ResponseWriter, data interface{}, statusCode int, opts ...RespondOpt) error {
+	var cfg respondConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 
 	// If there is nothing to marshal then set status code and return.
 	if statusCode == http.StatusNoContent {
@@ -52,6 +70,21 @@ func Respond(ctx context.Context, w http.ResponseWriter, data interface{}, statu
 		return fmt.Errorf("cannot marshal response data: %w", err)
 	}
 
+	// Set the validators and check whether the client already has the response.
+	if cfg.hash {
+		cfg.etag = hashETag(jsonData)
+	}
+	if cfg.etag != "" {
+		w.Header().Set("ETag", cfg.etag)
+	}
+	if !cfg.lastModified.IsZero() {
+		w.Header().Set("Last-Modified", cfg.lastModified.UTC().Format(http.TimeFormat))
+	}
+	if r, ok := requestFromContext(ctx); ok && statusCode == http.StatusOK && NotModified(r, cfg.etag, cfg.lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
 	// Set the content type and headers once we know marshaling has succeeded.
 	w.Header().Set("Content-Type", "application/json")
 
@@ -0,0 +1,35 @@
+package weberr
+
+import "errors"
+
+type coder interface {
+	Code() string
+}
+
+// Code extracts a machine readable code from the error, if possible,
+// like 'csrf_token_missing', so that clients can handle errors without
+// parsing their messages. An error has a code if it implements the interface:
+//
+//	type coder interface {
+//	     Code() string
+//	}
+//
+// If the error does not implement 'Code' behavior, it returns
+// 'ok' to false and other parameters should be ignored.
+func Code(err error) (code string, ok bool) {
+	var ce coder
+	if errors.As(err, &ce) {
+		return ce.Code(), true
+	}
+	return "", false
+}
+
+// codeError wraps an error adding the 'Code' behavior to it.
+type codeError struct {
+	error
+	code string
+}
+
+func (e *codeError) Code() string { return e.code }
+
+func (e *codeError) Unwrap() error { return e.error }
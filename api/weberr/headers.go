@@ -0,0 +1,35 @@
+package weberr
+
+import (
+	"errors"
+	"net/http"
+)
+
+type headerer interface {
+	Headers() http.Header
+}
+
+// Headers extracts the headers to be set in the response of the error, if possible.
+// An error has headers if it implements the interface:
+//     type headerer interface {
+//          Headers() http.Header
+//     }
+// If the error does not implement 'Headers' behavior, it returns
+// 'ok' to false and other parameters should be ignored.
+func Headers(err error) (headers http.Header, ok bool) {
+	var he headerer
+	if errors.As(err, &he) {
+		return he.Headers(), true
+	}
+	return nil, false
+}
+
+// headersError wraps an error adding the 'Headers' behavior to it.
+type headersError struct {
+	error
+	headers http.Header
+}
+
+func (e *headersError) Headers() http.Header { return e.headers }
+
+func (e *headersError) Unwrap() error { return e.error }
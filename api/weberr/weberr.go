@@ -8,6 +8,8 @@
 // is that behaviors of wrapped errors are implicitly propagated.
 package weberr
 
+import "net/http"
+
 type Opt func(error) error
 
 // Wrap allows to assign behaviors to an error.
@@ -44,6 +46,22 @@ func WithQuiet(quiet bool) Opt {
 	}
 }
 
+// WithHeaders returns a functional option that
+// adds the 'Headers' behavior to the error.
+func WithHeaders(headers http.Header) Opt {
+	return func(err error) error {
+		return &headersError{error: err, headers: headers}
+	}
+}
+
+// WithCode returns a functional option that
+// adds the 'Code' behavior to the error.
+func WithCode(code string) Opt {
+	return func(err error) error {
+		return &codeError{error: err, code: code}
+	}
+}
+
 // WithMask returns a functional option that
 // masks all the behaviors of the error.
 func WithMask(quiet bool) Opt {
@@ -37,3 +37,13 @@ func TestWrap(t *testing.T) {
 	}
 	handler()
 }
+
+func TestCode(t *testing.T) {
+	err := fmt.Errorf("cannot check token: %w", Wrap(errors.New("missing token"), WithCode("token_missing")))
+	if code, ok := Code(err); !ok || code != "token_missing" {
+		t.Errorf("want code token_missing, got %q %v", code, ok)
+	}
+	if _, ok := Code(errors.New("plain")); ok {
+		t.Error("want no code for plain errors")
+	}
+}
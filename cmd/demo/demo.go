@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -9,6 +10,7 @@ import (
 	"time"
 
 	"github.com/polldo/patweb/api"
+	"github.com/polldo/patweb/api/web"
 
 	"github.com/sirupsen/logrus"
 )
@@ -21,22 +23,39 @@ func main() {
 	defer log.Info("demo complete")
 
 	addr := "localhost:33888"
-	go serve(log.WithField("app", "Server"), addr)
+	slog := log.WithField("app", "Server")
+	bg := api.NewBackground(slog)
+	srv := server(slog, addr, bg)
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			slog.Error(err)
+		}
+	}()
 	consume(log.WithField("app", "Consumer"), addr)
+
+	// Stop the server, then wait for the goroutines spawned by handlers.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Errorf("cannot shutdown server: %v", err)
+	}
+	if err := bg.Shutdown(ctx); err != nil {
+		slog.Errorf("cannot await background goroutines: %v", err)
+	}
 }
 
-func serve(log logrus.FieldLogger, addr string) {
+func server(log logrus.FieldLogger, addr string, bg *web.Background) *http.Server {
 	// Construct the mux for the API calls.
 	mux := api.APIMux(api.APIConfig{
-		Log: log,
+		Log:        log,
+		Background: bg,
 	})
 
 	// Construct a server to service the requests against the mux.
-	api := http.Server{
+	return &http.Server{
 		Addr:    addr,
 		Handler: mux,
 	}
-	log.Error(api.ListenAndServe())
 }
 
 func consume(log logrus.FieldLogger, host string) {